@@ -21,9 +21,10 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/urfave/cli/v2"
-	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/osutils"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/build"
@@ -45,7 +46,7 @@ var buildCmd = &cli.Command{
 		&cli.StringFlag{
 			Name:    "package",
 			Aliases: []string{"p"},
-			Usage:   "Name of the package to build and its repo (example: default/go-bin)",
+			Usage:   "Name or path of the package to build and its repo (example: default/go-bin)",
 		},
 		&cli.BoolFlag{
 			Name:    "clean",
@@ -57,14 +58,28 @@ var buildCmd = &cli.Command{
 		ctx := c.Context
 		log := loggerctx.From(ctx)
 
+		err := repos.AutoPull(ctx)
+		if err != nil {
+			log.Fatal("Error pulling repositories").Err(err).Send()
+		}
+
 		script := c.String("script")
 		if c.String("package") != "" {
-			script = filepath.Join(config.GetPaths(ctx).RepoDir, c.String("package"), "lure.sh")
-		}
+			repoName, pkgName, ok := strings.Cut(c.String("package"), "/")
+			if !ok {
+				log.Fatal("Package must be specified as repo/package").Str("package", c.String("package")).Send()
+			}
 
-		err := repos.Pull(ctx, config.Config(ctx).Repos)
-		if err != nil {
-			log.Fatal("Error pulling repositories").Err(err).Send()
+			dbLock, err := lock.Shared(ctx, lock.DB)
+			if err != nil {
+				log.Fatal("Error acquiring lock").Err(err).Send()
+			}
+
+			script, err = repos.ScriptPath(ctx, repoName, pkgName)
+			dbLock.Release()
+			if err != nil {
+				log.Fatal("Error getting build script").Err(err).Send()
+			}
 		}
 
 		mgr := manager.Detect()
@@ -0,0 +1,238 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/dl"
+	"lure.sh/lure/pkg/build"
+	"lure.sh/lure/pkg/loggerctx"
+	"lure.sh/lure/pkg/manager"
+)
+
+var cacheCmd = &cli.Command{
+	Name:  "cache",
+	Usage: "Manage the download cache",
+	Subcommands: []*cli.Command{
+		cacheListCmd,
+		cacheRemoveCmd,
+		cacheGCCmd,
+		cacheImportCmd,
+	},
+}
+
+var cacheListCmd = &cli.Command{
+	Name:    "list",
+	Usage:   "List the sources in the download cache, least recently used first",
+	Aliases: []string{"ls"},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		entries, err := dl.ListCache(ctx)
+		if err != nil {
+			log.Fatal("Error reading download cache").Err(err).Send()
+		}
+
+		var total int64
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tSIZE\tLAST USED\tPACKAGES\tURL")
+
+		for _, entry := range entries {
+			total += entry.Size
+			lastUsed := time.Since(entry.LastUsed).Round(time.Second).String() + " ago"
+			pkgs := valueOr(strings.Join(entry.Packages, ","), "-")
+			// Blobs in the content-addressed store are shown by their digest
+			source := valueOr(entry.URL, valueOr(entry.Digest, "-"))
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Type, formatSize(entry.Size), lastUsed, pkgs, source)
+		}
+
+		err = tw.Flush()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%d entries, %s\n", len(entries), formatSize(total))
+		return nil
+	},
+}
+
+var cacheRemoveCmd = &cli.Command{
+	Name:      "rm",
+	Usage:     "Remove the sources of packages or the sources with the given URLs from the download cache",
+	ArgsUsage: "<package|url...>",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		args := c.Args().Slice()
+		if len(args) == 0 {
+			log.Fatalf("Command rm expected at least 1 argument, got %d", len(args)).Send()
+		}
+
+		entries, err := dl.ListCache(ctx)
+		if err != nil {
+			log.Fatal("Error reading download cache").Err(err).Send()
+		}
+
+		var remove []dl.CacheEntry
+		for _, arg := range args {
+			found := false
+			for _, entry := range entries {
+				if entry.Matches(arg) {
+					remove = append(remove, entry)
+					found = true
+				}
+			}
+
+			if !found {
+				log.Fatal("No cached sources found").Str("name", arg).Send()
+			}
+		}
+
+		err = dl.RemoveCacheEntries(ctx, remove)
+		if err != nil {
+			log.Fatal("Error removing cached sources").Err(err).Send()
+		}
+
+		log.Info("Removed cached sources").Int("entries", len(remove)).Send()
+		return nil
+	},
+}
+
+var cacheGCCmd = &cli.Command{
+	Name:  "gc",
+	Usage: "Remove unused sources from the download cache and old builds from the packages directory",
+	Description: "Without --older-than or --max-size, the maximum size from the config is used.\n" +
+		"Built packages that aren't the current version of a package in any repo are removed as well.",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "older-than",
+			Usage: "Remove sources that weren't used for this long, such as 720h",
+		},
+		&cli.IntFlag{
+			Name:  "max-size",
+			Usage: "Remove the least recently used sources until the download cache is no larger than this many MiB",
+		},
+		&cli.BoolFlag{
+			Name:    "dry-run",
+			Aliases: []string{"n"},
+			Usage:   "Only show what would be removed",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		opts := dl.GCOptions{
+			MaxAge:  c.Duration("older-than"),
+			MaxSize: int64(c.Int("max-size")) << 20,
+			DryRun:  c.Bool("dry-run"),
+		}
+		if opts.MaxAge == 0 && opts.MaxSize == 0 {
+			opts.MaxSize = int64(config.Config(ctx).Cache.MaxSize) << 20
+		}
+
+		removed, err := dl.GC(ctx, opts)
+		if err != nil {
+			log.Fatal("Error collecting garbage in download cache").Err(err).Send()
+		}
+
+		var size int64
+		for _, entry := range removed {
+			size += entry.Size
+			if opts.DryRun {
+				fmt.Println(entry.Path)
+			}
+		}
+
+		var report build.PruneReport
+		if mgr := manager.Detect(); mgr != nil {
+			report, err = build.Prune(ctx, mgr, opts.DryRun)
+			if err != nil {
+				log.Fatal("Error pruning built packages").Err(err).Send()
+			}
+
+			if opts.DryRun {
+				for _, path := range report.Removed {
+					fmt.Println(path)
+				}
+			}
+		} else {
+			log.Warn("Unable to detect a supported package manager on the system, keeping built packages").Send()
+		}
+
+		msg := "Removed unused files"
+		if opts.DryRun {
+			msg = "Unused files can be removed"
+		}
+		log.Info(msg).
+			Int("sources", len(removed)).
+			Int("builds", len(report.Removed)).
+			Str("size", formatSize(size+report.Size)).
+			Send()
+		return nil
+	},
+}
+
+var cacheImportCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Add the files in a directory to the content-addressed store",
+	ArgsUsage: "<dir>",
+	Description: "The directory can be a copy of the content-addressed store from another machine,\n" +
+		"or contain any files, which are added using their SHA-256 checksums.",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		if c.Args().Len() != 1 {
+			log.Fatalf("Command import expected 1 argument, got %d", c.Args().Len()).Send()
+		}
+
+		added, err := dl.ImportStore(ctx, c.Args().First())
+		if err != nil {
+			log.Fatal("Error importing files").Int("imported", added).Err(err).Send()
+		}
+
+		log.Info("Imported files into the content-addressed store").Int("files", added).Send()
+		return nil
+	},
+}
+
+// formatSize formats a size in bytes using the largest fitting binary unit
+func formatSize(size int64) string {
+	const units = "KMGTPE"
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	n, i := float64(size)/1024, 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
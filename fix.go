@@ -19,46 +19,338 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"text/tabwriter"
 
 	"github.com/urfave/cli/v2"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/dl"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/pkg/build"
 	"lure.sh/lure/pkg/loggerctx"
+	"lure.sh/lure/pkg/manager"
 	"lure.sh/lure/pkg/repos"
 )
 
 var fixCmd = &cli.Command{
 	Name:  "fix",
 	Usage: "Attempt to fix problems with LURE",
+	Description: "Without any flags, the whole cache is removed and all repos are cloned again.\n" +
+		"The flags repair individual parts of the cache instead, and can be combined.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "db",
+			Usage: "Rebuild the database and re-index the repos from their existing files",
+		},
+		&cli.BoolFlag{
+			Name:  "repos",
+			Usage: "Discard local changes to the repos and pull them again",
+		},
+		&cli.BoolFlag{
+			Name:  "dl-cache",
+			Usage: "Remove corrupt entries from the download cache",
+		},
+		&cli.BoolFlag{
+			Name:  "pkgs",
+			Usage: "Remove build files and built packages that aren't needed anymore",
+		},
+		&cli.BoolFlag{
+			Name:  "check",
+			Usage: "Only check for problems, without changing anything",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		ctx := c.Context
 		log := loggerctx.From(ctx)
 
-		db.Close()
-		paths := config.GetPaths(ctx)
+		check := c.Bool("check")
+		all := !c.Bool("db") && !c.Bool("repos") && !c.Bool("dl-cache") && !c.Bool("pkgs")
+		if all && !check {
+			resetCache(ctx)
+			return nil
+		}
 
-		log.Info("Removing cache directory").Send()
+		var results []fixResult
+		if check {
+			results = append(results, checkConfig(ctx))
+		}
+		if all || c.Bool("db") {
+			// If the repos are reset as well, they're
+			// indexed again when they're pulled
+			results = append(results, fixDB(ctx, check, !c.Bool("repos")))
+		}
+		if all || c.Bool("repos") {
+			results = append(results, fixRepos(ctx, check))
+		}
+		if all || c.Bool("dl-cache") {
+			results = append(results, fixDownloadCache(ctx, check))
+		}
+		if all || c.Bool("pkgs") {
+			results = append(results, fixPkgs(ctx, check))
+		}
 
-		err := os.RemoveAll(paths.CacheDir)
-		if err != nil {
-			log.Fatal("Unable to remove cache directory").Err(err).Send()
+		problems := 0
+		for _, res := range results {
+			for _, err := range res.problems {
+				log.Warn("Problem found").Str("area", res.name).Err(err).Send()
+			}
+			problems += len(res.problems)
 		}
 
-		log.Info("Rebuilding cache").Send()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, res := range results {
+			fmt.Fprintf(tw, "%s\t%s\n", res.name, res.summary())
+		}
+		tw.Flush()
 
-		err = os.MkdirAll(paths.CacheDir, 0o755)
-		if err != nil {
-			log.Fatal("Unable to create new cache directory").Err(err).Send()
+		if check && problems > 0 {
+			log.Fatal("Found problems. Run lure fix with the flags of the affected areas to repair them.").Int("problems", problems).Send()
 		}
 
-		err = repos.Pull(ctx, config.Config(ctx).Repos)
-		if err != nil {
-			log.Fatal("Error pulling repos").Err(err).Send()
+		return nil
+	},
+}
+
+// fixResult is the outcome of checking or
+// repairing one part of LURE's files
+type fixResult struct {
+	name     string
+	problems []error
+	// done describes what was done to repair
+	// the problems, or what could be done
+	done string
+	// skipped is why the area wasn't checked, if it wasn't
+	skipped string
+}
+
+// summary returns a single line describing the result
+func (fr fixResult) summary() string {
+	if fr.skipped != "" {
+		return "skipped, " + fr.skipped
+	}
+
+	out := "ok"
+	if len(fr.problems) == 1 {
+		out = "1 problem"
+	} else if len(fr.problems) > 1 {
+		out = fmt.Sprintf("%d problems", len(fr.problems))
+	}
+
+	if fr.done != "" {
+		out += ", " + fr.done
+	}
+	return out
+}
+
+// checkConfig checks the config file and its settings
+func checkConfig(ctx context.Context) fixResult {
+	res := fixResult{name: "Config"}
+
+	err := config.Check(ctx)
+	if err != nil {
+		res.problems = append(res.problems, err)
+	}
+
+	res.problems = append(res.problems, unjoin(repos.CheckConfig(ctx, config.Config(ctx)))...)
+	return res
+}
+
+// fixDB checks the database, and rebuilds it unless check is true.
+// If reindex is true, the repos are indexed again afterwards.
+func fixDB(ctx context.Context, check, reindex bool) fixResult {
+	log := loggerctx.From(ctx)
+	res := fixResult{name: "Database"}
+
+	dbLock, err := lock.Exclusive(ctx, lock.DB)
+	if err != nil {
+		log.Fatal("Error acquiring lock").Err(err).Send()
+	}
+
+	res.problems = unjoin(db.Check(ctx))
+	if check {
+		dbLock.Release()
+		return res
+	}
+
+	log.Info("Rebuilding database").Send()
+
+	err = db.Rebuild(ctx)
+	if err != nil {
+		log.Fatal("Error rebuilding database").Err(err).Send()
+	}
+
+	// Reindex acquires this lock itself
+	dbLock.Release()
+
+	res.done = "rebuilt"
+	if !reindex {
+		return res
+	}
+
+	err = repos.Reindex(ctx, nil)
+	if err != nil {
+		log.Fatal("Error re-indexing repos").Err(err).Send()
+	}
+
+	res.done = "rebuilt and re-indexed"
+	return res
+}
+
+// fixRepos checks the files of the repos, and resets
+// them and pulls them again unless check is true
+func fixRepos(ctx context.Context, check bool) fixResult {
+	log := loggerctx.From(ctx)
+	res := fixResult{name: "Repositories"}
+
+	for _, repo := range config.Config(ctx).Repos {
+		if repo.Disabled {
+			continue
 		}
 
-		log.Info("Done").Send()
+		log.Info("Checking repository").Str("name", repo.Name).Send()
 
+		err := repos.Check(ctx, repo)
+		for _, err := range unjoin(err) {
+			// Repos that were never pulled are pulled automatically
+			if !errors.Is(err, repos.ErrNotPulled) {
+				res.problems = append(res.problems, fmt.Errorf("%s: %w", repo.Name, err))
+			}
+		}
+	}
+
+	if check {
+		return res
+	}
+
+	err := repos.Reset(ctx, nil)
+	if err != nil {
+		log.Fatal("Error resetting repos").Err(err).Send()
+	}
+
+	res.done = "reset and pulled again"
+	return res
+}
+
+// fixDownloadCache checks the entries in the download cache,
+// and removes the corrupt ones unless check is true
+func fixDownloadCache(ctx context.Context, check bool) fixResult {
+	res := fixResult{name: "Download cache"}
+
+	report, err := dl.VerifyCache(ctx, !check)
+	if err != nil {
+		loggerctx.From(ctx).Fatal("Error checking download cache").Err(err).Send()
+	}
+
+	res.problems = report.Corrupt
+	if report.Removed > 0 {
+		res.done = fmt.Sprintf("removed %d of %d entries", report.Removed, report.Entries)
+	}
+	return res
+}
+
+// fixPkgs removes the files in the packages directory that
+// aren't needed anymore, or only reports them if check is true
+func fixPkgs(ctx context.Context, check bool) fixResult {
+	log := loggerctx.From(ctx)
+	res := fixResult{name: "Built packages"}
+
+	mgr := manager.Detect()
+	if mgr == nil {
+		log.Warn("Unable to detect a supported package manager on the system, skipping built packages").Send()
+		res.skipped = "no package manager"
+		return res
+	}
+
+	report, err := build.Prune(ctx, mgr, check)
+	if err != nil {
+		log.Fatal("Error pruning built packages").Err(err).Send()
+	}
+
+	if len(report.Removed) == 0 {
+		return res
+	}
+
+	size := formatSize(report.Size)
+	if check {
+		res.done = fmt.Sprintf("%d unneeded files and directories (%s) can be removed", len(report.Removed), size)
+	} else {
+		res.done = fmt.Sprintf("removed %d files and directories (%s)", len(report.Removed), size)
+	}
+	return res
+}
+
+// unjoin splits an error created by errors.Join
+// into the errors it contains
+func unjoin(err error) []error {
+	if err == nil {
 		return nil
-	},
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// resetCache removes the whole cache directory, except for
+// the locks, and then clones all of the repos again
+func resetCache(ctx context.Context) {
+	log := loggerctx.From(ctx)
+
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		log.Fatal("Error acquiring lock").Err(err).Send()
+	}
+
+	dbLock, err := lock.Exclusive(ctx, lock.DB)
+	if err != nil {
+		log.Fatal("Error acquiring lock").Err(err).Send()
+	}
+
+	db.Close()
+	paths := config.GetPaths(ctx)
+
+	log.Info("Removing cache directory").Send()
+
+	// The lock directory has to be kept, since other
+	// processes may be waiting on the locks inside it.
+	entries, err := os.ReadDir(paths.CacheDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal("Unable to remove cache directory").Err(err).Send()
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(paths.CacheDir, entry.Name())
+		if path == paths.LockDir {
+			continue
+		}
+
+		err = os.RemoveAll(path)
+		if err != nil {
+			log.Fatal("Unable to remove cache directory").Err(err).Send()
+		}
+	}
+
+	log.Info("Rebuilding cache").Send()
+
+	err = os.MkdirAll(paths.CacheDir, 0o755)
+	if err != nil {
+		log.Fatal("Unable to create new cache directory").Err(err).Send()
+	}
+
+	// Pull acquires these locks itself
+	dbLock.Release()
+	reposLock.Release()
+
+	err = repos.Pull(ctx, config.Config(ctx).Repos)
+	if err != nil {
+		log.Fatal("Error pulling repos").Err(err).Send()
+	}
+
+	log.Info("Done").Send()
 }
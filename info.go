@@ -25,6 +25,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"lure.sh/lure/internal/cliutils"
 	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/overrides"
 	"lure.sh/lure/pkg/distro"
 	"lure.sh/lure/pkg/loggerctx"
@@ -51,11 +52,17 @@ var infoCmd = &cli.Command{
 			log.Fatalf("Command info expected at least 1 argument, got %d", args.Len()).Send()
 		}
 
-		err := repos.Pull(ctx, config.Config(ctx).Repos)
+		err := repos.AutoPull(ctx)
 		if err != nil {
 			log.Fatal("Error pulling repositories").Err(err).Send()
 		}
 
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		defer dbLock.Release()
+
 		found, _, err := repos.FindPkgs(ctx, args.Slice())
 		if err != nil {
 			log.Fatal("Error finding packages").Err(err).Send()
@@ -86,6 +93,12 @@ var infoCmd = &cli.Command{
 		}
 
 		for _, pkg := range pkgs {
+			reason, err := repos.ExplainChoice(ctx, pkg)
+			if err != nil {
+				log.Fatal("Error resolving package repository").Err(err).Send()
+			}
+			log.Info("Using package from repository").Str("name", pkg.Name).Str("repo", pkg.Repository).Str("reason", reason).Send()
+
 			if !all {
 				err = yaml.NewEncoder(os.Stdout).Encode(overrides.ResolvePackage(&pkg, names))
 				if err != nil {
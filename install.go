@@ -20,11 +20,12 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 	"lure.sh/lure/internal/cliutils"
-	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/build"
 	"lure.sh/lure/pkg/loggerctx"
@@ -42,6 +43,10 @@ var installCmd = &cli.Command{
 			Aliases: []string{"c"},
 			Usage:   "Build package from scratch even if there's an already built package available",
 		},
+		&cli.StringFlag{
+			Name:  "at-commit",
+			Usage: "Build the packages as they were at the given commit of their repo",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		ctx := c.Context
@@ -52,45 +57,86 @@ var installCmd = &cli.Command{
 			log.Fatalf("Command install expected at least 1 argument, got %d", args.Len()).Send()
 		}
 
+		// Packages may be followed by a version from the
+		// history of their repo, such as foo@1.4.2
+		var names []string
+		versions := map[string]string{}
+		for _, arg := range args.Slice() {
+			name, version, ok := strings.Cut(arg, "@")
+			if ok {
+				versions[name] = version
+			}
+			names = append(names, name)
+		}
+
 		mgr := manager.Detect()
 		if mgr == nil {
 			log.Fatal("Unable to detect a supported package manager on the system").Send()
 		}
 
-		err := repos.Pull(ctx, config.Config(ctx).Repos)
+		err := repos.AutoPull(ctx)
 		if err != nil {
 			log.Fatal("Error pulling repositories").Err(err).Send()
 		}
 
-		found, notFound, err := repos.FindPkgs(ctx, args.Slice())
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+
+		found, notFound, err := repos.FindPkgs(ctx, names)
+		dbLock.Release()
 		if err != nil {
 			log.Fatal("Error finding packages").Err(err).Send()
 		}
 
-		pkgs := cliutils.FlattenPkgs(ctx, found, "install", c.Bool("interactive"))
-		build.InstallPkgs(ctx, pkgs, notFound, types.BuildOpts{
+		opts := types.BuildOpts{
 			Manager:     mgr,
 			Clean:       c.Bool("clean"),
 			Interactive: c.Bool("interactive"),
-		})
+		}
+
+		rev := c.String("at-commit")
+		if len(versions) == 0 && rev == "" {
+			pkgs := cliutils.FlattenPkgs(ctx, found, "install", c.Bool("interactive"))
+			build.InstallPkgs(ctx, pkgs, notFound, opts)
+			return nil
+		}
+
+		// Older versions only exist in LURE repos
+		if len(notFound) > 0 {
+			log.Fatal("Packages not found").Str("names", strings.Join(notFound, ", ")).Send()
+		}
+
+		for _, name := range names {
+			pkgs := cliutils.FlattenPkgs(ctx, map[string][]db.Package{name: found[name]}, "install", c.Bool("interactive"))
+			for _, pkg := range pkgs {
+				installFromHistory(ctx, pkg, versions[name], rev, opts)
+			}
+		}
 		return nil
 	},
 	BashComplete: func(c *cli.Context) {
 		log := loggerctx.From(c.Context)
-		result, err := db.GetPkgs(c.Context, "true")
+
+		dbLock, err := lock.Shared(c.Context, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		defer dbLock.Release()
+
+		result, err := db.IterPkgs(c.Context, db.Query{Where: db.All()})
 		if err != nil {
 			log.Fatal("Error getting packages").Err(err).Send()
 		}
 		defer result.Close()
 
 		for result.Next() {
-			var pkg db.Package
-			err = result.StructScan(&pkg)
-			if err != nil {
-				log.Fatal("Error iterating over packages").Err(err).Send()
-			}
+			fmt.Println(result.Package().Name)
+		}
 
-			fmt.Println(pkg.Name)
+		if err := result.Err(); err != nil {
+			log.Fatal("Error iterating over packages").Err(err).Send()
 		}
 	},
 }
@@ -100,7 +146,8 @@ var removeCmd = &cli.Command{
 	Usage:   "Remove an installed package",
 	Aliases: []string{"rm"},
 	Action: func(c *cli.Context) error {
-		log := loggerctx.From(c.Context)
+		ctx := c.Context
+		log := loggerctx.From(ctx)
 
 		args := c.Args()
 		if args.Len() < 1 {
@@ -112,7 +159,30 @@ var removeCmd = &cli.Command{
 			log.Fatal("Unable to detect a supported package manager on the system").Send()
 		}
 
-		err := mgr.Remove(nil, c.Args().Slice()...)
+		err := repos.AutoPull(ctx)
+		if err != nil {
+			log.Fatal("Error pulling repositories").Err(err).Send()
+		}
+
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		needed := warnDependents(ctx, mgr, args.Slice())
+		dbLock.Release()
+
+		if needed {
+			cont, err := cliutils.YesNoPrompt(ctx, "Would you still like to continue?", c.Bool("interactive"), true)
+			if err != nil {
+				log.Fatal("Error prompting user").Err(err).Send()
+			}
+
+			if !cont {
+				return nil
+			}
+		}
+
+		err = mgr.Remove(nil, args.Slice()...)
 		if err != nil {
 			log.Fatal("Error removing packages").Err(err).Send()
 		}
@@ -0,0 +1,285 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package auth finds the credentials LURE uses to access private
+// repos and sources, using the auth entries in the LURE config and
+// the user's .netrc file. Secrets are only read from environment
+// variables and files when they're needed, and they're never
+// written anywhere.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/types"
+)
+
+// Credentials contains the credentials for a host
+type Credentials struct {
+	Username string
+	Password string
+	// Token is sent as a bearer token over HTTP
+	Token string
+	// SSHKey is the path to a private key used for SSH
+	SSHKey string
+	// SSHAgent means that the SSH agent should be used
+	SSHAgent bool
+}
+
+// Store looks up the credentials for hosts
+type Store struct {
+	baseDir string
+	entries []types.Auth
+	netrc   []netrcEntry
+	// repoHosts are the hostnames of the configured repos,
+	// which are the only hosts the default .netrc entry is used for.
+	repoHosts map[string]bool
+}
+
+// New creates a Store using the auth entries from the
+// LURE config and the user's .netrc file, if it exists.
+// The location of the .netrc file can be changed using
+// the NETRC environment variable.
+func New(ctx context.Context) (*Store, error) {
+	s := &Store{
+		baseDir:   config.GetPaths(ctx).ConfigDir,
+		entries:   config.Config(ctx).Auth,
+		repoHosts: map[string]bool{},
+	}
+
+	for _, repo := range config.Config(ctx).Repos {
+		if repo.URL == "" {
+			continue
+		}
+		if ep, err := transport.NewEndpoint(repo.URL); err == nil {
+			s.repoHosts[strings.ToLower(ep.Host)] = true
+		}
+	}
+
+	netrcPath := os.Getenv("NETRC")
+	if netrcPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return s, nil
+		}
+		netrcPath = filepath.Join(home, ".netrc")
+	}
+
+	data, err := os.ReadFile(netrcPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s.netrc = parseNetrc(string(data))
+
+	return s, nil
+}
+
+// Lookup returns the credentials for the given host, which may include a
+// port. Auth entries from the config take precedence over the .netrc file,
+// and entries that include the port take precedence over ones that don't.
+// The default .netrc entry only applies to the hosts of configured repos,
+// so that its credentials aren't sent to arbitrary source URLs.
+func (s *Store) Lookup(host string) (Credentials, bool, error) {
+	if s == nil {
+		return Credentials{}, false, nil
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, match := range []string{host, hostname} {
+		for _, entry := range s.entries {
+			if !strings.EqualFold(entry.Host, match) {
+				continue
+			}
+
+			creds, err := s.load(entry)
+			if err != nil {
+				return Credentials{}, false, fmt.Errorf("auth for %s: %w", entry.Host, err)
+			}
+			return creds, true, nil
+		}
+	}
+
+	var def *netrcEntry
+	for i, entry := range s.netrc {
+		if entry.machine == "" {
+			if def == nil {
+				def = &s.netrc[i]
+			}
+			continue
+		}
+
+		if strings.EqualFold(entry.machine, hostname) {
+			return Credentials{Username: entry.login, Password: entry.password}, true, nil
+		}
+	}
+
+	if def != nil && s.repoHosts[strings.ToLower(hostname)] {
+		return Credentials{Username: def.login, Password: def.password}, true, nil
+	}
+
+	return Credentials{}, false, nil
+}
+
+// SetRequestAuth adds the credentials for the host of the request to it.
+// Requests whose URLs already contain credentials are left alone.
+func (s *Store) SetRequestAuth(req *http.Request) error {
+	if s == nil || req.URL.User != nil {
+		return nil
+	}
+
+	creds, ok, err := s.Lookup(req.URL.Host)
+	if err != nil || !ok {
+		return err
+	}
+
+	if creds.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+creds.Token)
+	} else if creds.Username != "" || creds.Password != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	return nil
+}
+
+// GitAuth returns the auth method go-git should use for the git repo at
+// the given URL. It returns nil if there are no credentials for its host,
+// in which case go-git uses its defaults, such as the SSH agent.
+func (s *Store) GitAuth(rawURL string) (transport.AuthMethod, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	ep, err := transport.NewEndpoint(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := ep.Host
+	if ep.Port != 0 {
+		host = net.JoinHostPort(ep.Host, strconv.Itoa(ep.Port))
+	}
+
+	creds, ok, err := s.Lookup(host)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	switch ep.Protocol {
+	case "ssh":
+		user := ep.User
+		if user == "" {
+			user = creds.Username
+		}
+		if user == "" {
+			user = "git"
+		}
+
+		if creds.SSHKey != "" {
+			return gitssh.NewPublicKeysFromFile(user, creds.SSHKey, "")
+		} else if creds.SSHAgent {
+			return gitssh.NewSSHAgentAuth(user)
+		}
+	case "http", "https":
+		if creds.Token != "" {
+			return &githttp.TokenAuth{Token: creds.Token}, nil
+		} else if creds.Username != "" || creds.Password != "" {
+			return &githttp.BasicAuth{Username: creds.Username, Password: creds.Password}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// load reads the secrets of a config entry
+func (s *Store) load(entry types.Auth) (Credentials, error) {
+	creds := Credentials{
+		Username: entry.Username,
+		SSHAgent: entry.SSHAgent,
+	}
+
+	var err error
+	creds.Password, err = s.readSecret(entry.PasswordEnv, entry.PasswordFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("password: %w", err)
+	}
+
+	creds.Token, err = s.readSecret(entry.TokenEnv, entry.TokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("token: %w", err)
+	}
+
+	if entry.SSHKey != "" {
+		creds.SSHKey = expandPath(s.baseDir, entry.SSHKey)
+	}
+
+	return creds, nil
+}
+
+// readSecret reads a secret from the given environment variable, or
+// from the given file if the variable isn't set. Errors never include
+// the secret itself.
+func (s *Store) readSecret(env, file string) (string, error) {
+	if env != "" {
+		if val, ok := os.LookupEnv(env); ok {
+			return val, nil
+		} else if file == "" {
+			return "", fmt.Errorf("environment variable %s is not set", env)
+		}
+	}
+
+	if file == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(expandPath(s.baseDir, file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// expandPath expands a leading ~ to the user's home directory
+// and resolves relative paths relative to baseDir.
+func expandPath(baseDir, path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return path
+}
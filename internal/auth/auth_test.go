@@ -0,0 +1,155 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"lure.sh/lure/internal/auth"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/dl"
+	"lure.sh/lure/internal/types"
+)
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+	netrc := filepath.Join(tmp, "netrc")
+	err := os.WriteFile(netrc, []byte("# Some comment\nmachine netrc.example.com login alice password s3cret\n\nmacdef init\nmachine macro.example.com\n\ndefault login anon password none\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	t.Setenv("NETRC", netrc)
+
+	tokenFile := filepath.Join(tmp, "token")
+	err = os.WriteFile(tokenFile, []byte("file-token\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	t.Setenv("LURE_TEST_PASSWORD", "env-password")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "bob" || pass != "env-password" {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		res.Write([]byte("private data"))
+	}))
+	defer srv.Close()
+
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	config.Config(ctx).Auth = []types.Auth{
+		{Host: "127.0.0.1", TokenFile: tokenFile},
+		{Host: srvURL.Host, Username: "bob", PasswordEnv: "LURE_TEST_PASSWORD"},
+		{Host: "missing.example.com", TokenEnv: "LURE_TEST_MISSING"},
+	}
+	config.Config(ctx).Repos = []types.Repo{{Name: "default", URL: "https://repo.example.com/lure-repo.git"}}
+
+	store, err := auth.New(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// The entry with the port takes precedence over the one without it
+	creds, ok, err := store.Lookup(srvURL.Host)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	} else if !ok || creds.Username != "bob" || creds.Password != "env-password" {
+		t.Errorf("Expected credentials for bob, got %+v", creds)
+	}
+
+	creds, _, _ = store.Lookup("127.0.0.1:1")
+	if creds.Token != "file-token" {
+		t.Errorf("Expected token from file, got %q", creds.Token)
+	}
+
+	creds, _, _ = store.Lookup("netrc.example.com")
+	if creds.Username != "alice" || creds.Password != "s3cret" {
+		t.Errorf("Expected credentials from .netrc, got %+v", creds)
+	}
+
+	creds, _, _ = store.Lookup("repo.example.com")
+	if creds.Username != "anon" {
+		t.Errorf("Expected default .netrc credentials, got %+v", creds)
+	}
+
+	// The default entry shouldn't be sent to hosts that aren't repos
+	_, ok, _ = store.Lookup("other.example.com")
+	if ok {
+		t.Errorf("Expected no credentials for other.example.com")
+	}
+
+	_, _, err = store.Lookup("missing.example.com")
+	if err == nil {
+		t.Errorf("Expected error for unset environment variable")
+	}
+
+	method, err := store.GitAuth("https://netrc.example.com/repo.git")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if basic, ok := method.(*githttp.BasicAuth); !ok || basic.Username != "alice" {
+		t.Errorf("Expected basic auth for alice, got %v", method)
+	}
+
+	dest := t.TempDir()
+	_, _, err = dl.FileDownloader{}.Download(dl.Options{
+		URL:              srv.URL + "/file.txt",
+		Destination:      dest,
+		PostprocDisabled: true,
+	})
+	if err == nil {
+		t.Errorf("Expected download without credentials to fail")
+	}
+
+	_, name, err := dl.FileDownloader{}.Download(dl.Options{
+		URL:         srv.URL + "/file.txt",
+		Destination: dest,
+		Auth:        store,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dest, name))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if string(data) != "private data" {
+		t.Errorf("Expected private data, got %q", data)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import "strings"
+
+// netrcEntry is a machine in a .netrc file. The default
+// entry, which matches any machine, has an empty name.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parses the contents of a .netrc file.
+// Macro definitions are skipped.
+func parseNetrc(data string) []netrcEntry {
+	var (
+		out     []netrcEntry
+		current *netrcEntry
+	)
+
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			// Comments last until the end of the line
+			if strings.HasPrefix(fields[j], "#") {
+				break
+			}
+
+			next := ""
+			if j+1 < len(fields) {
+				next = fields[j+1]
+			}
+
+			switch fields[j] {
+			case "machine":
+				out = append(out, netrcEntry{machine: next})
+				current = &out[len(out)-1]
+				j++
+			case "default":
+				out = append(out, netrcEntry{})
+				current = &out[len(out)-1]
+			case "login":
+				if current != nil {
+					current.login = next
+				}
+				j++
+			case "password":
+				if current != nil {
+					current.password = next
+				}
+				j++
+			case "account":
+				j++
+			case "macdef":
+				// A macro continues until the next empty line
+				current = nil
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+
+	return out
+}
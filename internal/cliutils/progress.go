@@ -0,0 +1,160 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cliutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Stderr should be used instead of os.Stderr for LURE's log messages.
+// While a MultiBar is shown, anything written to it is printed above
+// the progress bars rather than over them.
+var Stderr io.Writer = stderr{}
+
+var (
+	termMtx sync.Mutex
+	// active is the MultiBar that's currently shown, if there is one
+	active *MultiBar
+)
+
+type stderr struct{}
+
+func (stderr) Write(b []byte) (int, error) {
+	termMtx.Lock()
+	defer termMtx.Unlock()
+
+	if active == nil {
+		return os.Stderr.Write(b)
+	}
+
+	active.clear()
+	n, err := os.Stderr.Write(b)
+	active.draw()
+	return n, err
+}
+
+// MultiBar shows several progress bars at once, each on its own line at the
+// bottom of the terminal. If stderr isn't a terminal, the bars aren't shown.
+type MultiBar struct {
+	lines []string
+	// drawn is the amount of lines that were drawn last time
+	drawn int
+}
+
+// NewMultiBar creates a new MultiBar and shows it until Close is called.
+// Only one MultiBar can be shown at a time.
+func NewMultiBar() *MultiBar {
+	mb := &MultiBar{}
+
+	termMtx.Lock()
+	defer termMtx.Unlock()
+
+	if isatty.IsTerminal(os.Stderr.Fd()) && active == nil {
+		active = mb
+	}
+	return mb
+}
+
+// Bar adds a new progress bar to the MultiBar, and returns the writer that
+// the bar should be rendered to. Only the text after the last carriage return
+// or newline is shown, which is how progress bars normally redraw themselves.
+func (mb *MultiBar) Bar() io.Writer {
+	termMtx.Lock()
+	defer termMtx.Unlock()
+
+	if active != mb {
+		return io.Discard
+	}
+
+	mb.lines = append(mb.lines, "")
+	return &barWriter{mb: mb, index: len(mb.lines) - 1}
+}
+
+// Close stops showing the MultiBar. The bars stay on the
+// terminal as they were last drawn, above any new output.
+func (mb *MultiBar) Close() {
+	termMtx.Lock()
+	defer termMtx.Unlock()
+
+	if active == mb {
+		active = nil
+	}
+}
+
+// clear removes the bars from the terminal, leaving the cursor
+// where the first one was. termMtx must be held by the caller.
+func (mb *MultiBar) clear() {
+	if mb.drawn > 0 {
+		fmt.Fprintf(os.Stderr, "\033[%dA\033[J", mb.drawn)
+		mb.drawn = 0
+	}
+}
+
+// draw shows the bars below the cursor. termMtx must be held by the caller.
+func (mb *MultiBar) draw() {
+	var buf bytes.Buffer
+	for _, line := range mb.lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	os.Stderr.Write(buf.Bytes())
+	mb.drawn = len(mb.lines)
+}
+
+// barWriter is the writer for a single bar in a MultiBar
+type barWriter struct {
+	mb    *MultiBar
+	index int
+}
+
+func (bw *barWriter) Write(b []byte) (int, error) {
+	segments := strings.FieldsFunc(string(b), func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+
+	// Progress bars clear their line with spaces before redrawing it
+	line := ""
+	for i := len(segments) - 1; i >= 0; i-- {
+		if strings.TrimSpace(segments[i]) != "" {
+			line = strings.TrimRight(segments[i], " ")
+			break
+		}
+	}
+
+	if line == "" {
+		return len(b), nil
+	}
+
+	termMtx.Lock()
+	defer termMtx.Unlock()
+
+	bw.mb.lines[bw.index] = line
+	if active == bw.mb {
+		bw.mb.clear()
+		bw.mb.draw()
+	}
+	return len(b), nil
+}
@@ -50,8 +50,9 @@ func YesNoPrompt(ctx context.Context, msg string, interactive, def bool) (bool,
 
 // PromptViewScript asks the user if they'd like to see a script,
 // shows it if they answer yes, then asks if they'd still like to
-// continue, and exits if they answer no.
-func PromptViewScript(ctx context.Context, script, name, style string, interactive bool) error {
+// continue, and exits if they answer no. If the script's package
+// has an overlay, overlayDiff is shown instead of the script.
+func PromptViewScript(ctx context.Context, script, overlayDiff, name, style string, interactive bool) error {
 	log := loggerctx.From(ctx)
 
 	if !interactive {
@@ -65,7 +66,7 @@ func PromptViewScript(ctx context.Context, script, name, style string, interacti
 	}
 
 	if view {
-		err = ShowScript(script, name, style)
+		err = ShowScript(script, overlayDiff, name, style)
 		if err != nil {
 			return err
 		}
@@ -84,17 +85,28 @@ func PromptViewScript(ctx context.Context, script, name, style string, interacti
 }
 
 // ShowScript uses the built-in pager to display a script at a
-// given path, in the given syntax highlighting style.
-func ShowScript(path, name, style string) error {
-	scriptFl, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer scriptFl.Close()
+// given path, in the given syntax highlighting style. If overlayDiff
+// isn't empty, it's displayed instead of the script, so that the
+// changes made by the package's overlay can be seen.
+func ShowScript(path, overlayDiff, name, style string) error {
+	var str string
+	if overlayDiff != "" {
+		var err error
+		str, err = pager.SyntaxHighlightDiff(strings.NewReader(overlayDiff), style)
+		if err != nil {
+			return err
+		}
+	} else {
+		scriptFl, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer scriptFl.Close()
 
-	str, err := pager.SyntaxHighlightBash(scriptFl, style)
-	if err != nil {
-		return err
+		str, err = pager.SyntaxHighlightBash(scriptFl, style)
+		if err != nil {
+			return err
+		}
 	}
 
 	pgr := pager.New(name, str)
@@ -20,7 +20,10 @@ package config
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/pelletier/go-toml/v2"
@@ -77,3 +80,30 @@ func Config(ctx context.Context) *types.Config {
 
 	return config
 }
+
+// Check decodes the config file strictly, and returns an error if it
+// can't be decoded, or if it contains fields that LURE doesn't know about,
+// which are usually typos. Unlike Config, it never falls back to defaults.
+func Check(ctx context.Context) error {
+	cfgFl, err := os.Open(GetPaths(ctx).ConfigPath)
+	if err != nil {
+		return err
+	}
+	defer cfgFl.Close()
+
+	dec := toml.NewDecoder(cfgFl)
+	dec.DisallowUnknownFields()
+
+	var cfg types.Config
+	err = dec.Decode(&cfg)
+
+	var strictErr *toml.StrictMissingError
+	if errors.As(err, &strictErr) {
+		var keys []string
+		for _, e := range strictErr.Errors {
+			keys = append(keys, strings.Join(e.Key(), "."))
+		}
+		return fmt.Errorf("unknown fields in config: %s", strings.Join(keys, ", "))
+	}
+	return err
+}
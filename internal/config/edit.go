@@ -0,0 +1,307 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"lure.sh/lure/internal/types"
+)
+
+// ErrRepoNotFound is returned by Editor when the
+// requested repo can't be found in the config file
+var ErrRepoNotFound = errors.New("repo not found in config file")
+
+var (
+	headerRegex = regexp.MustCompile(`^\s*(\[\[?)\s*([^\[\]]+?)\s*\]\]?\s*(#.*)?$`)
+	keyRegex    = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+|"[^"]*"|'[^']*')\s*=`)
+)
+
+// Editor edits the LURE config file line by line, so that
+// the user's formatting and comments are preserved. Only the
+// lines that need to change are touched.
+type Editor struct {
+	path  string
+	lines []string
+}
+
+// NewEditor reads the LURE config file and returns an Editor for it
+func NewEditor(ctx context.Context) (*Editor, error) {
+	path := GetPaths(ctx).ConfigPath
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Editor{
+		path:  path,
+		lines: strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"),
+	}, nil
+}
+
+// AddRepo adds a repo to the end of the config file
+func (e *Editor) AddRepo(repo types.Repo) error {
+	data, err := toml.Marshal(struct {
+		Repos []types.Repo `toml:"repo"`
+	}{[]types.Repo{repo}})
+	if err != nil {
+		return err
+	}
+
+	if len(e.lines) > 0 && strings.TrimSpace(e.lines[len(e.lines)-1]) != "" {
+		e.lines = append(e.lines, "")
+	}
+	e.lines = append(e.lines, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")...)
+	return nil
+}
+
+// RemoveRepo removes the repo with the given name from the
+// config file, along with any comments directly above it
+func (e *Editor) RemoveRepo(name string) error {
+	start, end, err := e.findRepo(name)
+	if err != nil {
+		return err
+	}
+
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(e.lines[start-1]), "#") {
+		start--
+	}
+
+	// Blank lines and comments at the end of the table
+	// belong to whatever comes after it, so they're kept
+	for end > start+1 && !isValueLine(e.lines[end-1]) {
+		end--
+	}
+
+	// Remove the blank line separating the repo from the previous table
+	if start > 0 && strings.TrimSpace(e.lines[start-1]) == "" {
+		start--
+	}
+
+	e.lines = append(e.lines[:start], e.lines[end:]...)
+	return nil
+}
+
+// SetRepoValue sets a key of the repo with the given name.
+// If val is nil, the key is removed instead.
+func (e *Editor) SetRepoValue(name, key string, val any) error {
+	start, end, err := e.findRepo(name)
+	if err != nil {
+		return err
+	}
+	return e.setValue(start+1, end, key, val)
+}
+
+// SetValue sets a key in the given table, creating the table if
+// it doesn't exist. If val is nil, the key is removed instead.
+func (e *Editor) SetValue(table, key string, val any) error {
+	for i, line := range e.lines {
+		m := headerRegex.FindStringSubmatch(line)
+		if m != nil && m[1] == "[" && m[2] == table {
+			return e.setValue(i+1, e.tableEnd(i+1), key, val)
+		}
+	}
+
+	if val == nil {
+		return nil
+	}
+
+	if len(e.lines) > 0 && strings.TrimSpace(e.lines[len(e.lines)-1]) != "" {
+		e.lines = append(e.lines, "")
+	}
+	e.lines = append(e.lines, "["+table+"]")
+	return e.setValue(len(e.lines), len(e.lines), key, val)
+}
+
+// Save makes sure the edited config is still valid,
+// and then writes it to the config file
+func (e *Editor) Save() error {
+	for len(e.lines) > 0 && strings.TrimSpace(e.lines[len(e.lines)-1]) == "" {
+		e.lines = e.lines[:len(e.lines)-1]
+	}
+	data := []byte(strings.Join(e.lines, "\n") + "\n")
+
+	var cfg types.Config
+	err := toml.NewDecoder(bytes.NewReader(data)).Decode(&cfg)
+	if err != nil {
+		return fmt.Errorf("edited config is invalid: %w", err)
+	}
+
+	// Write to a temporary file first so that the
+	// config is never left partially written
+	tmp, err := os.CreateTemp(filepath.Dir(e.path), ".lure.toml.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(e.path); err == nil {
+		os.Chmod(tmp.Name(), fi.Mode().Perm())
+	}
+
+	return os.Rename(tmp.Name(), e.path)
+}
+
+// setValue sets a key within the lines between start and end,
+// which contain the keys of a single table
+func (e *Editor) setValue(start, end int, key string, val any) error {
+	var newLines []string
+	if val != nil {
+		data, err := toml.Marshal(map[string]any{key: val})
+		if err != nil {
+			return err
+		}
+		newLines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	// Keys are inserted after the last value in the table,
+	// rather than after any comments or blank lines at its end
+	insertAt := start
+	for i := start; i < end; i++ {
+		name, ok := lineKey(e.lines[i])
+		if !ok {
+			continue
+		}
+
+		valEnd := e.valueEnd(i, end)
+		if name == key {
+			if comment := lineComment(e.lines[i]); comment != "" && valEnd == i+1 && len(newLines) == 1 {
+				newLines[0] += " " + comment
+			}
+			e.splice(i, valEnd, newLines)
+			return nil
+		}
+
+		insertAt = valEnd
+		i = valEnd - 1
+	}
+
+	e.splice(insertAt, insertAt, newLines)
+	return nil
+}
+
+// findRepo returns the range of lines containing
+// the [[repo]] table of the repo with the given name
+func (e *Editor) findRepo(name string) (int, int, error) {
+	for i, line := range e.lines {
+		m := headerRegex.FindStringSubmatch(line)
+		if m == nil || m[1] != "[[" || m[2] != "repo" {
+			continue
+		}
+
+		end := e.tableEnd(i + 1)
+		for j := i + 1; j < end; j++ {
+			if key, ok := lineKey(e.lines[j]); ok && key == "name" {
+				var v struct {
+					Name string `toml:"name"`
+				}
+				if toml.Unmarshal([]byte(e.lines[j]), &v) == nil && v.Name == name {
+					return i, end, nil
+				}
+				break
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("%w: %s", ErrRepoNotFound, name)
+}
+
+// tableEnd returns the index of the first line after
+// start that begins a new table, or the end of the file
+func (e *Editor) tableEnd(start int) int {
+	for i := start; i < len(e.lines); i++ {
+		if _, ok := lineKey(e.lines[i]); ok {
+			i = e.valueEnd(i, len(e.lines)) - 1
+			continue
+		}
+
+		if headerRegex.MatchString(e.lines[i]) {
+			return i
+		}
+	}
+	return len(e.lines)
+}
+
+// valueEnd returns the index of the line after the end of the value
+// of the key on line i. Values such as arrays may span multiple lines.
+func (e *Editor) valueEnd(i, end int) int {
+	var v map[string]any
+	for j := i + 1; j <= end; j++ {
+		if toml.Unmarshal([]byte(strings.Join(e.lines[i:j], "\n")), &v) == nil {
+			return j
+		}
+	}
+	return i + 1
+}
+
+func (e *Editor) splice(start, end int, lines []string) {
+	out := make([]string, 0, len(e.lines)-(end-start)+len(lines))
+	out = append(out, e.lines[:start]...)
+	out = append(out, lines...)
+	out = append(out, e.lines[end:]...)
+	e.lines = out
+}
+
+// lineKey returns the key of a key/value line
+func lineKey(line string) (string, bool) {
+	m := keyRegex.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return strings.Trim(m[1], `"'`), true
+}
+
+// isValueLine reports whether a line contains part of a
+// key/value pair, rather than just whitespace or a comment
+func isValueLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && !strings.HasPrefix(line, "#")
+}
+
+// lineComment returns the comment at the end of a key/value line,
+// or an empty string if there isn't one
+func lineComment(line string) string {
+	var v map[string]any
+	for i, c := range line {
+		// A # inside of a string means that the text
+		// before it won't be a valid key/value pair
+		if c == '#' && toml.Unmarshal([]byte(line[:i]), &v) == nil {
+			return line[i:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/types"
+)
+
+const testConfig = `# LURE config
+rootCmd = 'sudo'
+pagerStyle = 'native'
+ignorePkgUpdates = []
+
+# Main repo
+[[repo]]
+name = 'default' # the default repo
+url = 'https://github.com/lure-sh/lure-repo.git'
+
+# Extra repo
+[[repo]]
+name = 'extra'
+url = 'https://example.com/extra.git'
+
+[pin]
+foo = 'extra'
+`
+
+const expectedConfig = `# LURE config
+rootCmd = 'sudo'
+pagerStyle = 'native'
+ignorePkgUpdates = []
+
+# Main repo
+[[repo]]
+name = 'main' # the default repo
+url = 'https://github.com/lure-sh/lure-repo.git'
+disabled = true
+
+[pin]
+foo = 'extra'
+bar = 'main'
+
+[[repo]]
+name = 'new'
+url = 'https://example.com/new.git'
+`
+
+func TestEditor(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+	cfgPath := config.GetPaths(ctx).ConfigPath
+	err := os.WriteFile(cfgPath, []byte(testConfig), 0o644)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	e, err := config.NewEditor(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = e.SetRepoValue("default", "name", "main")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = e.SetRepoValue("main", "disabled", true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = e.RemoveRepo("extra")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = e.SetValue("pin", "bar", "main")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = e.AddRepo(types.Repo{Name: "new", URL: "https://example.com/new.git"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = e.SetRepoValue("missing", "url", "https://example.com")
+	if err == nil {
+		t.Errorf("Expected error for missing repo")
+	}
+
+	err = e.Save()
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if string(data) != expectedConfig {
+		t.Errorf("Unexpected config file contents:\n%s", data)
+	}
+}
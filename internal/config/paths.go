@@ -32,9 +32,11 @@ import (
 type Paths struct {
 	ConfigDir  string
 	ConfigPath string
+	OverlayDir string
 	CacheDir   string
 	RepoDir    string
 	PkgsDir    string
+	LockDir    string
 	DBPath     string
 }
 
@@ -68,6 +70,7 @@ func GetPaths(ctx context.Context) *Paths {
 		}
 
 		paths.ConfigPath = filepath.Join(paths.ConfigDir, "lure.toml")
+		paths.OverlayDir = filepath.Join(paths.ConfigDir, "overlays")
 
 		if _, err := os.Stat(paths.ConfigPath); err != nil {
 			cfgFl, err := os.Create(paths.ConfigPath)
@@ -91,6 +94,7 @@ func GetPaths(ctx context.Context) *Paths {
 		paths.CacheDir = filepath.Join(cacheDir, "lure")
 		paths.RepoDir = filepath.Join(paths.CacheDir, "repo")
 		paths.PkgsDir = filepath.Join(paths.CacheDir, "pkgs")
+		paths.LockDir = filepath.Join(paths.CacheDir, "locks")
 
 		err = os.MkdirAll(paths.RepoDir, 0o755)
 		if err != nil {
@@ -0,0 +1,154 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"lure.sh/lure/internal/config"
+)
+
+// tables contains the names of all the tables in the database
+var tables = []string{
+	"pkgs",
+	"pkg_deps",
+	"repo_files",
+	"index_errors",
+	"repo_refresh",
+	"lure_db_version",
+}
+
+// Check checks the database file for corruption and makes sure its
+// schema is up to date. The database is opened separately in read-only
+// mode, so that it isn't reset or changed in any way. All the problems
+// that were found are returned together. If the database doesn't exist
+// yet, that's not a problem, since it's created when it's first used.
+func Check(ctx context.Context) error {
+	dbPath := config.GetPaths(ctx).DBPath
+	if _, err := os.Stat(dbPath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	ro, err := sqlx.Open("sqlite", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer ro.Close()
+
+	var results []string
+	err = ro.SelectContext(ctx, &results, "PRAGMA integrity_check;")
+	if err != nil {
+		return fmt.Errorf("database can't be read: %w", err)
+	}
+
+	var errs []error
+	for _, res := range results {
+		if res != "ok" {
+			errs = append(errs, fmt.Errorf("database is corrupt: %s", res))
+		}
+	}
+
+	for _, table := range tables {
+		var count int
+		err = ro.GetContext(ctx, &count, "SELECT count(1) FROM sqlite_master WHERE type = 'table' AND name = ?;", table)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			errs = append(errs, fmt.Errorf("database table %s is missing", table))
+		}
+	}
+
+	var ver version
+	err = ro.GetContext(ctx, &ver, "SELECT * FROM lure_db_version LIMIT 1;")
+	if err != nil {
+		errs = append(errs, errors.New("database has no version"))
+	} else if ver.Version != CurrentVersion {
+		errs = append(errs, fmt.Errorf("database version is %d, expected %d", ver.Version, CurrentVersion))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Rebuild replaces the database with a new, empty one. The refresh times
+// of the repos are kept if they can still be read, so that rebuilding the
+// database doesn't make LURE pull every repo again. The caller has to
+// hold the DB lock.
+func Rebuild(ctx context.Context) error {
+	dbPath := config.GetPaths(ctx).DBPath
+
+	// The refresh times are read using a separate connection,
+	// since opening the database normally fails if it's corrupt.
+	times := map[string]time.Time{}
+	if ro, err := sqlx.Open("sqlite", "file:"+dbPath+"?mode=ro"); err == nil {
+		var rows []refreshTime
+		if ro.SelectContext(ctx, &rows, "SELECT * FROM repo_refresh") == nil {
+			for _, row := range rows {
+				times[row.Repository] = time.Unix(row.Time, 0)
+			}
+		}
+		ro.Close()
+	}
+
+	Close()
+
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		err := os.Remove(dbPath + suffix)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// The version is added before the database is initialized,
+	// since a database without one is assumed to be broken.
+	nw, err := sqlx.Open("sqlite", dbPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = nw.ExecContext(ctx, "CREATE TABLE lure_db_version (version INT NOT NULL);")
+	if err == nil {
+		_, err = nw.ExecContext(ctx, "INSERT INTO lure_db_version(version) VALUES (?);", CurrentVersion)
+	}
+	nw.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = open(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+
+	for repo, t := range times {
+		err = SetRefreshTime(ctx, repo, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
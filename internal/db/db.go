@@ -25,6 +25,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/jmoiron/sqlx"
@@ -36,7 +37,7 @@ import (
 
 // CurrentVersion is the current version of the database.
 // The database is reset if its version doesn't match this.
-const CurrentVersion = 2
+const CurrentVersion = 9
 
 func init() {
 	sqlite.MustRegisterScalarFunction("json_array_contains", 2, jsonArrayContains)
@@ -44,22 +45,50 @@ func init() {
 
 // Package is a LURE package's database representation
 type Package struct {
-	Name          string                    `sh:"name,required" db:"name"`
-	Version       string                    `sh:"version,required" db:"version"`
-	Release       int                       `sh:"release,required" db:"release"`
-	Epoch         uint                      `sh:"epoch" db:"epoch"`
-	Description   JSON[map[string]string]   `db:"description"`
-	Homepage      JSON[map[string]string]   `db:"homepage"`
-	Maintainer    JSON[map[string]string]   `db:"maintainer"`
-	Architectures JSON[[]string]            `sh:"architectures" db:"architectures"`
-	Licenses      JSON[[]string]            `sh:"license" db:"licenses"`
-	Provides      JSON[[]string]            `sh:"provides" db:"provides"`
-	Conflicts     JSON[[]string]            `sh:"conflicts" db:"conflicts"`
-	Replaces      JSON[[]string]            `sh:"replaces" db:"replaces"`
-	Depends       JSON[map[string][]string] `db:"depends"`
-	BuildDepends  JSON[map[string][]string] `db:"builddepends"`
-	OptDepends    JSON[map[string][]string] `db:"optdepends"`
-	Repository    string                    `db:"repository"`
+	Name          string                    `sh:"name,required" db:"name" json:"name"`
+	Version       string                    `sh:"version,required" db:"version" json:"version"`
+	Release       int                       `sh:"release,required" db:"release" json:"release"`
+	Epoch         uint                      `sh:"epoch" db:"epoch" json:"epoch"`
+	Description   JSON[map[string]string]   `db:"description" json:"description"`
+	Homepage      JSON[map[string]string]   `db:"homepage" json:"homepage"`
+	Maintainer    JSON[map[string]string]   `db:"maintainer" json:"maintainer"`
+	Architectures JSON[[]string]            `sh:"architectures" db:"architectures" json:"architectures"`
+	Licenses      JSON[[]string]            `sh:"license" db:"licenses" json:"licenses"`
+	Provides      JSON[[]string]            `sh:"provides" db:"provides" json:"provides"`
+	Conflicts     JSON[[]string]            `sh:"conflicts" db:"conflicts" json:"conflicts"`
+	Replaces      JSON[[]string]            `sh:"replaces" db:"replaces" json:"replaces"`
+	Depends       JSON[map[string][]string] `db:"depends" json:"depends"`
+	BuildDepends  JSON[map[string][]string] `db:"builddepends" json:"builddepends"`
+	OptDepends    JSON[map[string][]string] `db:"optdepends" json:"optdepends"`
+	Repository    string                    `db:"repository" json:"repository"`
+	// Path is the path of the package's build script,
+	// relative to the directory of its repository
+	Path string `db:"path" json:"path,omitempty"`
+	// Files are the paths of the other files in the package's
+	// repository that its build script accessed while it was
+	// being indexed. The paths of directories end with a slash.
+	Files JSON[[]string] `db:"files" json:"-" yaml:"-"`
+	// Overlay is the hash of the local overlay that was
+	// applied to the package, or empty if there isn't one
+	Overlay string `db:"overlay" json:"-" yaml:"-"`
+}
+
+// Dependency types stored in the dependency index
+const (
+	DepTypeRuntime  = "depends"
+	DepTypeBuild    = "builddepends"
+	DepTypeOptional = "optdepends"
+)
+
+// Dependency is a single row in the dependency index. Each row
+// records that the package Name in Repository depends on Dep
+// when the given override is in effect.
+type Dependency struct {
+	Name       string `db:"name"`
+	Repository string `db:"repository"`
+	Type       string `db:"type"`
+	Override   string `db:"override"`
+	Dep        string `db:"dep"`
 }
 
 type version struct {
@@ -144,9 +173,45 @@ func initDB(ctx context.Context, dsn string) error {
 			depends       TEXT CHECK(depends = 'null' OR (JSON_VALID(depends) AND JSON_TYPE(depends) = 'object')),
 			builddepends  TEXT CHECK(builddepends = 'null' OR (JSON_VALID(builddepends) AND JSON_TYPE(builddepends) = 'object')),
 			optdepends    TEXT CHECK(optdepends = 'null' OR (JSON_VALID(optdepends) AND JSON_TYPE(optdepends) = 'object')),
+			path          TEXT NOT NULL DEFAULT '',
+			files         TEXT CHECK(files = 'null' OR (JSON_VALID(files) AND JSON_TYPE(files) = 'array')),
+			overlay       TEXT NOT NULL DEFAULT '',
 			UNIQUE(name, repository)
 		);
 
+		CREATE TABLE IF NOT EXISTS pkg_deps (
+			name       TEXT NOT NULL,
+			repository TEXT NOT NULL,
+			type       TEXT NOT NULL,
+			override   TEXT NOT NULL,
+			dep        TEXT NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS pkg_deps_dep ON pkg_deps(dep);
+		CREATE INDEX IF NOT EXISTS pkg_deps_pkg ON pkg_deps(name, repository);
+
+		CREATE TABLE IF NOT EXISTS repo_files (
+			repository TEXT NOT NULL,
+			path       TEXT NOT NULL,
+			mtime      INT  NOT NULL,
+			hash       TEXT NOT NULL,
+			pkg        TEXT NOT NULL,
+			UNIQUE(repository, path)
+		);
+
+		CREATE TABLE IF NOT EXISTS index_errors (
+			repository TEXT NOT NULL,
+			path       TEXT NOT NULL,
+			error      TEXT NOT NULL,
+			"commit"   TEXT NOT NULL,
+			UNIQUE(repository, path)
+		);
+
+		CREATE TABLE IF NOT EXISTS repo_refresh (
+			repository TEXT NOT NULL UNIQUE,
+			time       INT  NOT NULL
+		);
+
 		CREATE TABLE IF NOT EXISTS lure_db_version (
 			version INT NOT NULL
 		);
@@ -174,6 +239,22 @@ func reset(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	_, err = DB(ctx).ExecContext(ctx, "DROP TABLE IF EXISTS pkg_deps;")
+	if err != nil {
+		return err
+	}
+	_, err = DB(ctx).ExecContext(ctx, "DROP TABLE IF EXISTS repo_files;")
+	if err != nil {
+		return err
+	}
+	_, err = DB(ctx).ExecContext(ctx, "DROP TABLE IF EXISTS index_errors;")
+	if err != nil {
+		return err
+	}
+	_, err = DB(ctx).ExecContext(ctx, "DROP TABLE IF EXISTS repo_refresh;")
+	if err != nil {
+		return err
+	}
 	_, err = DB(ctx).ExecContext(ctx, "DROP TABLE IF EXISTS lure_db_version;")
 	return err
 }
@@ -204,9 +285,16 @@ func addVersion(ctx context.Context, ver int) error {
 	return err
 }
 
-// InsertPackage adds a package to the database
+// InsertPackage adds a package to the database, along with
+// its entries in the dependency index
 func InsertPackage(ctx context.Context, pkg Package) error {
-	_, err := DB(ctx).NamedExecContext(ctx, `
+	tx, err := DB(ctx).BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.NamedExecContext(ctx, `
 		INSERT OR REPLACE INTO pkgs (
 			name,
 			repository,
@@ -223,7 +311,10 @@ func InsertPackage(ctx context.Context, pkg Package) error {
 			replaces,
 			depends,
 			builddepends,
-			optdepends
+			optdepends,
+			path,
+			files,
+			overlay
 		) VALUES (
 			:name,
 			:repository,
@@ -240,32 +331,100 @@ func InsertPackage(ctx context.Context, pkg Package) error {
 			:replaces,
 			:depends,
 			:builddepends,
-			:optdepends
+			:optdepends,
+			:path,
+			:files,
+			:overlay
 		);
 	`, pkg)
-	return err
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM pkg_deps WHERE name = ? AND repository = ?", pkg.Name, pkg.Repository)
+	if err != nil {
+		return err
+	}
+
+	for _, dep := range Dependencies(pkg) {
+		_, err = tx.NamedExecContext(ctx, `
+			INSERT INTO pkg_deps (name, repository, type, override, dep)
+			VALUES (:name, :repository, :type, :override, :dep);
+		`, dep)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Dependencies flattens the dependency maps of a package
+// into rows for the dependency index
+func Dependencies(pkg Package) []Dependency {
+	var out []Dependency
+	add := func(depType string, deps map[string][]string) {
+		for override, list := range deps {
+			for _, dep := range list {
+				// Optional dependencies may contain a description
+				// after a colon, which isn't part of the name
+				dep, _, _ = strings.Cut(dep, ": ")
+				dep = strings.TrimSpace(dep)
+				if dep == "" {
+					continue
+				}
+
+				out = append(out, Dependency{
+					Name:       pkg.Name,
+					Repository: pkg.Repository,
+					Type:       depType,
+					Override:   override,
+					Dep:        dep,
+				})
+			}
+		}
+	}
+
+	add(DepTypeRuntime, pkg.Depends.Val)
+	add(DepTypeBuild, pkg.BuildDepends.Val)
+	add(DepTypeOptional, pkg.OptDepends.Val)
+	return out
 }
 
-// GetPkgs returns a result containing packages that match the where conditions
-func GetPkgs(ctx context.Context, where string, args ...any) (*sqlx.Rows, error) {
-	stream, err := DB(ctx).QueryxContext(ctx, "SELECT * FROM pkgs WHERE "+where, args...)
+// GetReverseDeps returns all the entries in the dependency index
+// that depend on any of the given names.
+func GetReverseDeps(ctx context.Context, names ...string) ([]Dependency, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM pkg_deps WHERE dep IN (?) ORDER BY repository, name, type, override", names)
 	if err != nil {
 		return nil, err
 	}
-	return stream, nil
-}
 
-// GetPkg returns a single package that matches the where conditions
-func GetPkg(ctx context.Context, where string, args ...any) (*Package, error) {
-	out := &Package{}
-	err := DB(ctx).GetContext(ctx, out, "SELECT * FROM pkgs WHERE "+where+" LIMIT 1", args...)
+	var out []Dependency
+	err = DB(ctx).SelectContext(ctx, &out, query, args...)
 	return out, err
 }
 
-// DeletePkgs deletes all packages matching the where conditions
-func DeletePkgs(ctx context.Context, where string, args ...any) error {
-	_, err := DB(ctx).ExecContext(ctx, "DELETE FROM pkgs WHERE "+where, args...)
-	return err
+// RenameRepo moves everything recorded for the
+// repository named from to the repository named to
+func RenameRepo(ctx context.Context, from, to string) error {
+	tx, err := DB(ctx).BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"pkgs", "pkg_deps", "repo_files", "index_errors", "repo_refresh"} {
+		_, err = tx.ExecContext(ctx, "UPDATE "+table+" SET repository = ? WHERE repository = ?", to, from)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // jsonArrayContains is an SQLite function that checks if a JSON array
@@ -333,6 +492,14 @@ func (s JSON[T]) Value() (driver.Value, error) {
 	return string(data), nil
 }
 
+func (s JSON[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Val)
+}
+
+func (s *JSON[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &s.Val)
+}
+
 func (s JSON[T]) MarshalYAML() (any, error) {
 	return s.Val, nil
 }
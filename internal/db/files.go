@@ -0,0 +1,66 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package db
+
+import (
+	"context"
+)
+
+// RepoFile records the state of the inputs of a package in a local
+// repository, such as its build script and the other files in its
+// directory, at the time it was last indexed, so that unchanged
+// packages can be skipped the next time the repository is indexed.
+type RepoFile struct {
+	Repository string `db:"repository"`
+	Path       string `db:"path"`
+	ModTime    int64  `db:"mtime"`
+	Hash       string `db:"hash"`
+	Package    string `db:"pkg"`
+}
+
+// GetRepoFiles returns the recorded state of all the
+// build scripts in the given repository
+func GetRepoFiles(ctx context.Context, repo string) ([]RepoFile, error) {
+	var out []RepoFile
+	err := DB(ctx).SelectContext(ctx, &out, "SELECT * FROM repo_files WHERE repository = ?", repo)
+	return out, err
+}
+
+// SetRepoFile records the state of a build script,
+// replacing any previously recorded state
+func SetRepoFile(ctx context.Context, f RepoFile) error {
+	_, err := DB(ctx).NamedExecContext(ctx, `
+		INSERT OR REPLACE INTO repo_files (repository, path, mtime, hash, pkg)
+		VALUES (:repository, :path, :mtime, :hash, :pkg);
+	`, f)
+	return err
+}
+
+// DeleteRepoFile removes the recorded state of a build script
+func DeleteRepoFile(ctx context.Context, repo, path string) error {
+	_, err := DB(ctx).ExecContext(ctx, "DELETE FROM repo_files WHERE repository = ? AND path = ?", repo, path)
+	return err
+}
+
+// DeleteRepoFiles removes the recorded state of all
+// the build scripts in the given repository
+func DeleteRepoFiles(ctx context.Context, repo string) error {
+	_, err := DB(ctx).ExecContext(ctx, "DELETE FROM repo_files WHERE repository = ?", repo)
+	return err
+}
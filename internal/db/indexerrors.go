@@ -0,0 +1,70 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package db
+
+import (
+	"context"
+)
+
+// IndexError records a build script that couldn't be indexed,
+// so that one broken package doesn't stop its repo from being
+// used, and the problem can be reported later.
+type IndexError struct {
+	Repository string `db:"repository"`
+	Path       string `db:"path"`
+	Error      string `db:"error"`
+	// Commit is the commit of a git repo that contained the broken
+	// build script. It's empty for repos that aren't git repos.
+	Commit string `db:"commit"`
+}
+
+// GetIndexErrors returns the recorded index errors of the given
+// repository, or of all repositories if repo is empty
+func GetIndexErrors(ctx context.Context, repo string) ([]IndexError, error) {
+	var out []IndexError
+	err := DB(ctx).SelectContext(ctx, &out, `
+		SELECT * FROM index_errors
+		WHERE ? = '' OR repository = ?
+		ORDER BY repository, path
+	`, repo, repo)
+	return out, err
+}
+
+// SetIndexError records an index error for a build script,
+// replacing any error previously recorded for it
+func SetIndexError(ctx context.Context, ie IndexError) error {
+	_, err := DB(ctx).NamedExecContext(ctx, `
+		INSERT OR REPLACE INTO index_errors (repository, path, error, "commit")
+		VALUES (:repository, :path, :error, :commit);
+	`, ie)
+	return err
+}
+
+// DeleteIndexError removes the index error recorded for a build script
+func DeleteIndexError(ctx context.Context, repo, path string) error {
+	_, err := DB(ctx).ExecContext(ctx, "DELETE FROM index_errors WHERE repository = ? AND path = ?", repo, path)
+	return err
+}
+
+// DeleteIndexErrors removes all the index
+// errors recorded for the given repository
+func DeleteIndexErrors(ctx context.Context, repo string) error {
+	_, err := DB(ctx).ExecContext(ctx, "DELETE FROM index_errors WHERE repository = ?", repo)
+	return err
+}
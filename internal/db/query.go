@@ -0,0 +1,339 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package db
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"go.elara.ws/vercmp"
+)
+
+// Predicate is a condition that packages must match
+// in order to be returned by a query.
+type Predicate struct {
+	cond string
+	args []any
+}
+
+// All matches every package
+func All() Predicate {
+	return Predicate{cond: "true"}
+}
+
+// ByName matches packages with the given name
+func ByName(name string) Predicate {
+	return Predicate{"name = ?", []any{name}}
+}
+
+// NameLike matches packages whose name matches the given
+// SQL LIKE pattern, in which "%" is a wildcard.
+func NameLike(pattern string) Predicate {
+	return Predicate{"name LIKE ?", []any{pattern}}
+}
+
+// DescriptionLike matches packages with a description in any
+// language that matches the given SQL LIKE pattern.
+func DescriptionLike(pattern string) Predicate {
+	return Predicate{"description LIKE ?", []any{pattern}}
+}
+
+// ByProvides matches packages that provide the given name
+func ByProvides(name string) Predicate {
+	return Predicate{"json_array_contains(provides, ?)", []any{name}}
+}
+
+// InRepo matches packages from the given repository
+func InRepo(repo string) Predicate {
+	return Predicate{"repository = ?", []any{repo}}
+}
+
+// ByPath matches packages whose build script is located
+// at the given path relative to their repository
+func ByPath(path string) Predicate {
+	return Predicate{"path = ?", []any{path}}
+}
+
+// RootDir is how the root directory of a repository
+// is recorded in the files array of a package
+const RootDir = "./"
+
+// UsesFile matches packages whose build scripts accessed the file at
+// the given slash-separated path relative to their repository, or
+// listed the contents of the directory that contains it
+func UsesFile(file string) Predicate {
+	dir := RootDir
+	if d := path.Dir(file); d != "." {
+		dir = d + "/"
+	}
+
+	return Or(
+		Predicate{"json_array_contains(files, ?)", []any{file}},
+		Predicate{"json_array_contains(files, ?)", []any{dir}},
+	)
+}
+
+// SupportsArch matches packages that list the given
+// architecture in their architectures array
+func SupportsArch(arch string) Predicate {
+	return Predicate{"json_array_contains(architectures, ?)", []any{arch}}
+}
+
+// DependsOn matches packages that have the given name as a
+// runtime, build, or optional dependency for any override.
+func DependsOn(name string) Predicate {
+	return Predicate{
+		"EXISTS (SELECT 1 FROM pkg_deps WHERE pkg_deps.name = pkgs.name AND pkg_deps.repository = pkgs.repository AND pkg_deps.dep = ?)",
+		[]any{name},
+	}
+}
+
+// And matches packages that match all the given predicates
+func And(preds ...Predicate) Predicate {
+	return join(" AND ", preds)
+}
+
+// Or matches packages that match any of the given predicates
+func Or(preds ...Predicate) Predicate {
+	return join(" OR ", preds)
+}
+
+// Not matches packages that don't match the given predicate
+func Not(pred Predicate) Predicate {
+	return Predicate{"NOT (" + pred.sql() + ")", pred.args}
+}
+
+func join(sep string, preds []Predicate) Predicate {
+	if len(preds) == 0 {
+		return All()
+	}
+
+	conds := make([]string, len(preds))
+	var args []any
+	for i, pred := range preds {
+		conds[i] = "(" + pred.sql() + ")"
+		args = append(args, pred.args...)
+	}
+	return Predicate{strings.Join(conds, sep), args}
+}
+
+// sql returns the SQL condition of the predicate. The zero
+// value of Predicate matches every package.
+func (p Predicate) sql() string {
+	if p.cond == "" {
+		return "true"
+	}
+	return p.cond
+}
+
+// SortBy represents a value that packages can be sorted by
+type SortBy uint8
+
+const (
+	SortByNone SortBy = iota
+	SortByName
+	SortByRepo
+	SortByVersion
+)
+
+// Query describes which packages should be returned from the
+// database and in what order.
+type Query struct {
+	Where  Predicate
+	SortBy SortBy
+	Limit  int64
+}
+
+func (q Query) sql() (string, []any) {
+	query := "SELECT * FROM pkgs WHERE " + q.Where.sql()
+
+	switch q.SortBy {
+	case SortByName:
+		query += " ORDER BY name"
+	case SortByRepo:
+		query += " ORDER BY repository"
+	}
+
+	// Versions can't be compared in SQL, so packages sorted by
+	// version are sorted and limited after they're fetched
+	if q.Limit > 0 && q.SortBy != SortByVersion {
+		query += " LIMIT " + strconv.FormatInt(q.Limit, 10)
+	}
+
+	return query, q.Where.args
+}
+
+// PkgIterator iterates over the packages returned by a query.
+// It must be closed once it's no longer needed, unless Next
+// has returned false.
+type PkgIterator struct {
+	rows *sqlx.Rows
+	// pkgs holds the remaining packages when they had
+	// to be fetched up front, in which case rows is nil
+	pkgs []Package
+	pkg  Package
+	err  error
+}
+
+// Next advances the iterator to the next package. It returns false
+// once there are no more packages or an error occurs, at which
+// point the iterator is closed automatically.
+func (it *PkgIterator) Next() bool {
+	if it.rows == nil {
+		if len(it.pkgs) == 0 {
+			return false
+		}
+		it.pkg, it.pkgs = it.pkgs[0], it.pkgs[1:]
+		return true
+	}
+
+	if it.err != nil || !it.rows.Next() {
+		it.Close()
+		return false
+	}
+
+	it.pkg = Package{}
+	it.err = it.rows.StructScan(&it.pkg)
+	if it.err != nil {
+		it.Close()
+		return false
+	}
+
+	return true
+}
+
+// Package returns the current package
+func (it *PkgIterator) Package() Package {
+	return it.pkg
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *PkgIterator) Err() error {
+	if it.err != nil || it.rows == nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+// Close closes the iterator. It's safe to call Close multiple times.
+func (it *PkgIterator) Close() error {
+	if it.rows == nil {
+		it.pkgs = nil
+		return nil
+	}
+	return it.rows.Close()
+}
+
+// IterPkgs returns an iterator over the packages matching the query
+func IterPkgs(ctx context.Context, q Query) (*PkgIterator, error) {
+	if q.SortBy == SortByVersion {
+		pkgs, err := GetPkgs(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return &PkgIterator{pkgs: pkgs}, nil
+	}
+
+	query, args := q.sql()
+	rows, err := DB(ctx).QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &PkgIterator{rows: rows}, nil
+}
+
+// GetPkgs returns all the packages matching the query
+func GetPkgs(ctx context.Context, q Query) ([]Package, error) {
+	query, args := q.sql()
+	var out []Package
+	err := DB(ctx).SelectContext(ctx, &out, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.SortBy == SortByVersion {
+		sort.SliceStable(out, func(i, j int) bool {
+			return compareVersions(out[i], out[j]) < 0
+		})
+		if q.Limit > 0 && int64(len(out)) > q.Limit {
+			out = out[:q.Limit]
+		}
+	}
+
+	return out, nil
+}
+
+// compareVersions compares the full versions of two packages the
+// same way package managers do, first by epoch, then by version,
+// and finally by release.
+func compareVersions(a, b Package) int {
+	if a.Epoch != b.Epoch {
+		if a.Epoch < b.Epoch {
+			return -1
+		}
+		return 1
+	}
+
+	if c := vercmp.Compare(a.Version, b.Version); c != 0 {
+		return c
+	}
+
+	return a.Release - b.Release
+}
+
+// GetPkg returns a single package that matches the predicate
+func GetPkg(ctx context.Context, where Predicate) (*Package, error) {
+	query, args := Query{Where: where, Limit: 1}.sql()
+	out := &Package{}
+	err := DB(ctx).GetContext(ctx, out, query, args...)
+	return out, err
+}
+
+// CountPkgs returns the number of packages matching the predicate
+func CountPkgs(ctx context.Context, where Predicate) (int, error) {
+	var count int
+	err := DB(ctx).GetContext(ctx, &count, "SELECT count(1) FROM pkgs WHERE "+where.sql(), where.args...)
+	return count, err
+}
+
+// DeletePkgs deletes all packages matching the predicate,
+// along with their entries in the dependency index
+func DeletePkgs(ctx context.Context, where Predicate) error {
+	tx, err := DB(ctx).BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM pkg_deps WHERE (name, repository) IN (SELECT name, repository FROM pkgs WHERE "+where.sql()+")", where.args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM pkgs WHERE "+where.sql(), where.args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
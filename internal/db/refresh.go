@@ -0,0 +1,57 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type refreshTime struct {
+	Repository string `db:"repository"`
+	Time       int64  `db:"time"`
+}
+
+// GetRefreshTimes returns the last time each repository was successfully
+// pulled. Repositories that were never pulled aren't included.
+func GetRefreshTimes(ctx context.Context) (map[string]time.Time, error) {
+	var rows []refreshTime
+	err := DB(ctx).SelectContext(ctx, &rows, "SELECT * FROM repo_refresh")
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		out[row.Repository] = time.Unix(row.Time, 0)
+	}
+	return out, nil
+}
+
+// SetRefreshTime records the last time the given repository was successfully pulled
+func SetRefreshTime(ctx context.Context, repo string, t time.Time) error {
+	_, err := DB(ctx).ExecContext(ctx, "INSERT OR REPLACE INTO repo_refresh (repository, time) VALUES (?, ?)", repo, t.Unix())
+	return err
+}
+
+// DeleteRefreshTime removes the recorded refresh time of the given repository
+func DeleteRefreshTime(ctx context.Context, repo string) error {
+	_, err := DB(ctx).ExecContext(ctx, "DELETE FROM repo_refresh WHERE repository = ?", repo)
+	return err
+}
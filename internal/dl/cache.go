@@ -0,0 +1,361 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dl
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/dlcache"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+// CacheReport is the result of checking the download cache
+type CacheReport struct {
+	// Entries is the amount of entries in the cache
+	Entries int
+	// Corrupt contains the problem with each corrupt entry
+	Corrupt []error
+	// Removed is the amount of corrupt entries that were removed
+	Removed int
+}
+
+// VerifyCache checks every entry in the download cache. An entry is corrupt
+// if its manifest can't be read, or if the file or git repo it describes is
+// missing or broken. If repair is true, corrupt entries are removed, so that
+// their sources are downloaded again the next time they're needed.
+func VerifyCache(ctx context.Context, repair bool) (CacheReport, error) {
+	var report CacheReport
+
+	entries, err := os.ReadDir(dlcache.BasePath(ctx))
+	if errors.Is(err, fs.ErrNotExist) {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+
+	for _, entry := range entries {
+		report.Entries++
+		entryPath := filepath.Join(dlcache.BasePath(ctx), entry.Name())
+
+		acquire := lock.Shared
+		if repair {
+			acquire = lock.Exclusive
+		}
+
+		// Entries are locked the same way Download locks them, so that
+		// an entry that's still being downloaded isn't reported as corrupt
+		entryLock, err := acquire(ctx, cacheLockName(entry.Name()))
+		if err != nil {
+			return report, err
+		}
+
+		err = checkEntry(entryPath)
+		if err == nil {
+			entryLock.Release()
+			continue
+		}
+		report.Corrupt = append(report.Corrupt, fmt.Errorf("%s: %w", entryPath, err))
+
+		if repair {
+			err = os.RemoveAll(entryPath)
+			if err != nil {
+				entryLock.Release()
+				return report, err
+			}
+			report.Removed++
+
+			// The entry's lock isn't needed anymore
+			err = entryLock.Remove()
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+		entryLock.Release()
+	}
+
+	return report, nil
+}
+
+// checkEntry makes sure the cache entry at entryPath is intact
+func checkEntry(entryPath string) error {
+	if b, err := hex.DecodeString(filepath.Base(entryPath)); err != nil || len(b) != 20 {
+		return errors.New("not a cache entry")
+	}
+
+	fi, err := os.Stat(entryPath)
+	if err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return errors.New("not a directory")
+	}
+
+	m, err := getManifest(entryPath)
+	if err != nil {
+		return fmt.Errorf("manifest can't be read: %w", err)
+	}
+
+	switch m.Type {
+	case TypeFile:
+		if m.Name == "" || !filepath.IsLocal(m.Name) {
+			return fmt.Errorf("invalid file name in manifest: %q", m.Name)
+		}
+
+		fi, err := os.Stat(filepath.Join(entryPath, m.Name))
+		if err != nil {
+			return err
+		} else if !fi.Mode().IsRegular() {
+			return fmt.Errorf("%s: not a regular file", m.Name)
+		}
+	case TypeDir:
+		// Git sources are cached as repos, which are updated in place
+		if _, err := os.Stat(filepath.Join(entryPath, ".git")); err == nil {
+			r, err := git.PlainOpen(entryPath)
+			if err != nil {
+				return err
+			}
+
+			_, err = r.Head()
+			if err != nil {
+				return fmt.Errorf("HEAD: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown type in manifest: %d", m.Type)
+	}
+
+	return nil
+}
+
+// cacheLockName returns the name of the lock that protects
+// the cache entry with the given hash
+func cacheLockName(hash string) string {
+	return "dl-" + hash
+}
+
+// CacheEntry is an entry in the download cache
+type CacheEntry struct {
+	Manifest
+	// Hash is the hash of the URL, which is used as the entry's
+	// name, or the digest of a blob in the content-addressed store
+	Hash string
+	// Digest is the algorithm and digest of a blob in the
+	// content-addressed store, such as sha256:<digest>. It's
+	// empty for other entries.
+	Digest string
+	Path   string
+	// Size is the total size of the files in the entry, in bytes
+	Size int64
+}
+
+// Matches checks whether the entry was downloaded from the given URL,
+// is used by the package with the given name, or has the given hash
+// or digest.
+func (ce CacheEntry) Matches(s string) bool {
+	if s == ce.Hash || (ce.Digest != "" && s == ce.Digest) || slices.Contains(ce.Packages, s) {
+		return true
+	}
+
+	normalized, err := normalizeURL(s)
+	return ce.URL != "" && err == nil && redactURL(normalized) == ce.URL
+}
+
+// ListCache returns the entries in the download cache and the blobs in
+// the content-addressed store, with the least recently used ones first.
+// Corrupt entries are skipped, since they're removed by VerifyCache.
+// Entries created by older versions of LURE don't have a last used time,
+// so the time they were last modified is used instead.
+func ListCache(ctx context.Context) ([]CacheEntry, error) {
+	out, err := listStore(StorePath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := os.ReadDir(dlcache.BasePath(ctx))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, dirEntry := range dirEntries {
+		entryLock, err := lock.Shared(ctx, cacheLockName(dirEntry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := readEntry(filepath.Join(dlcache.BasePath(ctx), dirEntry.Name()))
+		entryLock.Release()
+		if err != nil {
+			continue
+		}
+
+		out = append(out, entry)
+	}
+
+	slices.SortStableFunc(out, func(a, b CacheEntry) int {
+		return a.LastUsed.Compare(b.LastUsed)
+	})
+
+	return out, nil
+}
+
+// readEntry reads the manifest and size of the cache entry at entryPath
+func readEntry(entryPath string) (CacheEntry, error) {
+	err := checkEntry(entryPath)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+
+	m, err := getManifest(entryPath)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+
+	// Entries created by older versions of LURE may
+	// contain credentials that were part of the URL
+	m.URL = redactURL(m.URL)
+
+	entry := CacheEntry{
+		Manifest: m,
+		Hash:     filepath.Base(entryPath),
+		Path:     entryPath,
+	}
+
+	err = filepath.WalkDir(entryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode().IsRegular() {
+			entry.Size += fi.Size()
+		}
+
+		if entry.LastUsed.IsZero() && path == entryPath {
+			entry.LastUsed = fi.ModTime()
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// RemoveCacheEntries removes the given entries from the download cache
+func RemoveCacheEntries(ctx context.Context, entries []CacheEntry) error {
+	for _, entry := range entries {
+		entryLock, err := lock.Exclusive(ctx, cacheLockName(entry.Hash))
+		if err != nil {
+			return err
+		}
+
+		err = os.RemoveAll(entry.Path)
+		if err != nil {
+			entryLock.Release()
+			return err
+		}
+
+		// The entry's lock isn't needed anymore
+		err = entryLock.Remove()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GCOptions contains the options for collecting garbage in the download cache
+type GCOptions struct {
+	// MaxAge is how long an entry may go unused before it's removed.
+	// If it's zero, entries aren't removed because of their age.
+	MaxAge time.Duration
+	// MaxSize is the maximum total size of the cache, in bytes. If the cache
+	// is larger, the least recently used entries are removed until it fits.
+	// If it's zero, the size of the cache isn't limited.
+	MaxSize int64
+	// DryRun causes the entries that would be removed
+	// to be returned without removing them.
+	DryRun bool
+}
+
+// GC removes the entries in the download cache that weren't used within
+// opts.MaxAge, and then the least recently used entries until the cache
+// is no larger than opts.MaxSize. It returns the entries that were removed.
+func GC(ctx context.Context, opts GCOptions) ([]CacheEntry, error) {
+	entries, err := ListCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	var remove []CacheEntry
+	for _, entry := range entries {
+		tooOld := opts.MaxAge > 0 && time.Since(entry.LastUsed) > opts.MaxAge
+		tooLarge := opts.MaxSize > 0 && total > opts.MaxSize
+		if !tooOld && !tooLarge {
+			// The entries are sorted by when they were last
+			// used, so none of the remaining ones are removed.
+			break
+		}
+
+		remove = append(remove, entry)
+		total -= entry.Size
+	}
+
+	if opts.DryRun {
+		return remove, nil
+	}
+	return remove, RemoveCacheEntries(ctx, remove)
+}
+
+// AutoGC limits the size of the download cache to the maximum size
+// set in the LURE config, by removing the least recently used entries.
+// If no maximum size is set, it does nothing.
+func AutoGC(ctx context.Context) error {
+	maxSize := config.Config(ctx).Cache.MaxSize
+	if maxSize <= 0 {
+		return nil
+	}
+
+	removed, err := GC(ctx, GCOptions{MaxSize: int64(maxSize) << 20})
+	if err != nil {
+		return err
+	}
+
+	if len(removed) > 0 {
+		loggerctx.From(ctx).Info("Removed least recently used sources from the download cache").Int("entries", len(removed)).Send()
+	}
+	return nil
+}
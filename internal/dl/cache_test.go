@@ -0,0 +1,183 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dl_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/dl"
+)
+
+// useTempCache points LURE's cache and lock directories at a new
+// temporary directory until the test ends, so that the test only sees
+// its own cache entries. The paths are generated once per process, so
+// the XDG variables only matter if this is the first test to use them.
+func useTempCache(ctx context.Context, t *testing.T) string {
+	t.Helper()
+
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+	paths := config.GetPaths(ctx)
+	cacheDir, lockDir := paths.CacheDir, paths.LockDir
+	paths.CacheDir = filepath.Join(tmp, "cache")
+	paths.LockDir = filepath.Join(tmp, "locks")
+
+	t.Cleanup(func() {
+		paths.CacheDir, paths.LockDir = cacheDir, lockDir
+	})
+
+	return tmp
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := useTempCache(ctx, t)
+
+	srcDir := filepath.Join(tmp, "src")
+	err := os.MkdirAll(srcDir, 0o755)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// The sources are downloaded in order, so the first one is the least recently used
+	for i, name := range []string{"a", "b", "c"} {
+		err = os.WriteFile(filepath.Join(srcDir, name), make([]byte, 1000), 0o644)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		err = dl.Download(ctx, dl.Options{
+			Name:        name,
+			URL:         "local:///" + name,
+			Destination: t.TempDir(),
+			LocalDir:    srcDir,
+			Package:     []string{"foo", "foo", "bar"}[i],
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	}
+
+	entries, err := dl.ListCache(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(entries) != 3 || entries[0].URL != "local:///a" || entries[2].URL != "local:///c" {
+		t.Fatalf("Expected entries for a, b, and c, got %+v", entries)
+	}
+
+	if !entries[1].Matches("foo") || !entries[1].Matches("local:///b") || entries[1].Matches("bar") {
+		t.Errorf("Expected entry for b to match foo and its URL, got %+v", entries[1])
+	}
+
+	removed, err := dl.GC(ctx, dl.GCOptions{MaxSize: 2500, DryRun: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(removed) != 1 || removed[0].URL != "local:///a" {
+		t.Fatalf("Expected only a to be removed, got %+v", removed)
+	}
+
+	if _, err = os.Stat(removed[0].Path); err != nil {
+		t.Errorf("Expected dry run to keep the entry, got %s", err)
+	}
+
+	removed, err = dl.GC(ctx, dl.GCOptions{MaxSize: 1500})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(removed) != 2 {
+		t.Fatalf("Expected a and b to be removed, got %+v", removed)
+	}
+
+	for _, entry := range removed {
+		_, err = os.Stat(filepath.Join(config.GetPaths(ctx).LockDir, "dl-"+entry.Hash+".lock"))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected the lock of a removed entry to be removed, got %v", err)
+		}
+	}
+
+	entries, err = dl.ListCache(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(entries) != 1 || entries[0].URL != "local:///c" {
+		t.Errorf("Expected only c to be kept, got %+v", entries)
+	}
+}
+
+func TestCacheRedactsURL(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("data"))
+	}))
+	defer srv.Close()
+
+	srcURL := strings.Replace(srv.URL, "://", "://user:s3cret@", 1) + "/file.txt"
+	err := dl.Download(ctx, dl.Options{
+		Name:        "file",
+		URL:         srcURL,
+		Destination: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	entries, err := dl.ListCache(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	var found bool
+	for _, entry := range entries {
+		if !entry.Matches(srcURL) {
+			continue
+		}
+		found = true
+
+		if strings.Contains(entry.URL, "s3cret") {
+			t.Errorf("Expected password to be redacted, got %s", entry.URL)
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected an entry matching the source URL, got %+v", entries)
+	}
+}
@@ -33,13 +33,17 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/purell"
 	"github.com/vmihailenco/msgpack/v5"
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/blake2s"
 	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/auth"
 	"lure.sh/lure/internal/dlcache"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/loggerctx"
 )
 
@@ -50,6 +54,10 @@ const manifestFileName = ".lure_cache_manifest"
 var (
 	ErrChecksumMismatch = errors.New("dl: checksums did not match")
 	ErrNoSuchHashAlgo   = errors.New("dl: invalid hashing algorithm")
+
+	// errCorruptBlob occurs when a blob in the content-addressed
+	// store doesn't match its digest
+	errCorruptBlob = errors.New("dl: blob in content-addressed store is corrupt")
 )
 
 // Downloaders contains all the downloaders in the order in which
@@ -90,6 +98,30 @@ type Options struct {
 	PostprocDisabled bool
 	Progress         io.Writer
 	LocalDir         string
+	// Auth provides the credentials for private sources.
+	// If it's nil, no credentials are used.
+	Auth *auth.Store
+	// Mirrors contains the rules that rewrite the URLs of sources,
+	// so that they're downloaded from mirrors before their own URLs.
+	Mirrors []types.Mirror
+	// Package is the name of the package the source belongs to.
+	// It's recorded in the cache, so that the cache entries of a
+	// package can be found later.
+	Package string
+
+	// storeDir is the path of the content-addressed store.
+	// If it's empty, the store isn't used.
+	storeDir string
+	// ctx cancels the download when it's done
+	ctx context.Context
+}
+
+// context returns the context of the download
+func (opts Options) context() context.Context {
+	if opts.ctx == nil {
+		return context.Background()
+	}
+	return opts.ctx
 }
 
 func (opts Options) NewHash() (hash.Hash, error) {
@@ -125,6 +157,14 @@ func (opts Options) NewHash() (hash.Hash, error) {
 type Manifest struct {
 	Type Type
 	Name string
+	// URL is the normalized URL the entry was downloaded from,
+	// with any password in it redacted
+	URL string
+	// Packages contains the names of the packages that use the entry
+	Packages []string
+	// LastUsed is when the entry was last downloaded,
+	// updated, or linked into a build
+	LastUsed time.Time
 }
 
 type Downloader interface {
@@ -162,21 +202,45 @@ type UpdatingDownloader interface {
 // using hard links. If the source is not found in the cache,
 // it downloads the source to a new cache directory and links it
 // to the destination.
+//
+// If the URL has ~mirror parameters, or is rewritten by one of the rules in
+// opts.Mirrors, the alternative URLs are tried in order until the source is
+// downloaded, and its checksum matches. The source is cached using its own
+// URL, regardless of which URL it was downloaded from.
 func Download(ctx context.Context, opts Options) (err error) {
 	log := loggerctx.From(ctx)
-	normalized, err := normalizeURL(opts.URL)
+	u, mirrors, err := splitMirrors(opts.URL)
+	if err != nil {
+		return err
+	}
+
+	normalized, err := normalizeURL(u)
 	if err != nil {
 		return err
 	}
 	opts.URL = normalized
 
+	// The mirrors aren't normalized, since they're only used
+	// to download the source, and not to find it in the cache.
+	urls := sourceURLs(opts.URL, mirrors, opts.Mirrors)
+
 	d := getDownloader(opts.URL)
 
 	if opts.CacheDisabled {
-		_, _, err = d.Download(opts)
+		// The destination can't be cleared between attempts,
+		// since it may contain other files.
+		_, _, err = downloadFirst(ctx, opts, urls, opts.Destination, false)
 		return err
 	}
 
+	// Prevent other LURE processes from modifying
+	// this cache entry while it's being used
+	cacheLock, err := lock.Exclusive(ctx, cacheLockName(fmt.Sprintf("%x", sha1.Sum([]byte(opts.URL)))))
+	if err != nil {
+		return err
+	}
+	defer cacheLock.Release()
+
 	var t Type
 	cacheDir, ok := dlcache.Get(ctx, opts.URL)
 	if ok {
@@ -192,6 +256,8 @@ func Download(ctx context.Context, opts Options) (err error) {
 				Destination:   cacheDir,
 				Progress:      opts.Progress,
 				LocalDir:      opts.LocalDir,
+				Auth:          opts.Auth,
+				ctx:           ctx,
 			})
 			if err != nil {
 				return err
@@ -202,6 +268,14 @@ func Download(ctx context.Context, opts Options) (err error) {
 		if err == nil {
 			t = m.Type
 
+			// Entries created by older versions of LURE don't have
+			// a URL, or have one that wasn't redacted
+			m.URL = redactURL(opts.URL)
+			err = touchManifest(cacheDir, m, opts.Package)
+			if err != nil {
+				return err
+			}
+
 			dest := filepath.Join(opts.Destination, m.Name)
 			ok, err := handleCache(cacheDir, dest, m.Name, t)
 			if err != nil {
@@ -226,27 +300,18 @@ func Download(ctx context.Context, opts Options) (err error) {
 		}
 	}
 
-	log.Info("Downloading source").Str("source", opts.Name).Str("downloader", d.Name()).Send()
-
 	cacheDir, err = dlcache.New(ctx, opts.URL)
 	if err != nil {
 		return err
 	}
 
-	t, name, err := d.Download(Options{
-		Hash:          opts.Hash,
-		HashAlgorithm: opts.HashAlgorithm,
-		Name:          opts.Name,
-		URL:           opts.URL,
-		Destination:   cacheDir,
-		Progress:      opts.Progress,
-		LocalDir:      opts.LocalDir,
-	})
+	opts.storeDir = StorePath(ctx)
+	t, name, err := downloadFirst(ctx, opts, urls, cacheDir, true)
 	if err != nil {
 		return err
 	}
 
-	err = writeManifest(cacheDir, Manifest{t, name})
+	err = touchManifest(cacheDir, Manifest{Type: t, Name: name, URL: redactURL(opts.URL)}, opts.Package)
 	if err != nil {
 		return err
 	}
@@ -256,6 +321,85 @@ func Download(ctx context.Context, opts Options) (err error) {
 	return err
 }
 
+// downloadFirst downloads the source described by opts to dest from the
+// first of the given URLs that works. If clean is true, the contents of
+// dest are removed after each failed attempt. If none of the URLs work,
+// the errors from all of them are returned together.
+func downloadFirst(ctx context.Context, opts Options, urls []string, dest string, clean bool) (Type, string, error) {
+	log := loggerctx.From(ctx)
+
+	var errs []error
+	for i, u := range urls {
+		// Another source may have failed, in which
+		// case the other URLs aren't tried
+		if err := ctx.Err(); err != nil {
+			return 0, "", err
+		}
+
+		d := getDownloader(u)
+		if _, ok := storedBlob(opts); ok && d.Name() == "file" {
+			log.Info("Source found in content-addressed store").Str("source", opts.Name).Send()
+		} else {
+			log.Info("Downloading source").Str("source", opts.Name).Str("downloader", d.Name()).Str("url", redactURL(u)).Send()
+		}
+
+		t, name, err := d.Download(Options{
+			Hash:             opts.Hash,
+			HashAlgorithm:    opts.HashAlgorithm,
+			Name:             opts.Name,
+			URL:              u,
+			Destination:      dest,
+			CacheDisabled:    opts.CacheDisabled,
+			PostprocDisabled: opts.PostprocDisabled,
+			Progress:         opts.Progress,
+			LocalDir:         opts.LocalDir,
+			Auth:             opts.Auth,
+			storeDir:         opts.storeDir,
+			ctx:              ctx,
+		})
+		if err == nil {
+			if i > 0 {
+				log.Info("Source downloaded from alternative URL").Str("source", opts.Name).Str("url", redactURL(u)).Send()
+			}
+			return t, name, nil
+		}
+
+		if len(urls) == 1 {
+			return 0, "", err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", redactURL(u), err))
+
+		if i < len(urls)-1 {
+			log.Warn("Error downloading source, trying next URL").Str("source", opts.Name).Err(err).Send()
+		}
+
+		if clean {
+			err = clearDir(dest)
+			if err != nil {
+				return 0, "", err
+			}
+		}
+	}
+
+	return 0, "", fmt.Errorf("%s: all %d URLs failed: %w", opts.Name, len(urls), errors.Join(errs...))
+}
+
+// clearDir removes everything inside dir, without removing dir itself
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // writeManifest writes the manifest to the specified cache directory.
 func writeManifest(cacheDir string, m Manifest) error {
 	fl, err := os.Create(filepath.Join(cacheDir, manifestFileName))
@@ -266,6 +410,16 @@ func writeManifest(cacheDir string, m Manifest) error {
 	return msgpack.NewEncoder(fl).Encode(m)
 }
 
+// touchManifest sets the last used time of the manifest to the current time,
+// adds pkg to its packages if it's not empty, and writes it to cacheDir.
+func touchManifest(cacheDir string, m Manifest, pkg string) error {
+	m.LastUsed = time.Now()
+	if pkg != "" && !slices.Contains(m.Packages, pkg) {
+		m.Packages = append(m.Packages, pkg)
+	}
+	return writeManifest(cacheDir, m)
+}
+
 // getManifest reads the manifest from the specified cache directory.
 func getManifest(cacheDir string) (m Manifest, err error) {
 	fl, err := os.Open(filepath.Join(cacheDir, manifestFileName))
@@ -21,6 +21,8 @@ package dl
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"net/http"
@@ -51,26 +53,57 @@ func (FileDownloader) MatchURL(string) bool {
 }
 
 // Download downloads a file using HTTP. If the file is
-// compressed using a supported format, it will be extracted
-func (FileDownloader) Download(opts Options) (Type, string, error) {
+// compressed using a supported format, it will be extracted.
+// If the file has a checksum and a file with the same checksum
+// is in the content-addressed store, it's used instead.
+func (fd FileDownloader) Download(opts Options) (Type, string, error) {
+	t, name, err := fd.download(opts, true)
+	if errors.Is(err, errCorruptBlob) {
+		// The blob was removed, so the file is downloaded again
+		return fd.download(opts, false)
+	}
+	return t, name, err
+}
+
+// download downloads a file, using the content-addressed store if useStore is true
+func (FileDownloader) download(opts Options, useStore bool) (Type, string, error) {
 	u, err := url.Parse(opts.URL)
 	if err != nil {
 		return 0, "", err
 	}
 
 	query := u.Query()
-
 	name := query.Get("~name")
-	query.Del("~name")
-
 	archive := query.Get("~archive")
-	query.Del("~archive")
+	u.RawQuery = removeParams(u.RawQuery, "~name", "~archive")
 
-	u.RawQuery = query.Encode()
+	// Local files are already on disk, so they're neither read
+	// from nor added to the content-addressed store
+	local := u.Scheme == "local"
+
+	var blob string
+	fromStore := false
+	if useStore && !local {
+		blob, fromStore = storedBlob(opts)
+	}
 
 	var r io.ReadCloser
 	var size int64
-	if u.Scheme == "local" {
+	if fromStore {
+		blobFl, err := os.Open(blob)
+		if err != nil {
+			return 0, "", err
+		}
+		fi, err := blobFl.Stat()
+		if err != nil {
+			return 0, "", err
+		}
+		size = fi.Size()
+		if name == "" {
+			name = path.Base(u.Path)
+		}
+		r = blobFl
+	} else if u.Scheme == "local" {
 		localFl, err := os.Open(filepath.Join(opts.LocalDir, u.Path))
 		if err != nil {
 			return 0, "", err
@@ -85,10 +118,25 @@ func (FileDownloader) Download(opts Options) (Type, string, error) {
 		}
 		r = localFl
 	} else {
-		res, err := http.Get(u.String())
+		req, err := http.NewRequestWithContext(opts.context(), http.MethodGet, u.String(), nil)
+		if err != nil {
+			return 0, "", err
+		}
+
+		err = opts.Auth.SetRequestAuth(req)
 		if err != nil {
 			return 0, "", err
 		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return 0, "", fmt.Errorf("%s: %s", u.Redacted(), res.Status)
+		}
 		size = res.ContentLength
 		if name == "" {
 			name = getFilename(res)
@@ -133,14 +181,23 @@ func (FileDownloader) Download(opts Options) (Type, string, error) {
 		return 0, "", err
 	}
 
-	var w io.Writer
+	writers := []io.Writer{fl, bar}
 	if opts.Hash != nil {
-		w = io.MultiWriter(fl, h, bar)
-	} else {
-		w = io.MultiWriter(fl, bar)
+		writers = append(writers, h)
+	}
+
+	// Files with a checksum are added to the content-addressed store
+	var bw *blobWriter
+	if opts.Hash != nil && opts.storeDir != "" && !fromStore && !local {
+		bw, err = newBlobWriter(opts.storeDir)
+		if err != nil {
+			return 0, "", err
+		}
+		defer bw.abort()
+		writers = append(writers, bw)
 	}
 
-	_, err = io.Copy(w, r)
+	_, err = io.Copy(io.MultiWriter(writers...), r)
 	if err != nil {
 		return 0, "", err
 	}
@@ -149,10 +206,26 @@ func (FileDownloader) Download(opts Options) (Type, string, error) {
 	if opts.Hash != nil {
 		sum := h.Sum(nil)
 		if !bytes.Equal(sum, opts.Hash) {
+			if fromStore {
+				err = os.Remove(blob)
+				if err != nil {
+					return 0, "", err
+				}
+				return 0, "", errCorruptBlob
+			}
 			return 0, "", ErrChecksumMismatch
 		}
 	}
 
+	if bw != nil {
+		err = bw.commit(blobPath(opts.storeDir, opts.HashAlgorithm, opts.Hash))
+	} else if fromStore {
+		err = touchBlob(blob)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
 	if opts.PostprocDisabled {
 		return TypeFile, name, nil
 	}
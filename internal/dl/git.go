@@ -77,8 +77,14 @@ func (GitDownloader) Download(opts Options) (Type, string, error) {
 		}
 	}
 
+	gitAuth, err := opts.Auth.GitAuth(u.String())
+	if err != nil {
+		return 0, "", err
+	}
+
 	co := &git.CloneOptions{
 		URL:               u.String(),
+		Auth:              gitAuth,
 		Depth:             depth,
 		Progress:          opts.Progress,
 		RecurseSubmodules: git.NoRecurseSubmodules,
@@ -88,13 +94,14 @@ func (GitDownloader) Download(opts Options) (Type, string, error) {
 		co.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
 	}
 
-	r, err := git.PlainClone(opts.Destination, false, co)
+	r, err := git.PlainCloneContext(opts.context(), opts.Destination, false, co)
 	if err != nil {
 		return 0, "", err
 	}
 
-	err = r.Fetch(&git.FetchOptions{
+	err = r.FetchContext(opts.context(), &git.FetchOptions{
 		RefSpecs: []config.RefSpec{"+refs/*:refs/*"},
+		Auth:     gitAuth,
 	})
 	if err != git.NoErrAlreadyUpToDate && err != nil {
 		return 0, "", err
@@ -167,7 +174,13 @@ func (GitDownloader) Update(opts Options) (bool, error) {
 		}
 	}
 
+	gitAuth, err := opts.Auth.GitAuth(u.String())
+	if err != nil {
+		return false, err
+	}
+
 	po := &git.PullOptions{
+		Auth:              gitAuth,
 		Depth:             depth,
 		Progress:          opts.Progress,
 		RecurseSubmodules: git.NoRecurseSubmodules,
@@ -180,7 +193,7 @@ func (GitDownloader) Update(opts Options) (bool, error) {
 	m, err := getManifest(opts.Destination)
 	manifestOK := err == nil
 
-	err = w.Pull(po)
+	err = w.PullContext(opts.context(), po)
 	if errors.Is(err, git.NoErrAlreadyUpToDate) {
 		return false, nil
 	} else if err != nil {
@@ -0,0 +1,133 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dl
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/types"
+)
+
+// splitMirrors removes the ~mirror parameters from the source URL u, and
+// returns it along with the mirrors, in the order they should be tried. The
+// other parameters of u that start with a tilde, such as ~name, are added
+// to each mirror that doesn't set them itself, since they describe the
+// source rather than where it's downloaded from.
+func splitMirrors(u string) (string, []string, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mirrors := pu.Query()["~mirror"]
+	if len(mirrors) == 0 {
+		return u, nil, nil
+	}
+	pu.RawQuery = removeParams(pu.RawQuery, "~mirror")
+
+	var tilde []string
+	for _, param := range strings.Split(pu.RawQuery, "&") {
+		if strings.HasPrefix(paramName(param), "~") {
+			tilde = append(tilde, param)
+		}
+	}
+
+	for i, mirror := range mirrors {
+		mu, err := url.Parse(mirror)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var params []string
+		if mu.RawQuery != "" {
+			params = append(params, mu.RawQuery)
+		}
+
+		mq := mu.Query()
+		for _, param := range tilde {
+			if !mq.Has(paramName(param)) {
+				params = append(params, param)
+			}
+		}
+		mu.RawQuery = strings.Join(params, "&")
+
+		mirrors[i] = mu.String()
+	}
+
+	return pu.String(), mirrors, nil
+}
+
+// removeParams returns the raw query q without the parameters with the
+// given names. The other parameters are kept as they are, rather than
+// being decoded and encoded again, which would sort them and could change
+// their encoding, since some servers depend on the exact query string.
+func removeParams(q string, names ...string) string {
+	var kept []string
+	for _, param := range strings.Split(q, "&") {
+		if param != "" && !slices.Contains(names, paramName(param)) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// paramName returns the decoded name of a parameter in a raw query
+func paramName(param string) string {
+	name, _, _ := strings.Cut(param, "=")
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// sourceURLs returns all of the URLs the source at u can be downloaded from,
+// in the order they should be tried. These are the URL itself and its mirrors,
+// each preceded by the URL it's rewritten to by the first of the given rules
+// that matches it, if there is one.
+func sourceURLs(u string, mirrors []string, rules []types.Mirror) []string {
+	var out []string
+	for _, su := range append([]string{u}, mirrors...) {
+		for _, rule := range rules {
+			if rule.Prefix != "" && strings.HasPrefix(su, rule.Prefix) {
+				rewritten := rule.Replacement + strings.TrimPrefix(su, rule.Prefix)
+				if !slices.Contains(out, rewritten) {
+					out = append(out, rewritten)
+				}
+				break
+			}
+		}
+
+		if !slices.Contains(out, su) {
+			out = append(out, su)
+		}
+	}
+	return out
+}
+
+// redactURL returns u with any password replaced by "xxxxx",
+// so that it can be logged
+func redactURL(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return pu.Redacted()
+}
@@ -0,0 +1,141 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dl_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lure.sh/lure/internal/dl"
+	"lure.sh/lure/internal/types"
+)
+
+func TestMirrors(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+	srcDir := filepath.Join(tmp, "src")
+	for dir, content := range map[string]string{"good": "good", "bad": "bad"} {
+		err := os.MkdirAll(filepath.Join(srcDir, dir), 0o755)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		err = os.WriteFile(filepath.Join(srcDir, dir, "file"), []byte(content), 0o644)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	}
+	sum := sha256.Sum256([]byte("good"))
+
+	type testCase struct {
+		name    string
+		url     string
+		mirrors []types.Mirror
+		ok      bool
+	}
+
+	for _, tc := range []testCase{
+		{"mirror", "local:///missing/file?~mirror=local:///good/file&~name=out", nil, true},
+		{"checksumMismatch", "local:///bad/file?~mirror=local:///missing/file&~mirror=local:///good/file&~name=out", nil, true},
+		{"rewrite", "local:///down/file?~name=out", []types.Mirror{{Prefix: "local:///down/", Replacement: "local:///good/"}}, true},
+		{"allFail", "local:///bad/file?~mirror=local:///missing/file&~name=out", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := t.TempDir()
+			err := dl.Download(ctx, dl.Options{
+				Name:          tc.name,
+				URL:           tc.url,
+				Hash:          sum[:],
+				Destination:   dest,
+				LocalDir:      srcDir,
+				Mirrors:       tc.mirrors,
+				CacheDisabled: true,
+			})
+			if !tc.ok {
+				if !errors.Is(err, dl.ErrChecksumMismatch) || !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("Expected the errors from both URLs, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			// The ~name parameter should apply to the mirrors as well
+			data, err := os.ReadFile(filepath.Join(dest, "out"))
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			if string(data) != "good" {
+				t.Errorf("Expected the file from the working mirror, got %q", data)
+			}
+		})
+	}
+}
+
+func TestMirrorQuery(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		queries = append(queries, req.URL.RawQuery)
+		if req.URL.Path != "/mirror" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.Write([]byte("data"))
+	}))
+	defer srv.Close()
+
+	dest := t.TempDir()
+	err := dl.Download(ctx, dl.Options{
+		Name:          "query",
+		URL:           srv.URL + "/missing?~mirror=" + url.QueryEscape(srv.URL+"/mirror?z=1&a=%2f&sig=a%2Bb") + "&~name=out",
+		Destination:   dest,
+		CacheDisabled: true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// The mirror's query shouldn't be sorted or encoded differently
+	if len(queries) != 2 || queries[0] != "" || queries[1] != "z=1&a=%2f&sig=a%2Bb" {
+		t.Errorf("Expected the original queries, got %q", queries)
+	}
+
+	_, err = os.Stat(filepath.Join(dest, "out"))
+	if err != nil {
+		t.Errorf("Expected the file from the mirror, got %s", err)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dl
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/config"
+)
+
+// hashAlgorithms contains the names of the hash algorithms supported in checksums
+var hashAlgorithms = []string{
+	"sha256",
+	"sha224",
+	"sha512",
+	"sha384",
+	"sha1",
+	"md5",
+	"blake2s-128",
+	"blake2s-256",
+	"blake2b-256",
+	"blake2b-512",
+}
+
+// StorePath returns the path of the content-addressed store. File sources
+// with a checksum are kept in it, named by the algorithm and the digest of
+// their contents, so that any source with the same checksum can be found in
+// it, regardless of the URL it was downloaded from.
+func StorePath(ctx context.Context) string {
+	return filepath.Join(config.GetPaths(ctx).CacheDir, "cas")
+}
+
+// blobPath returns the path of the blob with the given
+// digest in the content-addressed store at storeDir
+func blobPath(storeDir, algo string, digest []byte) string {
+	if algo == "" {
+		algo = "sha256"
+	}
+	return filepath.Join(storeDir, algo, hex.EncodeToString(digest))
+}
+
+// storedBlob returns the path of the blob in the content-addressed
+// store that matches the checksum in opts, if there is one
+func storedBlob(opts Options) (string, bool) {
+	if opts.storeDir == "" || opts.Hash == nil {
+		return "", false
+	}
+
+	path := blobPath(opts.storeDir, opts.HashAlgorithm, opts.Hash)
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	return path, true
+}
+
+// blobWriter writes a new blob to a temporary file in the content-addressed
+// store, so that it only becomes visible once its checksum has been verified
+type blobWriter struct {
+	*os.File
+}
+
+// newBlobWriter creates a blobWriter for the store at storeDir
+func newBlobWriter(storeDir string) (*blobWriter, error) {
+	err := os.MkdirAll(storeDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	fl, err := os.CreateTemp(storeDir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{fl}, nil
+}
+
+// commit adds the blob to the store at each of the given paths
+func (bw *blobWriter) commit(paths ...string) error {
+	defer bw.abort()
+
+	err := bw.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return err
+		}
+
+		// Blobs are never changed, so if one with the
+		// same digest exists, it already has this content.
+		err = os.Link(bw.Name(), path)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// abort removes the temporary file
+func (bw *blobWriter) abort() {
+	bw.Close()
+	os.Remove(bw.Name())
+}
+
+// ImportStore adds the files in dir to the content-addressed store, and
+// returns how many were added. If dir is a copy of the store from another
+// machine, its files are added with the algorithm and digest in their path,
+// after their digest is checked. Any other files are added using their
+// SHA-256 digest, which is the default algorithm for checksums.
+func ImportStore(ctx context.Context, dir string) (int, error) {
+	storeDir := StorePath(ctx)
+
+	var (
+		added int
+		errs  []error
+	)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		algo, digest := "sha256", []byte(nil)
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			dirName, name := filepath.Split(rel)
+			if b, err := hex.DecodeString(name); err == nil && slices.Contains(hashAlgorithms, filepath.Clean(dirName)) {
+				algo, digest = filepath.Clean(dirName), b
+			}
+		}
+
+		err = importBlob(storeDir, path, algo, digest)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			return nil
+		}
+
+		added++
+		return nil
+	})
+	if err != nil {
+		return added, err
+	}
+
+	return added, errors.Join(errs...)
+}
+
+// importBlob copies the file at path into the store at storeDir, using its
+// digest with the given algorithm. If digest isn't nil, the file is only
+// added if its digest matches.
+func importBlob(storeDir, path, algo string, digest []byte) error {
+	fl, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	h, err := Options{HashAlgorithm: algo}.NewHash()
+	if err != nil {
+		return err
+	}
+
+	bw, err := newBlobWriter(storeDir)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.MultiWriter(bw, h), fl)
+	if err != nil {
+		bw.abort()
+		return err
+	}
+
+	sum := h.Sum(nil)
+	if digest != nil && !bytes.Equal(sum, digest) {
+		bw.abort()
+		return ErrChecksumMismatch
+	}
+
+	return bw.commit(blobPath(storeDir, algo, sum))
+}
+
+// listStore returns the blobs in the content-addressed store at storeDir
+// as cache entries. The time a blob was last modified is when it was last
+// used, since it's updated whenever a blob is used.
+func listStore(storeDir string) ([]CacheEntry, error) {
+	var out []CacheEntry
+	for _, algo := range hashAlgorithms {
+		dirEntries, err := os.ReadDir(filepath.Join(storeDir, algo))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, dirEntry := range dirEntries {
+			fi, err := dirEntry.Info()
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+
+			out = append(out, CacheEntry{
+				Manifest: Manifest{Type: TypeFile, LastUsed: fi.ModTime()},
+				Hash:     dirEntry.Name(),
+				Digest:   algo + ":" + dirEntry.Name(),
+				Path:     filepath.Join(storeDir, algo, dirEntry.Name()),
+				Size:     fi.Size(),
+			})
+		}
+	}
+	return out, nil
+}
+
+// touchBlob updates the time a blob was last used
+func touchBlob(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
@@ -0,0 +1,145 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dl_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lure.sh/lure/internal/dl"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := useTempCache(ctx, t)
+
+	srcDir := filepath.Join(tmp, "src")
+	importDir := filepath.Join(tmp, "import")
+	for _, dir := range []string{srcDir, importDir} {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	}
+
+	downloaded := []byte("downloaded")
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/file" {
+			http.NotFound(res, req)
+			return
+		}
+		res.Write(downloaded)
+	}))
+	defer srv.Close()
+
+	download := func(url string, content []byte) error {
+		sum := sha256.Sum256(content)
+		return dl.Download(ctx, dl.Options{
+			Name:        url,
+			URL:         url,
+			Hash:        sum[:],
+			Destination: t.TempDir(),
+			LocalDir:    srcDir,
+		})
+	}
+
+	err := download(srv.URL+"/file", downloaded)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// Sources with the same checksum should be found in the store,
+	// even though the file they would be downloaded from doesn't exist
+	err = download(srv.URL+"/mirror/file", downloaded)
+	if err != nil {
+		t.Errorf("Expected source to be found in store, got %s", err)
+	}
+
+	imported := []byte("imported")
+	err = os.WriteFile(filepath.Join(importDir, "file"), imported, 0o644)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	added, err := dl.ImportStore(ctx, importDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	} else if added != 1 {
+		t.Fatalf("Expected 1 file to be added, got %d", added)
+	}
+
+	err = download(srv.URL+"/imported/file", imported)
+	if err != nil {
+		t.Errorf("Expected imported source to be found in store, got %s", err)
+	}
+
+	err = download(srv.URL+"/missing/file", []byte("missing"))
+	if err == nil {
+		t.Errorf("Expected source that isn't in store to be downloaded and fail")
+	}
+
+	// Local sources are already on disk, so they should neither be
+	// read from the store nor added to it
+	err = download("local:///imported/file", imported)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected local source not to be read from store, got %v", err)
+	}
+
+	local := []byte("local")
+	err = os.WriteFile(filepath.Join(srcDir, "local"), local, 0o644)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = download("local:///local", local)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// A copy of the store should be importable on another machine
+	entries, err := dl.ListCache(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	var blobs int
+	for _, entry := range entries {
+		if entry.Digest != "" {
+			blobs++
+		}
+	}
+
+	if blobs != 2 {
+		t.Fatalf("Expected 2 blobs in store, got %d", blobs)
+	}
+
+	added, err = dl.ImportStore(ctx, dl.StorePath(ctx))
+	if err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	} else if added != 2 {
+		t.Errorf("Expected 2 files to be added, got %d", added)
+	}
+}
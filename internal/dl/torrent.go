@@ -38,9 +38,14 @@ func (TorrentDownloader) Download(opts Options) (Type, string, error) {
 
 	opts.URL = strings.TrimPrefix(opts.URL, "torrent+")
 
-	cmd := exec.Command(aria2Path, "--summary-interval=0", "--log-level=warn", "--seed-time=0", "--dir="+opts.Destination, opts.URL)
+	cmd := exec.CommandContext(opts.context(), aria2Path, "--summary-interval=0", "--log-level=warn", "--seed-time=0", "--dir="+opts.Destination, opts.URL)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if opts.Progress != nil {
+		// The output is shown in place of a progress bar
+		cmd.Stdout = opts.Progress
+		cmd.Stderr = opts.Progress
+	}
 	err = cmd.Run()
 	if err != nil {
 		return 0, "", fmt.Errorf("aria2c returned an error: %w", err)
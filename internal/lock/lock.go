@@ -0,0 +1,235 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package lock implements advisory file locks that prevent
+// multiple LURE processes from modifying the same state
+// at the same time.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+// Names of the locks used by LURE
+const (
+	// Repos protects the repository directories
+	Repos = "repos"
+	// DB protects the package database
+	DB = "db"
+)
+
+// ErrTimeout is returned if a lock couldn't be acquired
+// before Timeout elapsed
+var ErrTimeout = errors.New("lock: timed out waiting for lock")
+
+// Timeout is the maximum amount of time to wait for a lock
+// held by another process. If it's zero, LURE waits forever.
+var Timeout time.Duration
+
+// pollInterval is how often a lock is retried while waiting for it
+const pollInterval = 100 * time.Millisecond
+
+// Lock represents an acquired lock
+type Lock struct {
+	fl        *os.File
+	exclusive bool
+}
+
+// Build returns the name of the lock protecting the build
+// directory of the package with the given name.
+func Build(pkgName string) string {
+	return "build-" + pkgName
+}
+
+// Shared acquires a shared lock with the given name. Any number of
+// processes may hold a shared lock at once, but not while another
+// process holds an exclusive lock with the same name.
+func Shared(ctx context.Context, name string) (*Lock, error) {
+	return acquire(ctx, name, syscall.LOCK_SH)
+}
+
+// Exclusive acquires an exclusive lock with the given name,
+// waiting for any other process holding it to release it.
+func Exclusive(ctx context.Context, name string) (*Lock, error) {
+	return acquire(ctx, name, syscall.LOCK_EX)
+}
+
+// Release releases the lock. It's safe to call Release on a nil lock.
+func (l *Lock) Release() error {
+	if l == nil || l.fl == nil {
+		return nil
+	}
+	defer l.fl.Close()
+
+	// The owner is cleared, so that processes waiting for the lock
+	// while it's only held by shared holders don't report an old one.
+	if l.exclusive {
+		_ = l.fl.Truncate(0)
+	}
+
+	err := syscall.Flock(int(l.fl.Fd()), syscall.LOCK_UN)
+	l.fl = nil
+	return err
+}
+
+// Remove deletes the file of an exclusive lock and releases it. It's used
+// once the state protected by the lock doesn't exist anymore, so that lock
+// files don't pile up. It's safe to call Remove on a nil lock.
+func (l *Lock) Remove() error {
+	if l == nil || l.fl == nil {
+		return nil
+	}
+
+	if !l.exclusive {
+		return errors.New("lock: only exclusive locks can be removed")
+	}
+
+	err := os.Remove(l.fl.Name())
+	if rerr := l.Release(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func acquire(ctx context.Context, name string, how int) (*Lock, error) {
+	log := loggerctx.From(ctx)
+
+	lockDir := config.GetPaths(ctx).LockDir
+	err := os.MkdirAll(lockDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	lockPath := filepath.Join(lockDir, name+".lock")
+
+	var deadline <-chan time.Time
+	if Timeout > 0 {
+		timer := time.NewTimer(Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	waiting := false
+	for {
+		fl, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(fl.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			// The previous holder may have removed the lock's file
+			// after this process opened it, in which case the lock
+			// doesn't protect anything, and the new file has to be
+			// locked instead.
+			if !isCurrent(fl, lockPath) {
+				_ = syscall.Flock(int(fl.Fd()), syscall.LOCK_UN)
+				fl.Close()
+				continue
+			}
+
+			if how == syscall.LOCK_EX {
+				err = writeOwner(fl)
+				if err != nil {
+					_ = syscall.Flock(int(fl.Fd()), syscall.LOCK_UN)
+					fl.Close()
+					return nil, err
+				}
+			}
+
+			return &Lock{fl: fl, exclusive: how == syscall.LOCK_EX}, nil
+		} else if !errors.Is(err, syscall.EWOULDBLOCK) {
+			fl.Close()
+			return nil, err
+		}
+
+		if !waiting {
+			waiting = true
+			if pid, cmd := readOwner(fl); pid != 0 {
+				log.Info(fmt.Sprintf("Waiting for lock held by PID %d (%s)", pid, cmd)).Str("name", name).Send()
+			} else {
+				log.Info("Waiting for lock held by another process").Str("name", name).Send()
+			}
+		}
+		fl.Close()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, fmt.Errorf("%w: %s", ErrTimeout, name)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// isCurrent checks whether fl is still the file at path
+func isCurrent(fl *os.File, path string) bool {
+	flInfo, err := fl.Stat()
+	if err != nil {
+		return false
+	}
+
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(flInfo, pathInfo)
+}
+
+// writeOwner records the current process as the holder of an exclusive
+// lock so that other processes can tell the user what they're waiting for.
+// Shared holders don't write it, since they'd overwrite each other.
+func writeOwner(fl *os.File) error {
+	err := fl.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	cmd := append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...)
+	_, err = fl.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"+strings.Join(cmd, " ")+"\n"), 0)
+	return err
+}
+
+// readOwner reads the PID and command line of the process
+// that last acquired the lock.
+func readOwner(fl *os.File) (int, string) {
+	data, err := os.ReadFile(fl.Name())
+	if err != nil {
+		return 0, "unknown"
+	}
+
+	pidStr, cmd, _ := strings.Cut(string(data), "\n")
+	pid, _ := strconv.Atoi(pidStr)
+	cmd = strings.TrimSpace(cmd)
+	if cmd == "" {
+		cmd = "unknown"
+	}
+	return pid, cmd
+}
@@ -0,0 +1,143 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/lock"
+)
+
+func setLockDir(t *testing.T) {
+	t.Helper()
+	config.GetPaths(context.Background()).LockDir = t.TempDir()
+	lock.Timeout = 200 * time.Millisecond
+}
+
+func TestExclusive(t *testing.T) {
+	setLockDir(t)
+	ctx := context.Background()
+
+	l, err := lock.Exclusive(ctx, "test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	_, err = lock.Exclusive(ctx, "test")
+	if !errors.Is(err, lock.ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+
+	_, err = lock.Shared(ctx, "test")
+	if !errors.Is(err, lock.ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+
+	err = l.Release()
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	l, err = lock.Exclusive(ctx, "test")
+	if err != nil {
+		t.Fatalf("Expected no error after release, got %s", err)
+	}
+	l.Release()
+}
+
+func TestShared(t *testing.T) {
+	setLockDir(t)
+	ctx := context.Background()
+
+	l1, err := lock.Shared(ctx, "test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	defer l1.Release()
+
+	l2, err := lock.Shared(ctx, "test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	defer l2.Release()
+
+	_, err = lock.Exclusive(ctx, "test")
+	if !errors.Is(err, lock.ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	setLockDir(t)
+	ctx := context.Background()
+	lockPath := filepath.Join(config.GetPaths(ctx).LockDir, "test.lock")
+
+	l, err := lock.Exclusive(ctx, "test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// Wait for the lock while it's being removed
+	lock.Timeout = 5 * time.Second
+	acquired := make(chan *lock.Lock)
+	go func() {
+		l, err := lock.Exclusive(ctx, "test")
+		if err != nil {
+			t.Errorf("Expected no error, got %s", err)
+		}
+		acquired <- l
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	err = l.Remove()
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	l = <-acquired
+	defer l.Release()
+
+	// The waiting process has to lock the new file rather than
+	// the removed one, or the lock wouldn't exclude anyone.
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Fatalf("Expected the lock file to be created again, got %s", err)
+	}
+
+	lock.Timeout = 200 * time.Millisecond
+	_, err = lock.Exclusive(ctx, "test")
+	if !errors.Is(err, lock.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+
+	shared, err := lock.Shared(ctx, "other")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	defer shared.Release()
+
+	if err := shared.Remove(); err == nil {
+		t.Errorf("Expected shared lock not to be removable")
+	}
+}
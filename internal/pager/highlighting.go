@@ -35,3 +35,14 @@ func SyntaxHighlightBash(r io.Reader, style string) (string, error) {
 	err = quick.Highlight(w, string(data), "bash", "terminal", style)
 	return w.String(), err
 }
+
+func SyntaxHighlightDiff(r io.Reader, style string) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	w := &bytes.Buffer{}
+	err = quick.Highlight(w, string(data), "diff", "terminal", style)
+	return w.String(), err
+}
@@ -0,0 +1,415 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package limits restricts the resources build scripts may use while they
+// run in the restricted environment, so that a single broken or malicious
+// script can't hang LURE or use up all of the system's memory.
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ErrLimitExceeded is returned if a script exceeds one of its limits
+var ErrLimitExceeded = errors.New("script exceeded its resource limits")
+
+// Default limits, used if the config doesn't set them
+const (
+	DefaultTimeout           = 10 * time.Second
+	DefaultMaxCommands       = 100_000
+	DefaultMaxMemory         = 64 // MiB
+	DefaultMaxBraceExpansion = 10_000
+)
+
+// stopGracePeriod is how long Run waits for a runner to stop once
+// its context is canceled before warning that it's still running
+const stopGracePeriod = time.Second
+
+// Limits contains the resource limits of a script.
+// A limit that's zero or less is disabled.
+type Limits struct {
+	// Timeout is the maximum wall-clock time the script may run for
+	Timeout time.Duration
+	// MaxCommands is the maximum amount of commands,
+	// including builtins and functions, the script may run
+	MaxCommands int
+	// MaxMemory is the maximum total size of the script's
+	// variables, in bytes
+	MaxMemory int
+	// MaxBraceExpansion is the maximum amount of words
+	// a single brace expansion in the script may produce
+	MaxBraceExpansion int
+}
+
+// FromConfig returns the limits set in the config. If a limit isn't set,
+// its default value is used. If the timeout is invalid, a warning is
+// logged and the default timeout is used instead.
+func FromConfig(ctx context.Context, cfg types.Limits) Limits {
+	l := Limits{
+		Timeout:           DefaultTimeout,
+		MaxCommands:       DefaultMaxCommands,
+		MaxMemory:         DefaultMaxMemory << 20,
+		MaxBraceExpansion: DefaultMaxBraceExpansion,
+	}
+
+	if cfg.ScriptTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.ScriptTimeout)
+		if err != nil {
+			loggerctx.From(ctx).Warn("Invalid script timeout, using default").Str("scriptTimeout", cfg.ScriptTimeout).Err(err).Send()
+		} else {
+			l.Timeout = timeout
+		}
+	}
+
+	if cfg.MaxCommands != 0 {
+		l.MaxCommands = cfg.MaxCommands
+	}
+
+	if cfg.MaxMemory != 0 {
+		l.MaxMemory = cfg.MaxMemory << 20
+	}
+
+	if cfg.MaxBraceExpansion != 0 {
+		l.MaxBraceExpansion = cfg.MaxBraceExpansion
+	}
+
+	return l
+}
+
+// Budget keeps track of the resources used by the scripts
+// a runner executes, and stops them once they exceed their limits.
+// Its CallHandler has to be set on the runner when it's created.
+type Budget struct {
+	limits   Limits
+	commands int
+}
+
+// New creates a new budget with the given limits
+func New(l Limits) *Budget {
+	return &Budget{limits: l}
+}
+
+// CallHandler is an interp.CallHandlerFunc that counts the commands
+// the script runs and checks the size of its variables before each one.
+func (b *Budget) CallHandler(ctx context.Context, args []string) ([]string, error) {
+	b.commands++
+	if b.limits.MaxCommands > 0 && b.commands > b.limits.MaxCommands {
+		return nil, fmt.Errorf("%w: ran more than %d commands", ErrLimitExceeded, b.limits.MaxCommands)
+	}
+
+	if b.limits.MaxMemory > 0 {
+		size := 0
+		interp.HandlerCtx(ctx).Env.Each(func(name string, vr expand.Variable) bool {
+			size += varSize(vr)
+			return size <= b.limits.MaxMemory
+		})
+
+		if size > b.limits.MaxMemory {
+			return nil, fmt.Errorf("%w: variables use more than %d MiB of memory", ErrLimitExceeded, b.limits.MaxMemory>>20)
+		}
+	}
+
+	return args, nil
+}
+
+// Run runs fl using runner, stopping it if it exceeds its limits. Brace
+// expansions are checked before the script runs, since a single large
+// one can use up a lot of memory before any limit is checked. C-style
+// for loops in fl are replaced with equivalent while loops while it runs,
+// so that the runner can be stopped while it's in one. Run doesn't return
+// until the runner has stopped, and fl is left as it was, so both can
+// be used again afterwards.
+func (b *Budget) Run(ctx context.Context, runner *interp.Runner, fl *syntax.File) error {
+	err := b.checkBraces(fl)
+	if err != nil {
+		return err
+	}
+
+	restore := rewriteLoops(fl)
+	defer restore()
+
+	b.commands = 0
+
+	runCtx := ctx
+	if b.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, b.limits.Timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(runCtx, fl)
+	}()
+
+	select {
+	case err = <-done:
+	case <-runCtx.Done():
+		// The runner stops before its next statement. If it's
+		// blocked in a statement, it has to finish that first.
+		select {
+		case err = <-done:
+		case <-time.After(stopGracePeriod):
+			loggerctx.From(ctx).Warn("Waiting for script to stop").Send()
+			err = <-done
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("%w: ran for longer than %s", ErrLimitExceeded, b.limits.Timeout)
+	}
+	return err
+}
+
+// loopVarPrefix is the prefix of the variables that keep track of
+// whether the loops created by rewriteLoops are in their first iteration
+const loopVarPrefix = "__lure_loop"
+
+// rewriteLoops replaces the C-style for loops in fl with while loops.
+// The runner checks whether it should stop before each iteration of a
+// while loop, but not of a C-style for loop, so a C-style for loop that
+// doesn't run any statements, or whose statements are all skipped once
+// the runner is stopped, would otherwise keep running forever.
+//
+// A loop such as
+//
+//	for ((init; cond; post)); do ...; done
+//
+// is replaced with
+//
+//	{ ((v = 0, init)); while ((v ? post : (v = 1), cond)); do ...; done; }
+//
+// so that post still runs when the loop continues, and the loop stops
+// once the runner is stopped. The ternary operator is used since the
+// runner's arithmetic operators don't short-circuit.
+//
+// rewriteLoops returns a function that puts the original loops back.
+func rewriteLoops(fl *syntax.File) (restore func()) {
+	var (
+		stmts []*syntax.Stmt
+		cmds  []syntax.Command
+	)
+
+	n := 0
+	syntax.Walk(fl, func(node syntax.Node) bool {
+		st, ok := node.(*syntax.Stmt)
+		if !ok {
+			return true
+		}
+
+		fc, ok := st.Cmd.(*syntax.ForClause)
+		if !ok {
+			return true
+		}
+
+		loop, ok := fc.Loop.(*syntax.CStyleLoop)
+		if !ok {
+			return true
+		}
+
+		n++
+		v := loopVarPrefix + strconv.Itoa(n)
+		stmts = append(stmts, st)
+		cmds = append(cmds, st.Cmd)
+
+		init := arithmOr(loop.Init, "1")
+		post := arithmOr(loop.Post, "0")
+		cond := arithmOr(loop.Cond, "1")
+
+		// Walk continues into the new block, so
+		// nested loops are replaced as well.
+		st.Cmd = &syntax.Block{
+			Lbrace: fc.ForPos,
+			Rbrace: fc.DonePos,
+			Stmts: []*syntax.Stmt{
+				{
+					Position: fc.ForPos,
+					Cmd: &syntax.ArithmCmd{
+						Left:  loop.Lparen,
+						Right: loop.Rparen,
+						X:     arithmOp(syntax.Comma, arithmOp(syntax.Assgn, arithmWord(v), arithmWord("0")), init),
+					},
+				},
+				{
+					Position: fc.ForPos,
+					Cmd: &syntax.WhileClause{
+						WhilePos: fc.ForPos,
+						DoPos:    fc.DoPos,
+						DonePos:  fc.DonePos,
+						Cond: []*syntax.Stmt{{
+							Position: loop.Lparen,
+							Cmd: &syntax.ArithmCmd{
+								Left:  loop.Lparen,
+								Right: loop.Rparen,
+								X: arithmOp(syntax.Comma,
+									arithmOp(syntax.TernQuest, arithmWord(v),
+										arithmOp(syntax.TernColon, post, arithmOp(syntax.Assgn, arithmWord(v), arithmWord("1")))),
+									cond,
+								),
+							},
+						}},
+						Do:     fc.Do,
+						DoLast: fc.DoLast,
+					},
+				},
+			},
+		}
+		return true
+	})
+
+	return func() {
+		// Nested loops are inside the loops that contain them,
+		// so they're put back first
+		for i := len(stmts) - 1; i >= 0; i-- {
+			stmts[i].Cmd = cmds[i]
+		}
+	}
+}
+
+// arithmOr returns expr, or a literal if it's nil
+func arithmOr(expr syntax.ArithmExpr, lit string) syntax.ArithmExpr {
+	if expr == nil {
+		return arithmWord(lit)
+	}
+	return expr
+}
+
+func arithmOp(op syntax.BinAritOperator, x, y syntax.ArithmExpr) *syntax.BinaryArithm {
+	return &syntax.BinaryArithm{Op: op, X: x, Y: y}
+}
+
+func arithmWord(lit string) *syntax.Word {
+	return &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: lit}}}
+}
+
+// checkBraces returns an error if any brace expansion
+// in fl produces more words than the limit allows
+func (b *Budget) checkBraces(fl *syntax.File) error {
+	if b.limits.MaxBraceExpansion <= 0 {
+		return nil
+	}
+
+	var err error
+	syntax.Walk(fl, func(node syntax.Node) bool {
+		word, ok := node.(*syntax.Word)
+		if !ok {
+			return err == nil
+		}
+
+		// SplitBraces replaces the word's parts,
+		// so it has to run on a copy of the word
+		split := *word
+		if !syntax.SplitBraces(&split) {
+			return true
+		}
+
+		if braceWords(&split, b.limits.MaxBraceExpansion) > b.limits.MaxBraceExpansion {
+			err = fmt.Errorf("%w: %s: brace expansion produces more than %d words", ErrLimitExceeded, word.Pos(), b.limits.MaxBraceExpansion)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// braceWords returns the amount of words the brace expansions in word
+// produce. Once the amount exceeds limit, counting stops, so that the
+// result can't overflow.
+func braceWords(word *syntax.Word, limit int) int {
+	words := 1
+	for _, part := range word.Parts {
+		br, ok := part.(*syntax.BraceExp)
+		if !ok {
+			continue
+		}
+
+		n := 0
+		if br.Sequence {
+			n = sequenceLen(br, limit)
+		} else {
+			for _, elem := range br.Elems {
+				n += braceWords(elem, limit)
+				if n > limit {
+					break
+				}
+			}
+		}
+
+		n = max(n, 1)
+		if n > limit || words > limit/n {
+			return limit + 1
+		}
+		words *= n
+	}
+	return words
+}
+
+// sequenceLen returns the amount of words the sequence
+// expression br produces, or limit+1 if it exceeds limit
+func sequenceLen(br *syntax.BraceExp, limit int) int {
+	from, err1 := strconv.Atoi(br.Elems[0].Lit())
+	to, err2 := strconv.Atoi(br.Elems[1].Lit())
+	if err1 != nil || err2 != nil {
+		// Sequences of characters, such as {a..z}
+		from = int(br.Elems[0].Lit()[0])
+		to = int(br.Elems[1].Lit()[0])
+	}
+
+	var incr uint64 = 1
+	if len(br.Elems) > 2 {
+		if n, err := strconv.Atoi(br.Elems[2].Lit()); err == nil && n != 0 {
+			incr = uint64(n)
+			if n < 0 {
+				incr = uint64(-n)
+			}
+		}
+	}
+
+	if from > to {
+		from, to = to, from
+	}
+
+	// The difference is computed using unsigned integers,
+	// since it may not fit in a signed one
+	n := (uint64(to)-uint64(from))/incr + 1
+	if n > uint64(limit) {
+		return limit + 1
+	}
+	return int(n)
+}
+
+// varSize returns the amount of memory used by the value of vr
+func varSize(vr expand.Variable) int {
+	size := len(vr.Str)
+	for _, s := range vr.List {
+		size += len(s)
+	}
+	for k, v := range vr.Map {
+		size += len(k) + len(v)
+	}
+	return size
+}
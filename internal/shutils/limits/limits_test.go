@@ -0,0 +1,155 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package limits_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"lure.sh/lure/internal/shutils/limits"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+var testLimits = limits.Limits{
+	Timeout:           time.Second,
+	MaxCommands:       1000,
+	MaxMemory:         1 << 20,
+	MaxBraceExpansion: 100,
+}
+
+func TestLimits(t *testing.T) {
+	type testCase struct {
+		name     string
+		script   string
+		exceeded bool
+	}
+
+	for _, tc := range []testCase{
+		{"ok", "name=test; version=1; for i in {1..10}; do :; done", false},
+		{"commands", "while true; do x=1; done", true},
+		{"timeout", "for ((;;)); do x=1; done", true},
+		{"memory", "s=x; while :; do s=$s$s; done", true},
+		{"braces", "x=({1..100000000})", true},
+		{"nestedBraces", "x=({a..z}{a..z}{a..z})", true},
+		{"bracesNotExpanded", "x='{1..100000000}'", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fl, err := syntax.NewParser().Parse(strings.NewReader(tc.script), "lure.sh")
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			budget := limits.New(testLimits)
+			runner, err := interp.New(interp.CallHandler(budget.CallHandler))
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			start := time.Now()
+			err = budget.Run(context.Background(), runner, fl)
+			if tc.exceeded && !errors.Is(err, limits.ErrLimitExceeded) {
+				t.Errorf("Expected ErrLimitExceeded, got %v", err)
+			} else if !tc.exceeded && err != nil {
+				t.Errorf("Expected no error, got %s", err)
+			}
+
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Expected script to be stopped within the timeout, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestLoopsStop(t *testing.T) {
+	for _, script := range []string{
+		"for ((;;)); do x=1; done",
+		"for ((i=0; ; i++)); do :; done",
+		"while :; do x=1; done",
+		"for ((;;)); do for ((;;)); do x=1; done; done",
+	} {
+		t.Run(script, func(t *testing.T) {
+			fl, err := syntax.NewParser().Parse(strings.NewReader(script), "lure.sh")
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			// Only the timeout can stop these loops
+			budget := limits.New(limits.Limits{Timeout: 100 * time.Millisecond})
+			runner, err := interp.New(interp.CallHandler(budget.CallHandler))
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			before := runtime.NumGoroutine()
+			err = budget.Run(context.Background(), runner, fl)
+			if !errors.Is(err, limits.ErrLimitExceeded) {
+				t.Errorf("Expected ErrLimitExceeded, got %v", err)
+			}
+
+			// The runner's goroutine should exit rather than
+			// spinning in the loop after Run returns.
+			deadline := time.Now().Add(time.Second)
+			for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if n := runtime.NumGoroutine(); n > before {
+				t.Errorf("Expected runner goroutine to exit, %d goroutines are left over", n-before)
+			}
+		})
+	}
+}
+
+func TestCStyleLoop(t *testing.T) {
+	const script = "s=; for ((i = 0; i < 5; i++)); do if ((i == 2)); then continue; fi; if ((i == 4)); then break; fi; s=$s$i; done"
+
+	fl, err := syntax.NewParser().Parse(strings.NewReader(script), "lure.sh")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	budget := limits.New(testLimits)
+	runner, err := interp.New(interp.CallHandler(budget.CallHandler))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = budget.Run(context.Background(), runner, fl)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// continue should still run the loop's post expression
+	if s := runner.Vars["s"].String(); s != "013" {
+		t.Errorf("Expected s to be 013, got %q", s)
+	}
+
+	if i := runner.Vars["i"].String(); i != "4" {
+		t.Errorf("Expected i to be 4, got %q", i)
+	}
+	// The caller's file should be left as it was
+	if _, ok := fl.Stmts[1].Cmd.(*syntax.ForClause); !ok {
+		t.Errorf("Expected the loop to be put back after running, got %T", fl.Stmts[1].Cmd)
+	}
+}
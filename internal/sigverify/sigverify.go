@@ -0,0 +1,340 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package sigverify verifies OpenPGP and SSH signatures
+// against a set of trusted keys, and creates SSH signatures.
+package sigverify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrUnsigned  = errors.New("no signature found")
+	ErrUntrusted = errors.New("signature was not made by a trusted key")
+)
+
+const (
+	pgpSigHeader = "-----BEGIN PGP SIGNATURE-----"
+	sshSigHeader = "-----BEGIN SSH SIGNATURE-----"
+	sshSigFooter = "-----END SSH SIGNATURE-----"
+	pgpKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+)
+
+// KeyRing contains the keys that are trusted to sign data
+type KeyRing struct {
+	pgp openpgp.EntityList
+	ssh []ssh.PublicKey
+}
+
+// LoadKeys creates a key ring from a list of keys. Each key may be
+// either an SSH public key in authorized_keys format, or the path to
+// a file containing armored OpenPGP public keys or SSH public keys.
+// Relative paths are resolved relative to baseDir.
+func LoadKeys(baseDir string, keys []string) (*KeyRing, error) {
+	kr := &KeyRing{}
+	for _, key := range keys {
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err == nil {
+			kr.ssh = append(kr.ssh, pub)
+			continue
+		}
+
+		path := expandPath(baseDir, key)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = kr.add(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return kr, nil
+}
+
+// add adds all the keys in data to the key ring
+func (kr *KeyRing) add(data []byte) error {
+	if bytes.Contains(data, []byte(pgpKeyHeader)) {
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		kr.pgp = append(kr.pgp, entities...)
+		return nil
+	}
+
+	found := false
+	for len(bytes.TrimSpace(data)) > 0 {
+		pub, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return err
+		}
+		kr.ssh = append(kr.ssh, pub)
+		data = rest
+		found = true
+	}
+
+	if !found {
+		return errors.New("no public keys found")
+	}
+
+	return nil
+}
+
+// Empty returns true if the key ring contains no keys
+func (kr *KeyRing) Empty() bool {
+	return len(kr.pgp) == 0 && len(kr.ssh) == 0
+}
+
+// VerifyCommit checks that the given git commit is signed by a trusted key
+func (kr *KeyRing) VerifyCommit(c *object.Commit) error {
+	// go-git stores both OpenPGP and SSH signatures
+	// in the PGPSignature field.
+	if c.PGPSignature == "" {
+		return ErrUnsigned
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	err := c.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return err
+	}
+
+	r, err := encoded.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return kr.Verify(r, []byte(c.PGPSignature), "git")
+}
+
+// Verify checks that sig is a valid signature of the data read from r
+// made by a trusted key. The signature may be either an armored OpenPGP
+// signature or an SSH signature. The namespace is only used for SSH
+// signatures, which are bound to the namespace they were created for.
+func (kr *KeyRing) Verify(r io.Reader, sig []byte, namespace string) error {
+	sig = bytes.TrimSpace(sig)
+	switch {
+	case bytes.HasPrefix(sig, []byte(pgpSigHeader)):
+		if len(kr.pgp) == 0 {
+			return ErrUntrusted
+		}
+		_, err := openpgp.CheckArmoredDetachedSignature(kr.pgp, r, bytes.NewReader(sig), nil)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUntrusted, err)
+		}
+		return nil
+	case bytes.HasPrefix(sig, []byte(sshSigHeader)):
+		return kr.verifySSH(r, sig, namespace)
+	default:
+		return errors.New("unknown signature format")
+	}
+}
+
+// sshSig is the wire format of an SSH signature, as described in
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+type sshSig struct {
+	Magic     [6]byte
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  []byte
+	HashAlg   string
+	Signature []byte
+}
+
+// sshSignedData is the data that's actually signed in an SSH signature
+type sshSignedData struct {
+	Magic     [6]byte
+	Namespace string
+	Reserved  []byte
+	HashAlg   string
+	Hash      []byte
+}
+
+var sshSigMagic = [6]byte{'S', 'S', 'H', 'S', 'I', 'G'}
+
+func (kr *KeyRing) verifySSH(r io.Reader, armored []byte, namespace string) error {
+	body := bytes.TrimPrefix(armored, []byte(sshSigHeader))
+	body, _, ok := bytes.Cut(body, []byte(sshSigFooter))
+	if !ok {
+		return errors.New("malformed SSH signature")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return err
+	}
+
+	var sig sshSig
+	err = ssh.Unmarshal(blob, &sig)
+	if err != nil {
+		return err
+	}
+
+	if sig.Magic != sshSigMagic || sig.Version != 1 {
+		return errors.New("malformed SSH signature")
+	}
+
+	if sig.Namespace != namespace {
+		return fmt.Errorf("SSH signature has namespace %q, expected %q", sig.Namespace, namespace)
+	}
+
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if !kr.trustsSSH(pub) {
+		return ErrUntrusted
+	}
+
+	var h hash.Hash
+	switch sig.HashAlg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported SSH signature hash algorithm: %s", sig.HashAlg)
+	}
+
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+
+	signed := ssh.Marshal(sshSignedData{
+		Magic:     sshSigMagic,
+		Namespace: sig.Namespace,
+		Reserved:  sig.Reserved,
+		HashAlg:   sig.HashAlg,
+		Hash:      h.Sum(nil),
+	})
+
+	var s ssh.Signature
+	err = ssh.Unmarshal(sig.Signature, &s)
+	if err != nil {
+		return err
+	}
+
+	// ssh-rsa signatures use SHA-1, which ssh-keygen also refuses
+	// for SSH signatures, so only the SHA-2 RSA variants are accepted.
+	if s.Format == ssh.KeyAlgoRSA {
+		return fmt.Errorf("%w: unsupported signature algorithm: %s", ErrUntrusted, s.Format)
+	}
+
+	err = pub.Verify(signed, &s)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUntrusted, err)
+	}
+
+	return nil
+}
+
+// SignSSH creates an armored SSH signature of the data read from r
+// using the given signer, bound to the given namespace.
+func SignSSH(signer ssh.Signer, r io.Reader, namespace string) ([]byte, error) {
+	h := sha512.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := ssh.Marshal(sshSignedData{
+		Magic:     sshSigMagic,
+		Namespace: namespace,
+		HashAlg:   "sha512",
+		Hash:      h.Sum(nil),
+	})
+
+	var sig *ssh.Signature
+	// RSA signatures have to use SHA-2, since SHA-1 isn't
+	// accepted for SSH signatures anymore.
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := ssh.Marshal(sshSig{
+		Magic:     sshSigMagic,
+		Version:   1,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: namespace,
+		HashAlg:   "sha512",
+		Signature: ssh.Marshal(sig),
+	})
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	out := &bytes.Buffer{}
+	out.WriteString(sshSigHeader + "\n")
+	for len(encoded) > 70 {
+		out.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	out.WriteString(encoded + "\n")
+	out.WriteString(sshSigFooter + "\n")
+	return out.Bytes(), nil
+}
+
+func (kr *KeyRing) trustsSSH(pub ssh.PublicKey) bool {
+	marshaled := pub.Marshal()
+	for _, key := range kr.ssh {
+		if bytes.Equal(key.Marshal(), marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPath expands a leading ~ to the user's home directory
+// and resolves relative paths relative to baseDir.
+func expandPath(baseDir, path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return path
+}
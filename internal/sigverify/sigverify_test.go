@@ -0,0 +1,87 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sigverify_test
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"lure.sh/lure/internal/sigverify"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	return signer
+}
+
+func TestSSHSignature(t *testing.T) {
+	trusted := newSigner(t)
+	untrusted := newSigner(t)
+
+	kr, err := sigverify.LoadKeys(t.TempDir(), []string{string(ssh.MarshalAuthorizedKey(trusted.PublicKey()))})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	const data = "some data"
+
+	sig, err := sigverify.SignSSH(trusted, strings.NewReader(data), "test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = kr.Verify(strings.NewReader(data), sig, "test")
+	if err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+
+	err = kr.Verify(strings.NewReader("other data"), sig, "test")
+	if !errors.Is(err, sigverify.ErrUntrusted) {
+		t.Errorf("Expected modified data to be rejected, got %v", err)
+	}
+
+	err = kr.Verify(strings.NewReader(data), sig, "other")
+	if err == nil {
+		t.Errorf("Expected signature with a different namespace to be rejected")
+	}
+
+	sig, err = sigverify.SignSSH(untrusted, strings.NewReader(data), "test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = kr.Verify(strings.NewReader(data), sig, "test")
+	if !errors.Is(err, sigverify.ErrUntrusted) {
+		t.Errorf("Expected signature from untrusted key to be rejected, got %v", err)
+	}
+}
@@ -20,17 +20,82 @@ package types
 
 // Config represents the LURE configuration file
 type Config struct {
-	RootCmd          string   `toml:"rootCmd"`
-	PagerStyle       string   `toml:"pagerStyle"`
-	IgnorePkgUpdates []string `toml:"ignorePkgUpdates"`
-	Repos            []Repo   `toml:"repo"`
-	Unsafe           Unsafe   `toml:"unsafe"`
+	RootCmd          string            `toml:"rootCmd"`
+	PagerStyle       string            `toml:"pagerStyle"`
+	IgnorePkgUpdates []string          `toml:"ignorePkgUpdates"`
+	Refresh          string            `toml:"refresh,omitempty"`
+	Repos            []Repo            `toml:"repo"`
+	Pin              map[string]string `toml:"pin,omitempty"`
+	PinVersion       map[string]string `toml:"pinVersion,omitempty"`
+	Auth             []Auth            `toml:"auth,omitempty"`
+	Mirrors          []Mirror          `toml:"mirror,omitempty"`
+	Limits           Limits            `toml:"limits"`
+	Cache            Cache             `toml:"cache"`
+	// ParallelDownloads is the amount of sources that are downloaded at once
+	ParallelDownloads int    `toml:"parallelDownloads,omitempty"`
+	Unsafe            Unsafe `toml:"unsafe"`
 }
 
 // Repo represents a LURE repo within a configuration file
 type Repo struct {
-	Name string `toml:"name"`
-	URL  string `toml:"url"`
+	Name     string `toml:"name"`
+	Type     string `toml:"type,omitempty"`
+	URL      string `toml:"url,omitempty"`
+	Path     string `toml:"path,omitempty"`
+	Ref      string `toml:"ref,omitempty"`
+	Commit   string `toml:"commit,omitempty"`
+	Priority int    `toml:"priority,omitempty"`
+	// Disabled repos are kept in the config,
+	// but they aren't pulled or searched.
+	Disabled bool `toml:"disabled,omitempty"`
+
+	TrustedKeys      []string `toml:"trustedKeys,omitempty"`
+	VerifyAllCommits bool     `toml:"verifyAllCommits,omitempty"`
+	// AllowUnsigned lets HTTP repos be used without trustedKeys,
+	// in which case their index isn't verified.
+	AllowUnsigned bool `toml:"allowUnsigned,omitempty"`
+}
+
+// Auth contains the credentials LURE uses for a host. Secrets
+// aren't stored in the config itself, but read from environment
+// variables or files when they're needed.
+type Auth struct {
+	// Host is the hostname, optionally followed by a port
+	Host         string `toml:"host"`
+	Username     string `toml:"username,omitempty"`
+	PasswordEnv  string `toml:"passwordEnv,omitempty"`
+	PasswordFile string `toml:"passwordFile,omitempty"`
+	TokenEnv     string `toml:"tokenEnv,omitempty"`
+	TokenFile    string `toml:"tokenFile,omitempty"`
+	SSHKey       string `toml:"sshKey,omitempty"`
+	SSHAgent     bool   `toml:"sshAgent,omitempty"`
+}
+
+// Mirror rewrites the URLs of sources that start with Prefix, by
+// replacing the prefix with Replacement. The rewritten URL is tried
+// first, and the original URL is used if it doesn't work.
+type Mirror struct {
+	Prefix      string `toml:"prefix"`
+	Replacement string `toml:"replacement"`
+}
+
+// Limits restricts the resources build scripts may use while they're
+// parsed in the restricted environment. Limits that aren't set use
+// their default values, and negative limits are disabled.
+type Limits struct {
+	// ScriptTimeout is a duration, such as "10s"
+	ScriptTimeout string `toml:"scriptTimeout,omitempty"`
+	MaxCommands   int    `toml:"maxCommands,omitempty"`
+	// MaxMemory is in MiB
+	MaxMemory         int `toml:"maxMemory,omitempty"`
+	MaxBraceExpansion int `toml:"maxBraceExpansion,omitempty"`
+}
+
+// Cache contains the settings for LURE's download cache
+type Cache struct {
+	// MaxSize is in MiB. If the download cache grows larger after a
+	// build, the least recently used sources are removed until it fits.
+	MaxSize int `toml:"maxSize,omitempty"`
 }
 
 type Unsafe struct {
@@ -21,6 +21,31 @@ package types
 // RepoConfig represents a LURE repo's lure-repo.toml file.
 type RepoConfig struct {
 	Repo struct {
-		MinVersion string `toml:"minVersion"`
+		MinVersion  string   `toml:"minVersion"`
+		Description string   `toml:"description"`
+		Homepage    string   `toml:"homepage"`
+		Maintainers []string `toml:"maintainers"`
+		// Layout describes where the repo's build scripts are located.
+		// It's either a path such as "category/pkg/lure.sh", in which
+		// each directory stands for one level of nesting, or "recursive".
+		Layout string `toml:"layout"`
+		// RequiredFeatures and RequiredHelpers list the LURE features
+		// and helper commands that the repo's build scripts rely on.
+		RequiredFeatures []string `toml:"requiredFeatures"`
+		RequiredHelpers  []string `toml:"requiredHelpers"`
+	}
+
+	// Defaults contains variables that are set before
+	// every build script in the repo is run, so that
+	// scripts only have to set them if they're different.
+	Defaults map[string]any `toml:"defaults"`
+
+	Policy struct {
+		// Licenses lists the licenses packages in the repo may use.
+		// If it's empty, any license is allowed.
+		Licenses []string `toml:"licenses"`
+		// DeniedDeps lists packages that packages
+		// in the repo may not depend on.
+		DeniedDeps []string `toml:"deniedDeps"`
 	}
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/pkg/loggerctx"
 	"lure.sh/lure/pkg/manager"
 	"lure.sh/lure/pkg/repos"
@@ -44,19 +45,23 @@ var listCmd = &cli.Command{
 		ctx := c.Context
 		log := loggerctx.From(ctx)
 
-		err := repos.Pull(ctx, config.Config(ctx).Repos)
+		err := repos.AutoPull(ctx)
 		if err != nil {
 			log.Fatal("Error pulling repositories").Err(err).Send()
 		}
 
-		where := "true"
-		args := []any(nil)
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		defer dbLock.Release()
+
+		where := repos.EnabledRepos(ctx)
 		if c.NArg() > 0 {
-			where = "name LIKE ? OR json_array_contains(provides, ?)"
-			args = []any{c.Args().First(), c.Args().First()}
+			where = db.And(where, db.Or(db.NameLike(c.Args().First()), db.ByProvides(c.Args().First())))
 		}
 
-		result, err := db.GetPkgs(ctx, where, args...)
+		result, err := db.IterPkgs(ctx, db.Query{Where: where})
 		if err != nil {
 			log.Fatal("Error getting packages").Err(err).Send()
 		}
@@ -76,11 +81,7 @@ var listCmd = &cli.Command{
 		}
 
 		for result.Next() {
-			var pkg db.Package
-			err := result.StructScan(&pkg)
-			if err != nil {
-				return err
-			}
+			pkg := result.Package()
 
 			if slices.Contains(config.Config(ctx).IgnorePkgUpdates, pkg.Name) {
 				continue
@@ -99,7 +100,7 @@ var listCmd = &cli.Command{
 			fmt.Printf("%s/%s %s\n", pkg.Repository, pkg.Name, version)
 		}
 
-		if err != nil {
+		if err := result.Err(); err != nil {
 			log.Fatal("Error iterating over packages").Err(err).Send()
 		}
 
@@ -0,0 +1,299 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"go.elara.ws/vercmp"
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/cliutils"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/overrides"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/build"
+	"lure.sh/lure/pkg/distro"
+	"lure.sh/lure/pkg/loggerctx"
+	"lure.sh/lure/pkg/manager"
+	"lure.sh/lure/pkg/repos"
+)
+
+var lockCmd = &cli.Command{
+	Name:      "lock",
+	Usage:     "Write a lockfile containing the current versions of the given packages",
+	ArgsUsage: "[package...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Value:   "lure.lock",
+			Usage:   "Path to the lockfile",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		path := c.String("output")
+		names := c.Args().Slice()
+		if len(names) == 0 {
+			// Without any arguments, the packages in
+			// the existing lockfile are updated
+			lf, err := repos.ReadLockfile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Fatal("Command lock expected at least 1 argument when there's no existing lockfile").Send()
+			} else if err != nil {
+				log.Fatal("Error reading lockfile").Err(err).Send()
+			}
+
+			for _, lp := range lf.Packages {
+				names = append(names, lp.Name)
+			}
+		}
+
+		err := repos.AutoPull(ctx)
+		if err != nil {
+			log.Fatal("Error pulling repositories").Err(err).Send()
+		}
+
+		found, notFound, err := repos.FindPkgs(ctx, names)
+		if err != nil {
+			log.Fatal("Error finding packages").Err(err).Send()
+		}
+
+		if len(notFound) > 0 {
+			log.Fatal("Packages not found").Str("names", strings.Join(notFound, ", ")).Send()
+		}
+
+		pkgs := cliutils.FlattenPkgs(ctx, found, "lock", c.Bool("interactive"))
+		slices.SortFunc(pkgs, func(a, b db.Package) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		lf, err := repos.Lock(ctx, pkgs)
+		if err != nil {
+			log.Fatal("Error creating lockfile").Err(err).Send()
+		}
+
+		err = repos.WriteLockfile(path, lf)
+		if err != nil {
+			log.Fatal("Error writing lockfile").Err(err).Send()
+		}
+
+		log.Info("Wrote lockfile").Str("path", path).Int("packages", len(lf.Packages)).Send()
+		return nil
+	},
+}
+
+var syncCmd = &cli.Command{
+	Name:      "sync",
+	Usage:     "Install the exact package versions recorded in a lockfile",
+	ArgsUsage: "[lockfile]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "clean",
+			Aliases: []string{"c"},
+			Usage:   "Build packages from scratch even if there's an already built package available",
+		},
+		&cli.BoolFlag{
+			Name:  "remove-extras",
+			Usage: "Remove installed LURE packages that aren't in the lockfile or needed by the packages in it",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		args := c.Args()
+		if args.Len() > 1 {
+			log.Fatalf("Command sync expected at most 1 argument, got %d", args.Len()).Send()
+		}
+
+		path := "lure.lock"
+		if args.Len() == 1 {
+			path = args.First()
+		}
+
+		lf, err := repos.ReadLockfile(path)
+		if err != nil {
+			log.Fatal("Error reading lockfile").Err(err).Send()
+		}
+
+		for _, lr := range lf.Repos {
+			repo := findConfigRepo(ctx, lr.Name)
+			if lr.URL != "" && repo.URL != lr.URL {
+				log.Warn("Repository URL differs from the one in the lockfile").
+					Str("name", lr.Name).
+					Str("url", repo.URL).
+					Str("lockfile", lr.URL).
+					Send()
+			}
+		}
+
+		mgr := manager.Detect()
+		if mgr == nil {
+			log.Fatal("Unable to detect a supported package manager on the system").Send()
+		}
+
+		err = repos.AutoPull(ctx)
+		if err != nil {
+			log.Fatal("Error pulling repositories").Err(err).Send()
+		}
+
+		ctx, cleanup, err := repos.Checkout(ctx, lf)
+		if err != nil {
+			log.Fatal("Error checking out locked repositories").Err(err).Send()
+		}
+		defer cleanup()
+
+		installed, err := mgr.ListInstalled(nil)
+		if err != nil {
+			cleanup()
+			log.Fatal("Error listing installed packages").Err(err).Send()
+		}
+
+		var pkgs []db.Package
+		for _, lp := range lf.Packages {
+			if ver, ok := installed[lp.Name]; ok && vercmp.Compare(lp.FullVersion(), ver) == 0 {
+				continue
+			}
+			pkgs = append(pkgs, lp.Package())
+		}
+
+		if len(pkgs) > 0 {
+			build.InstallPkgs(ctx, pkgs, nil, types.BuildOpts{
+				Manager:     mgr,
+				Clean:       c.Bool("clean"),
+				Interactive: c.Bool("interactive"),
+			})
+		} else {
+			log.Info("All packages in the lockfile are already installed").Send()
+		}
+
+		if c.Bool("remove-extras") {
+			err = removeExtras(ctx, c.Bool("interactive"), mgr, lf, installed)
+			if err != nil {
+				cleanup()
+				log.Fatal("Error removing extra packages").Err(err).Send()
+			}
+		}
+
+		return nil
+	},
+}
+
+// removeExtras removes the installed LURE packages that aren't in the
+// lockfile and aren't needed by any of the packages that are, once the
+// user confirms it. If the user can't be asked, nothing is removed.
+func removeExtras(ctx context.Context, interactive bool, mgr manager.Manager, lf repos.Lockfile, installed map[string]string) error {
+	log := loggerctx.From(ctx)
+
+	info, err := distro.ParseOSRelease(ctx)
+	if err != nil {
+		return err
+	}
+
+	names, err := overrides.Resolve(info, overrides.DefaultOpts)
+	if err != nil {
+		return err
+	}
+
+	// Find everything the locked packages depend on, including the
+	// dependencies of LURE dependencies. The packages are parsed from
+	// the checked out repos, since their dependencies at the locked
+	// commits may differ from the ones in the DB.
+	needed := map[string]bool{}
+	var queue []db.Package
+	for _, lp := range lf.Packages {
+		needed[lp.Name] = true
+		queue = append(queue, lp.Package())
+	}
+
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+
+		parsed, err := repos.ParsePkg(ctx, pkg)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		resolved := overrides.ResolvePackage(&parsed, names)
+		for _, dep := range resolved.Depends {
+			if needed[dep] {
+				continue
+			}
+			needed[dep] = true
+
+			found, _, err := repos.FindPkgs(ctx, []string{dep})
+			if err != nil {
+				return err
+			}
+			queue = append(queue, found[dep]...)
+		}
+	}
+
+	var installedNames []string
+	for name := range installed {
+		installedNames = append(installedNames, name)
+	}
+
+	found, _, err := repos.FindPkgs(ctx, installedNames)
+	if err != nil {
+		return err
+	}
+
+	// A system package may have the same name as a LURE package, so
+	// only the packages whose installed version is one that LURE builds
+	// are considered to have been installed by LURE.
+	var extras []string
+	for name, pkgs := range found {
+		if needed[name] || !slices.ContainsFunc(pkgs, func(p db.Package) bool {
+			return p.Name == name && vercmp.Compare(repos.FullVersion(p), installed[name]) == 0
+		}) {
+			continue
+		}
+		extras = append(extras, name)
+	}
+
+	if len(extras) == 0 {
+		return nil
+	}
+	slices.Sort(extras)
+
+	log.Info("Installed packages not in the lockfile").Str("names", strings.Join(extras, ", ")).Send()
+	remove, err := cliutils.YesNoPrompt(ctx, "Would you like to remove them?", interactive, false)
+	if err != nil {
+		return err
+	} else if !remove {
+		if !interactive {
+			log.Warn("Not removing packages without confirmation, run with --interactive to remove them").Send()
+		}
+		return nil
+	}
+
+	return mgr.Remove(&manager.Opts{AsRoot: true}, extras...)
+}
@@ -28,11 +28,14 @@ import (
 	"github.com/mattn/go-isatty"
 	"github.com/urfave/cli/v2"
 	"go.elara.ws/logger"
+	"lure.sh/lure/internal/cliutils"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/translations"
 	"lure.sh/lure/pkg/loggerctx"
 	"lure.sh/lure/pkg/manager"
+	"lure.sh/lure/pkg/repos"
 )
 
 var app = &cli.App{
@@ -50,6 +53,15 @@ var app = &cli.App{
 			Value:   isatty.IsTerminal(os.Stdin.Fd()),
 			Usage:   "Enable interactive questions and prompts",
 		},
+		&cli.DurationFlag{
+			Name:  "lock-timeout",
+			Usage: "Maximum amount of time to wait for other LURE processes to finish (0 waits forever)",
+		},
+		&cli.BoolFlag{
+			Name:    "offline",
+			Aliases: []string{"no-refresh"},
+			Usage:   "Use the existing repository data instead of refreshing it",
+		},
 	},
 	Commands: []*cli.Command{
 		installCmd,
@@ -57,11 +69,18 @@ var app = &cli.App{
 		upgradeCmd,
 		infoCmd,
 		listCmd,
+		rdepsCmd,
 		buildCmd,
 		addrepoCmd,
 		removerepoCmd,
+		repoCmd,
 		refreshCmd,
+		lockCmd,
+		syncCmd,
+		versionsCmd,
+		unpinCmd,
 		fixCmd,
+		cacheCmd,
 		genCmd,
 		helperCmd,
 		versionCmd,
@@ -80,6 +99,9 @@ var app = &cli.App{
 			manager.Args = append(manager.Args, args...)
 		}
 
+		lock.Timeout = c.Duration("lock-timeout")
+		repos.Offline = c.Bool("offline")
+
 		return nil
 	},
 	After: func(ctx *cli.Context) error {
@@ -99,7 +121,7 @@ var versionCmd = &cli.Command{
 
 func main() {
 	ctx := context.Background()
-	log := translations.NewLogger(ctx, logger.NewCLI(os.Stderr), config.Language(ctx))
+	log := translations.NewLogger(ctx, logger.NewCLI(cliutils.Stderr), config.Language(ctx))
 	ctx = loggerctx.With(ctx, log)
 
 	// Set the root command to the one set in the LURE config
@@ -22,8 +22,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -39,14 +41,18 @@ import (
 
 	"github.com/goreleaser/nfpm/v2"
 	"github.com/goreleaser/nfpm/v2/files"
+	"golang.org/x/sync/errgroup"
+	"lure.sh/lure/internal/auth"
 	"lure.sh/lure/internal/cliutils"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/cpu"
 	"lure.sh/lure/internal/db"
 	"lure.sh/lure/internal/dl"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/shutils/decoder"
 	"lure.sh/lure/internal/shutils/handlers"
 	"lure.sh/lure/internal/shutils/helpers"
+	"lure.sh/lure/internal/shutils/limits"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/distro"
 	"lure.sh/lure/pkg/loggerctx"
@@ -67,7 +73,7 @@ func BuildPackage(ctx context.Context, opts types.BuildOpts) ([]string, []string
 		return nil, nil, err
 	}
 
-	fl, err := parseScript(info, opts.Script)
+	fl, prelude, err := parseScript(info, opts.Script)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -75,13 +81,48 @@ func BuildPackage(ctx context.Context, opts types.BuildOpts) ([]string, []string
 	// The first pass is just used to get variable values and runs before
 	// the script is displayed, so it's restricted so as to prevent malicious
 	// code from executing.
-	vars, err := executeFirstPass(ctx, info, fl, opts.Script)
+	vars, err := executeFirstPass(ctx, info, repos.WithPrelude(prelude, fl), opts.Script)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// Overlays are found using the name of the package, so
+	// they can only be applied after the first pass.
+	overlay, hasOverlay, err := repos.FindScriptOverlay(ctx, opts.Script, vars.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if hasOverlay {
+		log.Info("Applying overlay").Str("name", vars.Name).Str("dir", overlay.Dir).Send()
+
+		fl, err = overlay.Apply(fl)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := vars.Name
+		vars, err = executeFirstPass(ctx, info, repos.WithPrelude(prelude, fl), opts.Script, overlay.Dir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if vars.Name != name {
+			return nil, nil, fmt.Errorf("overlay %s: overlays can't change the name of a package", overlay.Dir)
+		}
+	}
+	fl = repos.WithPrelude(prelude, fl)
+
 	dirs := getDirs(ctx, vars, opts.Script)
 
+	// Make sure no other LURE process is building this package,
+	// since the build directory gets wiped before building.
+	buildLock, err := lock.Exclusive(ctx, lock.Build(vars.Name))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer buildLock.Release()
+
 	// If opts.Clean isn't set and we find the package already built,
 	// just return it rather than rebuilding
 	if !opts.Clean {
@@ -95,8 +136,18 @@ func BuildPackage(ctx context.Context, opts types.BuildOpts) ([]string, []string
 		}
 	}
 
+	// If the package has an overlay, the user is shown
+	// the changes it makes to the build script as well
+	var overlayDiff string
+	if hasOverlay {
+		overlayDiff, err = overlay.Diff(opts.Script)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Ask the user if they'd like to see the build script
-	err = cliutils.PromptViewScript(ctx, opts.Script, vars.Name, config.Config(ctx).PagerStyle, opts.Interactive)
+	err = cliutils.PromptViewScript(ctx, opts.Script, overlayDiff, vars.Name, config.Config(ctx).PagerStyle, opts.Interactive)
 	if err != nil {
 		log.Fatal("Failed to prompt user to view build script").Err(err).Send()
 	}
@@ -191,6 +242,13 @@ func BuildPackage(ctx context.Context, opts types.BuildOpts) ([]string, []string
 		return nil, nil, err
 	}
 
+	// The sources are kept in the cache, so it may
+	// have grown larger than its configured maximum
+	err = dl.AutoGC(ctx)
+	if err != nil {
+		log.Warn("Error limiting the size of the download cache").Err(err).Send()
+	}
+
 	// Add the path and name of the package we just built to the
 	// appropriate slices
 	pkgPaths := append(builtPaths, pkgPath)
@@ -205,42 +263,66 @@ func BuildPackage(ctx context.Context, opts types.BuildOpts) ([]string, []string
 	return pkgPaths, pkgNames, nil
 }
 
-// parseScript parses the build script using the built-in bash implementation
-func parseScript(info *distro.OSRelease, script string) (*syntax.File, error) {
+// parseScript parses the build script using the built-in bash implementation.
+// If the script is part of a repo, the prelude that sets the repo's default
+// variables is returned as well. It has to run before the script.
+func parseScript(info *distro.OSRelease, script string) (*syntax.File, *syntax.File, error) {
 	fl, err := os.Open(script)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer fl.Close()
 
 	file, err := syntax.NewParser().Parse(fl, "lure.sh")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return file, nil
+	repoCfg, err := repos.FindRepoConfig(script)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = repos.CheckRequirements(repoCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prelude, err := repos.Prelude(repoCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, prelude, nil
 }
 
 // executeFirstPass executes the parsed script in a restricted environment
-// to extract the build variables without executing any actual code.
-func executeFirstPass(ctx context.Context, info *distro.OSRelease, fl *syntax.File, script string) (*types.BuildVars, error) {
+// to extract the build variables without executing any actual code. The
+// script may only access files in its own directory and in extraDirs, and
+// it's stopped if it exceeds the limits set in the config.
+func executeFirstPass(ctx context.Context, info *distro.OSRelease, fl *syntax.File, script string, extraDirs ...string) (*types.BuildVars, error) {
 	scriptDir := filepath.Dir(script)
 	env := createBuildEnvVars(info, types.Directories{ScriptDir: scriptDir})
+	allowedDirs := append([]string{scriptDir}, extraDirs...)
+	budget := limits.New(limits.FromConfig(ctx, config.Config(ctx).Limits))
 
 	runner, err := interp.New(
 		interp.Env(expand.ListEnviron(env...)),
 		interp.StdIO(os.Stdin, os.Stdout, os.Stderr),
+		interp.CallHandler(budget.CallHandler),
 		interp.ExecHandler(helpers.Restricted.ExecHandler(handlers.NopExec)),
-		interp.ReadDirHandler(handlers.RestrictedReadDir(scriptDir)),
-		interp.StatHandler(handlers.RestrictedStat(scriptDir)),
-		interp.OpenHandler(handlers.RestrictedOpen(scriptDir)),
+		interp.ReadDirHandler(handlers.RestrictedReadDir(allowedDirs...)),
+		interp.StatHandler(handlers.RestrictedStat(allowedDirs...)),
+		interp.OpenHandler(handlers.RestrictedOpen(allowedDirs...)),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	err = runner.Run(ctx, fl)
-	if err != nil {
+	err = budget.Run(ctx, runner, fl)
+	if errors.Is(err, limits.ErrLimitExceeded) {
+		return nil, fmt.Errorf("%s: %w", script, err)
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -389,7 +471,11 @@ func buildLUREDeps(ctx context.Context, opts types.BuildOpts, vars *types.BuildV
 
 		// If there are multiple options for some packages, flatten them all into a single slice
 		pkgs := cliutils.FlattenPkgs(ctx, found, "install", opts.Interactive)
-		scripts := GetScriptPaths(ctx, pkgs)
+		scripts, err := GetScriptPaths(ctx, pkgs)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
 		for _, script := range scripts {
 			newOpts := opts
 			newOpts.Script = script
@@ -703,20 +789,45 @@ func createBuildEnvVars(info *distro.OSRelease, dirs types.Directories) []string
 	return env
 }
 
-// getSources downloads the sources from the script.
+// defaultParallelDownloads is the amount of sources that are
+// downloaded at once if it isn't set in the config
+const defaultParallelDownloads = 4
+
+// getSources downloads the sources from the script. Several
+// sources are downloaded at once, and if any of them fails,
+// the downloads of the others are canceled.
 func getSources(ctx context.Context, dirs types.Directories, bv *types.BuildVars) error {
 	log := loggerctx.From(ctx)
 	if len(bv.Sources) != len(bv.Checksums) {
 		log.Fatal("The checksums array must be the same length as sources").Send()
 	}
 
+	authStore, err := auth.New(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Each source is downloaded into its own directory, since sources
+	// that are downloaded at the same time could otherwise write to
+	// the same files. They're moved into the source directory once
+	// they've all been downloaded.
+	tmpDir, err := os.MkdirTemp(dirs.BaseDir, "sources-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	allOpts := make([]dl.Options, len(bv.Sources))
 	for i, src := range bv.Sources {
 		opts := dl.Options{
 			Name:        fmt.Sprintf("%s[%d]", bv.Name, i),
 			URL:         src,
-			Destination: dirs.SrcDir,
+			Destination: filepath.Join(tmpDir, strconv.Itoa(i)),
 			Progress:    os.Stderr,
 			LocalDir:    dirs.ScriptDir,
+			Auth:        authStore,
+			Mirrors:     config.Config(ctx).Mirrors,
+			Package:     bv.Name,
 		}
 
 		if !strings.EqualFold(bv.Checksums[i], "SKIP") {
@@ -740,7 +851,86 @@ func getSources(ctx context.Context, dirs types.Directories, bv *types.BuildVars
 			}
 		}
 
-		err := dl.Download(ctx, opts)
+		allOpts[i] = opts
+	}
+
+	parallel := config.Config(ctx).ParallelDownloads
+	if parallel <= 0 {
+		parallel = defaultParallelDownloads
+	}
+
+	// When several sources are downloaded at once,
+	// their progress bars are shown together
+	if parallel > 1 && len(allOpts) > 1 {
+		mb := cliutils.NewMultiBar()
+		defer mb.Close()
+
+		for i := range allOpts {
+			allOpts[i].Progress = mb.Bar()
+		}
+	}
+
+	// The first source that fails cancels the downloads of the others
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(parallel)
+	for _, opts := range allOpts {
+		opts := opts
+		g.Go(func() error {
+			err := os.MkdirAll(opts.Destination, 0o755)
+			if err != nil {
+				return err
+			}
+			return dl.Download(gctx, opts)
+		})
+	}
+
+	err = g.Wait()
+	if err != nil {
+		return err
+	}
+
+	// The sources are moved in order, so if several of them contain
+	// the same file, the last one wins, as if they were downloaded
+	// one after another.
+	for _, opts := range allOpts {
+		err = moveInto(opts.Destination, dirs.SrcDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveInto moves the contents of src into dst. Directories that exist
+// in both are merged, and any other files in dst are replaced.
+func moveInto(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		fi, err := os.Lstat(dstPath)
+		if err == nil && fi.IsDir() && entry.IsDir() {
+			err = moveInto(srcPath, dstPath)
+			if err != nil {
+				return err
+			}
+			continue
+		} else if err == nil {
+			err = os.RemoveAll(dstPath)
+			if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		err = os.Rename(srcPath, dstPath)
 		if err != nil {
 			return err
 		}
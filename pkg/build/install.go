@@ -20,12 +20,11 @@ package build
 
 import (
 	"context"
-	"path/filepath"
 
-	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/loggerctx"
+	"lure.sh/lure/pkg/repos"
 )
 
 // InstallPkgs installs native packages via the package manager,
@@ -40,18 +39,26 @@ func InstallPkgs(ctx context.Context, lurePkgs []db.Package, nativePkgs []string
 		}
 	}
 
-	InstallScripts(ctx, GetScriptPaths(ctx, lurePkgs), opts)
+	scripts, err := GetScriptPaths(ctx, lurePkgs)
+	if err != nil {
+		log.Fatal("Error getting build scripts").Err(err).Send()
+	}
+
+	InstallScripts(ctx, scripts, opts)
 }
 
 // GetScriptPaths returns a slice of script paths corresponding to the
 // given packages
-func GetScriptPaths(ctx context.Context, pkgs []db.Package) []string {
+func GetScriptPaths(ctx context.Context, pkgs []db.Package) ([]string, error) {
 	var scripts []string
 	for _, pkg := range pkgs {
-		scriptPath := filepath.Join(config.GetPaths(ctx).RepoDir, pkg.Repository, pkg.Name, "lure.sh")
+		scriptPath, err := repos.PackageScriptPath(ctx, pkg)
+		if err != nil {
+			return nil, err
+		}
 		scripts = append(scripts, scriptPath)
 	}
-	return scripts
+	return scripts, nil
 }
 
 // InstallScripts builds and installs the given LURE build scripts
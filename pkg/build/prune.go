@@ -0,0 +1,163 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package build
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/manager"
+)
+
+// PruneReport is the result of pruning the packages directory
+type PruneReport struct {
+	// Removed contains the paths that were removed,
+	// or that would have been removed in a dry run
+	Removed []string
+	// Size is the total size of the removed files, in bytes
+	Size int64
+}
+
+// Prune removes the files in the packages directory that aren't needed
+// anymore. These are the source and staging directories left behind by
+// builds, and built packages that aren't the current version of a package
+// in any repo. The built packages of current versions are kept, since
+// they're installed instead of building the packages again. If dryRun
+// is true, nothing is removed, but the report is still returned.
+func Prune(ctx context.Context, mgr manager.Manager, dryRun bool) (PruneReport, error) {
+	var report PruneReport
+	pkgsDir := config.GetPaths(ctx).PkgsDir
+	pkgFormat := getPkgFormat(mgr)
+
+	entries, err := os.ReadDir(pkgsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			err = report.remove(filepath.Join(pkgsDir, entry.Name()), dryRun)
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		err = prunePkgDir(ctx, &report, filepath.Join(pkgsDir, entry.Name()), pkgFormat, dryRun)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// prunePkgDir prunes the build directory of a single package. The
+// directory itself is removed if none of the files inside it are kept.
+func prunePkgDir(ctx context.Context, report *PruneReport, dir, pkgFormat string, dryRun bool) error {
+	name := filepath.Base(dir)
+
+	// Make sure the package isn't being built while it's pruned
+	buildLock, err := lock.Exclusive(ctx, lock.Build(name))
+	if err != nil {
+		return err
+	}
+	defer buildLock.Release()
+
+	pkgs, err := db.GetPkgs(ctx, db.Query{Where: db.ByName(name)})
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	for _, pkg := range pkgs {
+		filename, err := pkgFileName(&types.BuildVars{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Release: pkg.Release,
+			Epoch:   pkg.Epoch,
+		}, pkgFormat)
+		if err != nil {
+			return err
+		}
+		keep[filename] = true
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var remove []string
+	for _, entry := range entries {
+		if entry.IsDir() || !keep[entry.Name()] {
+			remove = append(remove, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	if len(remove) == len(entries) {
+		return report.remove(dir, dryRun)
+	}
+
+	for _, path := range remove {
+		err = report.remove(path, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove adds the size of the file or directory at path to the
+// report, and then removes it, unless dryRun is true
+func (r *PruneReport) remove(path string, dryRun bool) error {
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			r.Size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.Removed = append(r.Removed, path)
+	if dryRun {
+		return nil
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,167 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+)
+
+// backend is implemented by each kind of repo that LURE can pull
+type backend interface {
+	// Dir returns the directory containing the repo's files
+	Dir(ctx context.Context, repo types.Repo) string
+
+	// Update brings the repo's files up to date if necessary. It returns
+	// a function that writes the packages that changed to the DB, or nil
+	// if there's nothing to write. If full is true, all of the repo's
+	// packages have to be written, even if they didn't change. The
+	// function may be returned along with an error if the files were
+	// left in a usable state, in which case the DB is still written.
+	Update(ctx context.Context, repo types.Repo, full bool) (indexFunc, error)
+
+	// ScriptPath returns the path to the build script of the given
+	// package, fetching the package's files first if necessary.
+	ScriptPath(ctx context.Context, repo types.Repo, pkg db.Package) (string, error)
+
+	// Reindex returns a function that writes all of the repo's packages
+	// to the DB again, using the files that were already pulled rather
+	// than fetching them. If the repo was never pulled, it returns an
+	// error wrapping ErrNotPulled.
+	Reindex(ctx context.Context, repo types.Repo) (indexFunc, error)
+
+	// Check checks the repo's files for problems without changing them.
+	// If the repo was never pulled, it returns an error wrapping ErrNotPulled.
+	Check(ctx context.Context, repo types.Repo) error
+}
+
+// ErrNotPulled is returned if a repo's files are needed,
+// but the repo was never pulled
+var ErrNotPulled = errors.New("repository has not been pulled yet")
+
+// indexFunc writes the packages of a repo to the DB. Pull calls
+// index functions one at a time, so they never run concurrently.
+type indexFunc func(ctx context.Context) error
+
+// backendFor returns the backend that handles the given repo
+func backendFor(repo types.Repo) (backend, error) {
+	switch repo.Type {
+	case "git":
+		return gitBackend{}, nil
+	case "local":
+		return localBackend{}, nil
+	case "http":
+		return httpBackend{}, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("%s: unknown repository type: %s", repo.Name, repo.Type)
+	}
+
+	if repo.Path != "" {
+		return localBackend{}, nil
+	}
+
+	repoURL, err := url.Parse(repo.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if repoURL.Scheme == "file" {
+		return localBackend{}, nil
+	}
+
+	return gitBackend{}, nil
+}
+
+// Dir returns the directory containing the files of the given repo
+func Dir(ctx context.Context, repo types.Repo) string {
+	// Checkout replaces the directories of repos with the
+	// commits recorded in a lockfile
+	if dirs, ok := ctx.Value(dirOverridesKey{}).(map[string]string); ok {
+		if dir, ok := dirs[repo.Name]; ok {
+			return dir
+		}
+	}
+
+	b, err := backendFor(repo)
+	if err != nil {
+		return filepath.Join(config.GetPaths(ctx).RepoDir, repo.Name)
+	}
+	return b.Dir(ctx, repo)
+}
+
+// ScriptPath returns the path to the build script of the given
+// package in the repo with the given name. The package may also be
+// specified as the path of its directory within the repo, such as
+// "category/pkg". For repos that only fetch packages when they're
+// needed, such as HTTP repos, the package is fetched if it hasn't
+// been already.
+func ScriptPath(ctx context.Context, repoName, pkgName string) (string, error) {
+	where := db.ByName(pkgName)
+	if strings.Contains(pkgName, "/") {
+		where = db.ByPath(path.Join(pkgName, "lure.sh"))
+	}
+
+	pkg, err := db.GetPkg(ctx, db.And(where, db.InRepo(repoName)))
+	if errors.Is(err, sql.ErrNoRows) {
+		// The package may not have been indexed, for example because its
+		// build script is invalid, so fall back to the default layout.
+		pkg = &db.Package{Name: pkgName, Repository: repoName}
+	} else if err != nil {
+		return "", err
+	}
+
+	return PackageScriptPath(ctx, *pkg)
+}
+
+// PackageScriptPath returns the path to the build script of the given
+// package. Like ScriptPath, it fetches the package's files if necessary.
+func PackageScriptPath(ctx context.Context, pkg db.Package) (string, error) {
+	_, repo := findRepo(config.Config(ctx), pkg.Repository)
+	b, err := backendFor(repo)
+	if err != nil {
+		return "", err
+	}
+	return b.ScriptPath(ctx, repo, pkg)
+}
+
+// dirScriptPath returns the path to the build script of a package
+// in a backend that keeps all of its packages' files in its directory
+func dirScriptPath(ctx context.Context, repo types.Repo, pkg db.Package) (string, error) {
+	scriptPath := pkg.Path
+	if scriptPath == "" {
+		scriptPath = path.Join(pkg.Name, "lure.sh")
+	}
+
+	if !filepath.IsLocal(scriptPath) {
+		return "", fmt.Errorf("%s: invalid build script path: %s", repo.Name, scriptPath)
+	}
+
+	return filepath.Join(Dir(ctx, repo), filepath.FromSlash(scriptPath)), nil
+}
@@ -21,13 +21,20 @@ package repos
 import (
 	"context"
 
+	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
 )
 
 // FindPkgs looks for packages matching the inputs inside the database.
 // It returns a map that maps the package name input to any packages found for it.
 // It also returns a slice that contains the names of all packages that were not found.
+// If a package exists in multiple repos, only the one from the pinned or highest
+// priority repo is returned, and the packages are ordered so that the most
+// preferred one comes first. Packages from disabled repos are ignored.
 func FindPkgs(ctx context.Context, pkgs []string) (map[string][]db.Package, []string, error) {
+	cfg := config.Config(ctx)
+	enabled := enabledRepos(cfg)
 	found := map[string][]db.Package{}
 	notFound := []string(nil)
 
@@ -36,48 +43,39 @@ func FindPkgs(ctx context.Context, pkgs []string) (map[string][]db.Package, []st
 			continue
 		}
 
-		result, err := db.GetPkgs(ctx, "json_array_contains(provides, ?)", pkgName)
+		result, err := db.GetPkgs(ctx, db.Query{Where: db.And(db.ByProvides(pkgName), enabled)})
 		if err != nil {
 			return nil, nil, err
 		}
 
-		added := 0
-		for result.Next() {
-			var pkg db.Package
-			err = result.StructScan(&pkg)
+		if len(result) == 0 {
+			result, err = db.GetPkgs(ctx, db.Query{Where: db.And(db.NameLike(pkgName), enabled)})
 			if err != nil {
 				return nil, nil, err
 			}
-
-			added++
-			found[pkgName] = append(found[pkgName], pkg)
-		}
-		result.Close()
-
-		if added == 0 {
-			result, err := db.GetPkgs(ctx, "name LIKE ?", pkgName)
-			if err != nil {
-				return nil, nil, err
-			}
-
-			for result.Next() {
-				var pkg db.Package
-				err = result.StructScan(&pkg)
-				if err != nil {
-					return nil, nil, err
-				}
-
-				added++
-				found[pkgName] = append(found[pkgName], pkg)
-			}
-
-			result.Close()
 		}
 
-		if added == 0 {
+		if len(result) > 0 {
+			found[pkgName] = resolveDuplicates(cfg, append(found[pkgName], result...))
+		} else {
 			notFound = append(notFound, pkgName)
 		}
 	}
 
 	return found, notFound, nil
 }
+
+// EnabledRepos matches packages that aren't from disabled repos
+func EnabledRepos(ctx context.Context) db.Predicate {
+	return enabledRepos(config.Config(ctx))
+}
+
+func enabledRepos(cfg *types.Config) db.Predicate {
+	var preds []db.Predicate
+	for _, repo := range cfg.Repos {
+		if repo.Disabled {
+			preds = append(preds, db.Not(db.InRepo(repo.Name)))
+		}
+	}
+	return db.And(preds...)
+}
@@ -20,38 +20,29 @@ package repos_test
 
 import (
 	"context"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/repos"
 )
 
 func TestFindPkgs(t *testing.T) {
-	_, err := db.Open(":memory:")
-	if err != nil {
-		t.Fatalf("Expected no error, got %s", err)
-	}
-	defer db.Close()
-
-	setCfgDirs(t)
-	defer removeCacheDir(t)
-
 	ctx := context.Background()
+	tmp := setupTest(ctx, t)
 
-	err = repos.Pull(ctx, []types.Repo{
-		{
-			Name: "default",
-			URL:  "https://github.com/Arsen6331/lure-repo.git",
-		},
-	})
+	newTestRepo(ctx, t, "find", filepath.Join(tmp, "repo"))
+
+	err := repos.Pull(ctx, config.Config(ctx).Repos)
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
 
-	found, notFound, err := repos.FindPkgs([]string{"itd", "nonexistentpackage1", "nonexistentpackage2"})
+	found, notFound, err := repos.FindPkgs(ctx, []string{"itd", "nonexistentpackage1", "nonexistentpackage2"})
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
@@ -81,16 +72,12 @@ func TestFindPkgs(t *testing.T) {
 }
 
 func TestFindPkgsEmpty(t *testing.T) {
-	_, err := db.Open(":memory:")
-	if err != nil {
-		t.Fatalf("Expected no error, got %s", err)
-	}
-	defer db.Close()
+	ctx := context.Background()
+	setupTest(ctx, t)
 
-	setCfgDirs(t)
-	defer removeCacheDir(t)
+	config.Config(ctx).Repos = []types.Repo{{Name: "default"}}
 
-	err = db.InsertPackage(db.Package{
+	err := db.InsertPackage(ctx, db.Package{
 		Name:       "test1",
 		Repository: "default",
 		Version:    "0.0.1",
@@ -105,7 +92,7 @@ func TestFindPkgsEmpty(t *testing.T) {
 		t.Fatalf("Expected no error, got %s", err)
 	}
 
-	err = db.InsertPackage(db.Package{
+	err = db.InsertPackage(ctx, db.Package{
 		Name:       "test2",
 		Repository: "default",
 		Version:    "0.0.1",
@@ -120,7 +107,7 @@ func TestFindPkgsEmpty(t *testing.T) {
 		t.Fatalf("Expected no error, got %s", err)
 	}
 
-	found, notFound, err := repos.FindPkgs([]string{"test", ""})
+	found, notFound, err := repos.FindPkgs(ctx, []string{"test", ""})
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
@@ -0,0 +1,265 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/sigverify"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+// Check checks the files of the given repo for problems without changing
+// them. For git repos, this includes checking the integrity of all of the
+// repo's objects. All the problems that were found are returned together.
+func Check(ctx context.Context, repo types.Repo) error {
+	b, err := backendFor(repo)
+	if err != nil {
+		return err
+	}
+
+	reposLock, err := lock.Shared(ctx, lock.Repos)
+	if err != nil {
+		return err
+	}
+	defer reposLock.Release()
+
+	return b.Check(ctx, repo)
+}
+
+// Reindex writes the packages of the given repos to the DB again, using
+// the files that were already pulled rather than fetching them. Repos that
+// were never pulled are pulled instead. If repos is nil, the repos in the
+// LURE config are used. Disabled repos are skipped.
+func Reindex(ctx context.Context, repos []types.Repo) error {
+	log := loggerctx.From(ctx)
+	repos = pullableRepos(ctx, repos)
+
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs   []error
+		toPull []types.Repo
+	)
+	for _, repo := range repos {
+		err := reindex(ctx, repo)
+		if errors.Is(err, ErrNotPulled) {
+			toPull = append(toPull, repo)
+		} else if err != nil {
+			log.Error("Error re-indexing repository").Str("name", repo.Name).Err(err).Send()
+			errs = append(errs, &PullError{Repo: repo.Name, Err: err})
+		}
+	}
+
+	// Pull acquires this lock itself
+	reposLock.Release()
+
+	if len(toPull) > 0 {
+		errs = append(errs, Pull(ctx, toPull))
+	}
+
+	return errors.Join(errs...)
+}
+
+// reindex writes all of the packages of a single repo to the DB again
+func reindex(ctx context.Context, repo types.Repo) error {
+	b, err := backendFor(repo)
+	if err != nil {
+		return err
+	}
+
+	index, err := b.Reindex(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	loggerctx.From(ctx).Info("Re-indexing repository").Str("name", repo.Name).Send()
+
+	return withDBLock(ctx, func() error {
+		err := index(ctx)
+		if err != nil {
+			return err
+		}
+		return indexOverlays(ctx, repo)
+	})
+}
+
+// Reset discards any local changes to the files of the given repos, and
+// then pulls them, so that they're checked out at the latest commit of their
+// remote, and indexes all of their packages again. Git repos that can't be
+// opened are removed, so that they're cloned again, and the packages of HTTP
+// repos are fetched again when they're needed. Local repos are only indexed
+// again. If repos is nil, the repos in the LURE config are used. Disabled
+// repos are skipped.
+func Reset(ctx context.Context, repos []types.Repo) error {
+	log := loggerctx.From(ctx)
+	repos = pullableRepos(ctx, repos)
+
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		b, err := backendFor(repo)
+		if err != nil {
+			reposLock.Release()
+			return err
+		}
+
+		repoDir := b.Dir(ctx, repo)
+		switch b.(type) {
+		case gitBackend:
+			err = resetClone(repoDir)
+			if err != nil {
+				log.Warn("Unable to reset repository, cloning it again").Str("name", repo.Name).Err(err).Send()
+				err = os.RemoveAll(repoDir)
+			}
+		case httpBackend:
+			err = os.RemoveAll(repoDir)
+		}
+		if err != nil {
+			reposLock.Release()
+			return err
+		}
+
+		// Without a refresh time, Pull indexes all of the repo's packages
+		err = withDBLock(ctx, func() error {
+			err := db.DeletePkgs(ctx, db.InRepo(repo.Name))
+			if err != nil {
+				return err
+			}
+			return db.DeleteRefreshTime(ctx, repo.Name)
+		})
+		if err != nil {
+			reposLock.Release()
+			return err
+		}
+	}
+
+	// Pull acquires this lock itself
+	reposLock.Release()
+
+	return Pull(ctx, repos)
+}
+
+// resetClone discards any changes to the files in the clone of a git repo,
+// including files that were added, so that they match its current commit.
+// Pull checks out the latest commit afterwards.
+func resetClone(repoDir string) error {
+	r, err := git.PlainOpen(repoDir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	err = w.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset})
+	if err != nil {
+		return err
+	}
+
+	return w.Clean(&git.CleanOptions{Dir: true})
+}
+
+// CheckConfig checks the settings in the config for problems, such as
+// repos with invalid names or missing URLs, pins of repos that don't
+// exist, and invalid durations. All the problems that were found are
+// returned together.
+func CheckConfig(ctx context.Context, cfg *types.Config) error {
+	var errs []error
+
+	var names []string
+	for _, repo := range cfg.Repos {
+		if !ValidName(repo.Name) {
+			errs = append(errs, fmt.Errorf("repo %q: invalid name", repo.Name))
+		} else if slices.Contains(names, repo.Name) {
+			errs = append(errs, fmt.Errorf("repo %s: defined more than once", repo.Name))
+		}
+		names = append(names, repo.Name)
+
+		if repo.URL == "" && repo.Path == "" {
+			errs = append(errs, fmt.Errorf("repo %s: no url or path", repo.Name))
+		} else if b, err := backendFor(repo); err != nil {
+			errs = append(errs, fmt.Errorf("repo %s: %w", repo.Name, err))
+		} else if _, ok := b.(httpBackend); ok && len(repo.TrustedKeys) == 0 && !repo.AllowUnsigned {
+			errs = append(errs, fmt.Errorf("repo %s: %w", repo.Name, ErrNoTrustedKeys))
+		}
+
+		if len(repo.TrustedKeys) > 0 {
+			_, err := sigverify.LoadKeys(config.GetPaths(ctx).ConfigDir, repo.TrustedKeys)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("repo %s: trustedKeys: %w", repo.Name, err))
+			}
+		}
+	}
+
+	if _, err := parseRefreshPolicy(cfg.Refresh); err != nil {
+		errs = append(errs, fmt.Errorf("refresh: %w", err))
+	}
+
+	for pkg, repo := range cfg.Pin {
+		if !slices.Contains(names, repo) {
+			errs = append(errs, fmt.Errorf("pin %s: repo %s doesn't exist", pkg, repo))
+		}
+	}
+
+	for _, a := range cfg.Auth {
+		if a.Host == "" {
+			errs = append(errs, errors.New("auth: entry without a host"))
+		}
+	}
+
+	for _, m := range cfg.Mirrors {
+		if m.Prefix == "" || m.Replacement == "" {
+			errs = append(errs, fmt.Errorf("mirror %q: prefix and replacement are required", m.Prefix))
+		}
+	}
+
+	if cfg.Limits.ScriptTimeout != "" {
+		if _, err := time.ParseDuration(cfg.Limits.ScriptTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("limits: scriptTimeout: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
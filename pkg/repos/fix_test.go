@@ -0,0 +1,175 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/crypto/ssh"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/sigverify"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	repoDir := filepath.Join(tmp, "repo")
+	writeFile(t, filepath.Join(repoDir, "foo", "lure.sh"), "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\n")
+
+	config.Config(ctx).Repos = []types.Repo{{Name: "local", Path: repoDir}}
+
+	err := repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	times, err := db.GetRefreshTimes(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	refreshed := times["local"]
+
+	err = db.Rebuild(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	_, err = db.GetPkg(ctx, db.ByName("foo"))
+	if err == nil {
+		t.Fatalf("Expected the rebuilt database to be empty")
+	}
+
+	// The refresh time should survive the rebuild
+	times, err = db.GetRefreshTimes(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if kept := times["local"]; refreshed.IsZero() || !kept.Truncate(time.Second).Equal(refreshed.Truncate(time.Second)) {
+		t.Errorf("Expected refresh time %s, got %s", refreshed, kept)
+	}
+
+	err = repos.Reindex(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	_, err = db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("local")))
+	if err != nil {
+		t.Errorf("Expected foo to be indexed again, got %s", err)
+	}
+
+	err = db.Check(ctx)
+	if err != nil {
+		t.Errorf("Expected no problems with the database, got %s", err)
+	}
+}
+
+func TestCheckUnsigned(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	srcDir := filepath.Join(tmp, "src")
+	r, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	commitFile(t, r, "foo/lure.sh", "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\n")
+
+	repo := types.Repo{Name: "check", Type: "git", URL: srcDir}
+	config.Config(ctx).Repos = []types.Repo{repo}
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = repos.Check(ctx, repo)
+	if err != nil {
+		t.Fatalf("Expected no problems, got %s", err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// The HEAD that was pulled before the key was configured
+	// isn't signed, so the check has to fail now.
+	repo.TrustedKeys = []string{string(ssh.MarshalAuthorizedKey(signer.PublicKey()))}
+	err = repos.Check(ctx, repo)
+	if !errors.Is(err, sigverify.ErrUnsigned) {
+		t.Errorf("Expected unsigned HEAD to be rejected, got %v", err)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &types.Config{
+		Repos: []types.Repo{
+			{Name: "default", URL: "https://github.com/lure-sh/lure-repo.git"},
+			{Name: "default", URL: "https://github.com/lure-sh/lure-repo.git"},
+			{Name: "empty"},
+			{Name: "web", Type: "http", URL: "https://lure.example.com/web"},
+		},
+		Pin:    map[string]string{"foo": "missing"},
+		Limits: types.Limits{ScriptTimeout: "forever"},
+	}
+
+	err := repos.CheckConfig(ctx, cfg)
+	if err == nil {
+		t.Fatalf("Expected problems, got none")
+	}
+
+	for _, expected := range []string{
+		"repo default: defined more than once",
+		"repo empty: no url or path",
+		"repo web: " + repos.ErrNoTrustedKeys.Error(),
+		"pin foo: repo missing doesn't exist",
+		"limits: scriptTimeout",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in %q", expected, err)
+		}
+	}
+
+	err = repos.CheckConfig(ctx, &types.Config{Repos: cfg.Repos[:1]})
+	if err != nil {
+		t.Errorf("Expected no problems, got %s", err)
+	}
+}
@@ -0,0 +1,528 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"lure.sh/lure/internal/auth"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/sigverify"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+// gitBackend handles repos that are git repositories. They're cloned
+// into the repo directory, and pulled incrementally afterwards.
+type gitBackend struct{}
+
+func (gitBackend) Dir(ctx context.Context, repo types.Repo) string {
+	return filepath.Join(config.GetPaths(ctx).RepoDir, repo.Name)
+}
+
+func (gitBackend) ScriptPath(ctx context.Context, repo types.Repo, pkg db.Package) (string, error) {
+	return dirScriptPath(ctx, repo, pkg)
+}
+
+func (g gitBackend) Update(ctx context.Context, repo types.Repo, full bool) (indexFunc, error) {
+	log := loggerctx.From(ctx)
+	repoDir := g.Dir(ctx, repo)
+	progress := newPrefixWriter(os.Stderr, repo.Name)
+
+	gitDir := filepath.Join(repoDir, ".git")
+	// Only pull repos that contain valid git repos
+	if fi, err := os.Stat(gitDir); err == nil && fi.IsDir() {
+		r, err := git.PlainOpen(repoDir)
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := r.Worktree()
+		if err != nil {
+			return nil, err
+		}
+
+		old, err := r.Head()
+		if err != nil {
+			return nil, err
+		}
+
+		err = fetchRepo(ctx, r, progress)
+		if err != nil {
+			return nil, err
+		}
+
+		target, branch, err := resolveTarget(ctx, r, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		refChanged := readPinnedRef(gitDir) != pinnedRef(repo)
+
+		// If the new commit can't be verified, keep using the current one,
+		// but only if it's signed by a trusted key itself. Otherwise, the repo
+		// may have been compromised before the keys were configured. Either
+		// way, the error is returned so that the repo isn't considered refreshed.
+		var verifyErr error
+		err = verifyTarget(ctx, r, repo, old.Hash(), target)
+		if err != nil {
+			if verr := verifyTarget(ctx, r, repo, plumbing.ZeroHash, old.Hash()); verr != nil {
+				return nil, fmt.Errorf("signature verification failed: %w", verr)
+			}
+			verifyErr = fmt.Errorf("signature verification failed, keeping the current commit: %w", err)
+			target, refChanged = old.Hash(), false
+		}
+
+		upToDate := target == old.Hash() && !refChanged
+		if upToDate && verifyErr == nil {
+			log.Info("Repository up to date").Str("name", repo.Name).Send()
+		} else if !upToDate {
+			err = checkoutTarget(r, w, target, branch)
+			if err != nil {
+				return nil, err
+			}
+
+			err = writePinnedRef(gitDir, repo)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Make sure the DB is created even if the repo is up to date
+		if upToDate && !full {
+			return nil, verifyErr
+		}
+
+		new, err := r.Head()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(ctx context.Context) error {
+			// If the DB was not present at startup, that means it's
+			// empty. In this case, we need to update the DB fully
+			// rather than just incrementally. The same applies if
+			// the repo was switched to a different ref, since the
+			// old packages may have nothing in common with the new ones.
+			if full {
+				return processRepoFull(ctx, repo, repoDir)
+			} else if refChanged {
+				log.Info("Repository ref changed, re-indexing").Str("name", repo.Name).Str("ref", pinnedRef(repo)).Send()
+				err := db.DeletePkgs(ctx, db.InRepo(repo.Name))
+				if err != nil {
+					return err
+				}
+				return processRepoFull(ctx, repo, repoDir)
+			}
+			return processRepoChanges(ctx, repo, r, w, old, new)
+		}, verifyErr
+	}
+
+	err := os.RemoveAll(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(repoDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	authMethod, err := gitAuth(ctx, repo.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := git.PlainCloneContext(ctx, repoDir, false, &git.CloneOptions{
+		URL:        repo.URL,
+		Auth:       authMethod,
+		Progress:   progress,
+		NoCheckout: true,
+		Tags:       git.AllTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	target, branch, err := resolveTarget(ctx, r, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't leave an unverified clone around,
+	// since it would be used by the next pull.
+	err = verifyTarget(ctx, r, repo, plumbing.ZeroHash, target)
+	if err != nil {
+		os.RemoveAll(repoDir)
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	err = checkoutTarget(r, w, target, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writePinnedRef(gitDir, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		// A fresh clone may replace a repo whose packages are still in
+		// the DB, for example if its directory was removed manually.
+		err := db.DeletePkgs(ctx, db.InRepo(repo.Name))
+		if err != nil {
+			return err
+		}
+		return processRepoFull(ctx, repo, repoDir)
+	}, nil
+}
+
+// Reindex indexes all of the packages in the commit the repo is
+// currently checked out at, without fetching anything
+func (g gitBackend) Reindex(ctx context.Context, repo types.Repo) (indexFunc, error) {
+	repoDir := g.Dir(ctx, repo)
+	if fi, err := os.Stat(filepath.Join(repoDir, ".git")); err != nil || !fi.IsDir() {
+		return nil, ErrNotPulled
+	}
+
+	return func(ctx context.Context) error {
+		return reindexRepo(ctx, repo, repoDir)
+	}, nil
+}
+
+// Check makes sure all of the objects in the repo are intact, that the
+// commit it's checked out at is complete, and that its files weren't
+// changed locally.
+func (g gitBackend) Check(ctx context.Context, repo types.Repo) error {
+	repoDir := g.Dir(ctx, repo)
+	if fi, err := os.Stat(filepath.Join(repoDir, ".git")); err != nil || !fi.IsDir() {
+		return ErrNotPulled
+	}
+
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	objs, err := r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+
+	err = objs.ForEach(func(obj plumbing.EncodedObject) error {
+		rd, err := obj.Reader()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("object %s: %w", obj.Hash(), err))
+			return nil
+		}
+		defer rd.Close()
+
+		data, err := io.ReadAll(rd)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("object %s: %w", obj.Hash(), err))
+		} else if plumbing.ComputeHash(obj.Type(), data) != obj.Hash() {
+			errs = append(errs, fmt.Errorf("object %s: hash mismatch", obj.Hash()))
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("HEAD: %w", err))...)
+	}
+
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("commit %s: %w", head.Hash(), err))...)
+	}
+
+	files, err := commit.Files()
+	if err == nil {
+		err = files.ForEach(func(*object.File) error { return nil })
+	}
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("commit %s is incomplete: %w", head.Hash(), err))...)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		errs = append(errs, err)
+	} else if !status.IsClean() {
+		errs = append(errs, errors.New("repository has local changes"))
+	}
+
+	err = verifyTarget(ctx, r, repo, plumbing.ZeroHash, head.Hash())
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// pinnedRefFile is the name of the file inside a repo's git directory
+// that records which ref or commit the repo was last checked out at,
+// so that LURE can tell when the configured ref changes.
+const pinnedRefFile = "lure_pinned_ref"
+
+// pinnedRef returns the ref or commit the repo should be checked out at.
+// A commit takes precedence over a ref.
+func pinnedRef(repo types.Repo) string {
+	if repo.Commit != "" {
+		return repo.Commit
+	}
+	return repo.Ref
+}
+
+// readPinnedRef returns the ref the repo in gitDir was last checked out at
+func readPinnedRef(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, pinnedRefFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// writePinnedRef records the ref the repo in gitDir is checked out at
+func writePinnedRef(gitDir string, repo types.Repo) error {
+	return os.WriteFile(filepath.Join(gitDir, pinnedRefFile), []byte(pinnedRef(repo)+"\n"), 0o644)
+}
+
+// fetchRepo fetches all branches and tags from the repo's remote
+func fetchRepo(ctx context.Context, r *git.Repository, progress io.Writer) error {
+	remote, err := r.Remote("origin")
+	if err != nil {
+		return err
+	}
+
+	authMethod, err := gitAuth(ctx, remote.Config().URLs[0])
+	if err != nil {
+		return err
+	}
+
+	err = r.FetchContext(ctx, &git.FetchOptions{
+		Tags:     git.AllTags,
+		Progress: progress,
+		Auth:     authMethod,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
+
+// resolveTarget finds the commit that the repo should be checked out at.
+// If the target is a branch, its name is returned as well so that
+// it can be checked out as a local branch rather than a detached HEAD.
+func resolveTarget(ctx context.Context, r *git.Repository, repo types.Repo) (plumbing.Hash, string, error) {
+	if repo.Commit != "" {
+		h, err := r.ResolveRevision(plumbing.Revision(repo.Commit))
+		if err != nil {
+			return plumbing.ZeroHash, "", fmt.Errorf("commit %s: %w", repo.Commit, err)
+		}
+		return *h, "", nil
+	}
+
+	branch := strings.TrimPrefix(repo.Ref, "refs/heads/")
+	if repo.Ref == "" {
+		var err error
+		branch, err = defaultBranch(ctx, r)
+		if err != nil {
+			return plumbing.ZeroHash, "", err
+		}
+	}
+
+	ref, err := r.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err == nil {
+		return ref.Hash(), branch, nil
+	}
+
+	ref, err = r.Reference(plumbing.NewTagReferenceName(strings.TrimPrefix(repo.Ref, "refs/tags/")), true)
+	if err == nil {
+		// Annotated tags point to a tag object rather
+		// than a commit, so they have to be peeled.
+		tag, err := r.TagObject(ref.Hash())
+		if err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				return plumbing.ZeroHash, "", err
+			}
+			return commit.Hash, "", nil
+		}
+		return ref.Hash(), "", nil
+	}
+
+	h, err := r.ResolveRevision(plumbing.Revision(repo.Ref))
+	if err != nil {
+		return plumbing.ZeroHash, "", fmt.Errorf("ref %s: %w", repo.Ref, err)
+	}
+	return *h, "", nil
+}
+
+// defaultBranch returns the default branch of the repo's remote.
+func defaultBranch(ctx context.Context, r *git.Repository) (string, error) {
+	// If HEAD is on a branch, it's either the default branch that was
+	// checked out during the clone, or a branch the repo was pinned to
+	// before. Only the former is possible when it has no pinned ref.
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err == nil && head.Type() == plumbing.SymbolicReference {
+		cfg, err := r.Config()
+		if err == nil {
+			if _, ok := cfg.Branches[head.Target().Short()]; ok {
+				return head.Target().Short(), nil
+			}
+		}
+	}
+
+	remote, err := r.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+
+	authMethod, err := gitAuth(ctx, remote.Config().URLs[0])
+	if err != nil {
+		return "", err
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: authMethod})
+	if err != nil {
+		return "", err
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target().Short(), nil
+		}
+	}
+
+	return "", errors.New("unable to determine the default branch of the repository")
+}
+
+// gitAuth returns the credentials to use for the git repo at the given URL
+func gitAuth(ctx context.Context, repoURL string) (transport.AuthMethod, error) {
+	store, err := auth.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.GitAuth(repoURL)
+}
+
+// checkoutTarget checks out the given commit in the worktree, discarding
+// any local changes. If branch isn't empty, the commit is checked out as
+// that branch so that the repo isn't left with a detached HEAD.
+func checkoutTarget(r *git.Repository, w *git.Worktree, target plumbing.Hash, branch string) error {
+	if branch == "" {
+		return w.Checkout(&git.CheckoutOptions{Hash: target, Force: true})
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if _, err := r.Reference(branchRef, false); err != nil {
+		return w.Checkout(&git.CheckoutOptions{
+			Branch: branchRef,
+			Hash:   target,
+			Create: true,
+			Force:  true,
+		})
+	}
+
+	err := w.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true})
+	if err != nil {
+		return err
+	}
+
+	return w.Reset(&git.ResetOptions{Commit: target, Mode: git.HardReset})
+}
+
+// verifyTarget makes sure the target commit is signed by one of the repo's
+// trusted keys. If the repo is configured to verify all commits, every commit
+// that's reachable from target but not from old is verified as well.
+// If the repo has no trusted keys, verifyTarget does nothing.
+func verifyTarget(ctx context.Context, r *git.Repository, repo types.Repo, old, target plumbing.Hash) error {
+	if len(repo.TrustedKeys) == 0 {
+		return nil
+	}
+
+	kr, err := sigverify.LoadKeys(config.GetPaths(ctx).ConfigDir, repo.TrustedKeys)
+	if err != nil {
+		return err
+	}
+
+	commit, err := r.CommitObject(target)
+	if err != nil {
+		return err
+	}
+
+	// The target is always verified, even if it's the same as
+	// the old commit, in case the keys were only just configured.
+	err = verifyCommit(kr, commit)
+	if err != nil || !repo.VerifyAllCommits || old.IsZero() || old == target {
+		return err
+	}
+
+	// Collect all the commits that were already present, so that
+	// only the new ones are verified, even if the history contains
+	// merges of branches that started before the old commit.
+	seen := map[plumbing.Hash]bool{}
+	if oldCommit, err := r.CommitObject(old); err == nil {
+		err = object.NewCommitPreorderIter(oldCommit, nil, nil).ForEach(func(c *object.Commit) error {
+			seen[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return object.NewCommitPreorderIter(commit, seen, nil).ForEach(func(c *object.Commit) error {
+		return verifyCommit(kr, c)
+	})
+}
+
+func verifyCommit(kr *sigverify.KeyRing, c *object.Commit) error {
+	err := kr.VerifyCommit(c)
+	if err != nil {
+		return fmt.Errorf("commit %s: %w", c.Hash, err)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+)
+
+// ErrVersionNotFound is returned by FindVersion if the
+// requested version isn't in the history of the repo
+var ErrVersionNotFound = errors.New("version not found in repository history")
+
+// HistoryVersion is a version of a package found in the history of a git repo
+type HistoryVersion struct {
+	// Commit is the newest commit containing the version
+	Commit  string
+	Time    time.Time
+	Version string
+	Release int
+	Epoch   uint
+}
+
+// FullVersion returns the version of the package in the
+// same format the system package manager uses
+func (hv HistoryVersion) FullVersion() string {
+	return fullVersion(hv.Version, hv.Release, hv.Epoch)
+}
+
+// Versions returns the versions of the given package found in the history
+// of its repo, newest first. Only commits that change the package's build
+// script, or the files it used when it was last indexed, are checked.
+func Versions(ctx context.Context, pkg db.Package) ([]HistoryVersion, error) {
+	h, err := openHistory(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	defer h.close()
+
+	iter, err := h.r.Log(&git.LogOptions{
+		Order:      git.LogOrderCommitterTime,
+		PathFilter: h.touches,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var out []HistoryVersion
+	err = iter.ForEach(func(c *object.Commit) error {
+		hv, err := h.parse(ctx, c)
+		if err != nil {
+			// The package may not have existed yet, or its build script
+			// may have been broken, but that shouldn't stop the other
+			// versions from being found.
+			return nil
+		}
+
+		if !seen[hv.FullVersion()] {
+			seen[hv.FullVersion()] = true
+			out = append(out, hv)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// FindVersion returns the newest commit in the history of the package's
+// repo where it had the given version. The version may include the
+// release and epoch, such as 1:1.0.0-2, to pick a specific release.
+func FindVersion(ctx context.Context, pkg db.Package, version string) (HistoryVersion, error) {
+	versions, err := Versions(ctx, pkg)
+	if err != nil {
+		return HistoryVersion{}, err
+	}
+
+	for _, hv := range versions {
+		if hv.Version == version || hv.FullVersion() == version {
+			return hv, nil
+		}
+	}
+
+	return HistoryVersion{}, fmt.Errorf("%s/%s %s: %w", pkg.Repository, pkg.Name, version, ErrVersionNotFound)
+}
+
+// VersionAt returns the version the given package had at the given
+// commit of its repo. The commit may be any revision git understands,
+// such as an abbreviated hash or a tag.
+func VersionAt(ctx context.Context, pkg db.Package, rev string) (HistoryVersion, error) {
+	h, err := openHistory(ctx, pkg)
+	if err != nil {
+		return HistoryVersion{}, err
+	}
+	defer h.close()
+
+	hash, err := resolveCommit(h.r, rev)
+	if err != nil {
+		return HistoryVersion{}, fmt.Errorf("commit %s: %w", rev, err)
+	}
+
+	c, err := h.r.CommitObject(hash)
+	if err != nil {
+		return HistoryVersion{}, fmt.Errorf("commit %s: %w", rev, err)
+	}
+
+	hv, err := h.parse(ctx, c)
+	if err != nil {
+		return HistoryVersion{}, fmt.Errorf("%s/%s at commit %s: %w", pkg.Repository, pkg.Name, rev, err)
+	}
+	return hv, nil
+}
+
+// history reads the versions of a package from the commits of its repo
+type history struct {
+	r      *git.Repository
+	repo   types.Repo
+	pkg    db.Package
+	script string
+	tmpDir string
+}
+
+func openHistory(ctx context.Context, pkg db.Package) (*history, error) {
+	_, repo := findRepo(config.Config(ctx), pkg.Repository)
+	b, err := backendFor(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := b.(gitBackend); !ok {
+		return nil, fmt.Errorf("%s: version history is only available for git repositories", repo.Name)
+	}
+
+	r, err := git.PlainOpen(b.Dir(ctx, repo))
+	if err != nil {
+		return nil, err
+	}
+
+	script := pkg.Path
+	if script == "" {
+		script = path.Join(pkg.Name, "lure.sh")
+	}
+
+	if !filepath.IsLocal(script) {
+		return nil, fmt.Errorf("%s: invalid build script path: %s", repo.Name, script)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "lure-history-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &history{
+		r:      r,
+		repo:   repo,
+		pkg:    pkg,
+		script: script,
+		tmpDir: tmpDir,
+	}, nil
+}
+
+// touches reports whether a change to the file at the given
+// path within the repo may change the version of the package
+func (h *history) touches(file string) bool {
+	if file == h.script || file == "lure-repo.toml" {
+		return true
+	}
+
+	for _, used := range h.pkg.Files.Val {
+		if file == used || (strings.HasSuffix(used, "/") && strings.HasPrefix(file, used)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse parses the package's build script as it was in the given commit.
+// Only the files the script may use are written to a temporary directory,
+// rather than the whole repo, since this is done for many commits.
+func (h *history) parse(ctx context.Context, c *object.Commit) (HistoryVersion, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return HistoryVersion{}, err
+	}
+
+	if _, err := tree.File(h.script); err != nil {
+		return HistoryVersion{}, err
+	}
+
+	repoDir := filepath.Join(h.tmpDir, c.Hash.String())
+	defer os.RemoveAll(repoDir)
+
+	files := append([]string{h.script, "lure-repo.toml"}, h.pkg.Files.Val...)
+	for _, file := range files {
+		err = writeTreeFiles(tree, file, repoDir)
+		if err != nil {
+			return HistoryVersion{}, err
+		}
+	}
+
+	ix, err := newIndexer(ctx, h.repo, repoDir)
+	if err != nil {
+		return HistoryVersion{}, err
+	}
+
+	scriptPath := filepath.Join(repoDir, filepath.FromSlash(h.script))
+	fl, err := os.Open(scriptPath)
+	if err != nil {
+		return HistoryVersion{}, err
+	}
+
+	pkg, err := ix.parse(ctx, scriptPath, fl)
+	if err != nil && !errors.Is(err, ErrPolicyViolation) {
+		return HistoryVersion{}, err
+	}
+
+	if pkg.Name != h.pkg.Name {
+		return HistoryVersion{}, fmt.Errorf("%s: build script describes %s instead", h.script, pkg.Name)
+	}
+
+	return HistoryVersion{
+		Commit:  c.Hash.String(),
+		Time:    c.Committer.When,
+		Version: pkg.Version,
+		Release: pkg.Release,
+		Epoch:   pkg.Epoch,
+	}, nil
+}
+
+func (h *history) close() {
+	os.RemoveAll(h.tmpDir)
+}
+
+// writeTreeFiles writes the file at the given path in the tree to dir.
+// If the path ends in a slash, the files in that directory are written
+// instead. Paths that don't exist in the tree are ignored.
+func writeTreeFiles(tree *object.Tree, file, dir string) error {
+	if !filepath.IsLocal(file) {
+		return nil
+	}
+
+	if strings.HasSuffix(file, "/") {
+		sub, err := tree.Tree(strings.TrimSuffix(file, "/"))
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Join(dir, filepath.FromSlash(file)), 0o755)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range sub.Entries {
+			if entry.Mode.IsFile() {
+				err = writeTreeFiles(tree, file+entry.Name, dir)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	f, err := tree.File(file)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	fpath := filepath.Join(dir, filepath.FromSlash(file))
+	err = os.MkdirAll(filepath.Dir(fpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	return err
+}
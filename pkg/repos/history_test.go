@@ -0,0 +1,106 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	srcDir := filepath.Join(tmp, "src")
+	r, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// The version is sourced from another file, so changes
+	// to that file have to be found in the history too.
+	commitFile(t, r, "foo/lure.sh", "name=foo\nsource \"$scriptdir/version\"\nrelease=1\narchitectures=(all)\n")
+	first := commitFile(t, r, "foo/version", "version=1.0.0\n")
+	commitFile(t, r, "foo/version", "version=1.1.0\n")
+	commitFile(t, r, "foo/lure.sh", "name=foo\nsource \"$scriptdir/version\"\nrelease=2\narchitectures=(all)\n")
+
+	config.Config(ctx).Repos = []types.Repo{{Name: "history", Type: "git", URL: srcDir}}
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	pkg, err := db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("history")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	versions, err := repos.Versions(ctx, *pkg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	var got []string
+	for _, hv := range versions {
+		got = append(got, hv.FullVersion())
+	}
+
+	expected := []string{"1.1.0-2", "1.1.0-1", "1.0.0-1"}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected versions %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected versions %v, got %v", expected, got)
+		}
+	}
+
+	hv, err := repos.FindVersion(ctx, *pkg, "1.0.0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if hv.Commit != first {
+		t.Errorf("Expected commit %s, got %s", first, hv.Commit)
+	}
+
+	_, err = repos.FindVersion(ctx, *pkg, "2.0.0")
+	if !errors.Is(err, repos.ErrVersionNotFound) {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+	}
+
+	hv, err = repos.VersionAt(ctx, *pkg, first[:8])
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if hv.FullVersion() != "1.0.0-1" {
+		t.Errorf("Expected version 1.0.0-1, got %s", hv.FullVersion())
+	}
+}
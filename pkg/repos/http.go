@@ -0,0 +1,446 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"lure.sh/lure/internal/auth"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/sigverify"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+const (
+	// IndexFile is the name of an HTTP repo's index
+	IndexFile = "index.json"
+	// IndexSigFile is the name of the signature of an HTTP repo's index
+	IndexSigFile = "index.json.sig"
+	// IndexNamespace is the namespace of SSH signatures of HTTP repo indices
+	IndexNamespace = "lure-index"
+	// IndexVersion is the current version of the HTTP repo index format
+	IndexVersion = 1
+)
+
+// Index is the index of an HTTP repo. It contains the metadata of every
+// package in the repo, and the location of an archive for each package
+// containing its build script and any other files in its directory.
+type Index struct {
+	Version    int            `json:"version"`
+	RepoConfig string         `json:"repoConfig,omitempty"`
+	Packages   []IndexPackage `json:"packages"`
+}
+
+// IndexPackage is a package in an HTTP repo index
+type IndexPackage struct {
+	db.Package
+
+	// Archive is the location of the package's archive,
+	// relative to the URL of the repo.
+	Archive string `json:"archive"`
+	// SHA256 is the checksum of the package's archive
+	SHA256 string `json:"sha256"`
+}
+
+// ErrNoTrustedKeys is returned when an HTTP repo has no trusted keys
+// to verify its index with and doesn't explicitly allow unsigned indexes.
+var ErrNoTrustedKeys = errors.New("http repos require trustedKeys, unless allowUnsigned is set")
+
+// httpBackend handles repos that are served as static files over HTTP.
+// Only the repo's index is downloaded when it's pulled. The files of each
+// package are downloaded when the package is first needed.
+type httpBackend struct{}
+
+func (httpBackend) Dir(ctx context.Context, repo types.Repo) string {
+	return filepath.Join(config.GetPaths(ctx).RepoDir, repo.Name)
+}
+
+func (h httpBackend) Update(ctx context.Context, repo types.Repo, full bool) (indexFunc, error) {
+	log := loggerctx.From(ctx)
+	repoDir := h.Dir(ctx, repo)
+
+	data, err := httpGet(ctx, repo.URL, IndexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(repo.TrustedKeys) == 0 && !repo.AllowUnsigned {
+		return nil, ErrNoTrustedKeys
+	}
+
+	if len(repo.TrustedKeys) > 0 {
+		sig, err := httpGet(ctx, repo.URL, IndexSigFile)
+		if err != nil {
+			return nil, err
+		}
+
+		kr, err := sigverify.LoadKeys(config.GetPaths(ctx).ConfigDir, repo.TrustedKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		err = kr.Verify(bytes.NewReader(data), sig, IndexNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("index signature verification failed: %w", err)
+		}
+	} else {
+		log.Warn("Repository allows unsigned indexes, so its index can't be verified").Str("name", repo.Name).Send()
+	}
+
+	idx, err := parseIndex(data)
+	if err != nil {
+		return nil, err
+	}
+
+	oldData, err := os.ReadFile(filepath.Join(repoDir, IndexFile))
+	if err == nil && bytes.Equal(oldData, data) && !full {
+		log.Info("Repository up to date").Str("name", repo.Name).Send()
+		return nil, nil
+	}
+
+	err = os.MkdirAll(repoDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the files of any packages that were fetched before
+	// but changed since then, so that they get fetched again.
+	var oldIdx Index
+	if json.Unmarshal(oldData, &oldIdx) == nil {
+		sums := map[string]string{}
+		for _, pkg := range idx.Packages {
+			sums[pkg.Name] = pkg.SHA256
+		}
+
+		for _, pkg := range oldIdx.Packages {
+			if validPkgName(pkg.Name) && sums[pkg.Name] != pkg.SHA256 {
+				err = os.RemoveAll(filepath.Join(repoDir, pkg.Name))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	repoCfgPath := filepath.Join(repoDir, "lure-repo.toml")
+	if idx.RepoConfig != "" {
+		err = os.WriteFile(repoCfgPath, []byte(idx.RepoConfig), 0o644)
+	} else {
+		err = os.Remove(repoCfgPath)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		err := indexPackages(ctx, repo, idx)
+		if err != nil {
+			return err
+		}
+
+		// The index is written last, so that if anything above
+		// fails, the repo is updated again during the next pull.
+		return os.WriteFile(filepath.Join(repoDir, IndexFile), data, 0o644)
+	}, nil
+}
+
+// Reindex writes the packages in the stored index to the DB. The stored
+// index was verified when the repo was pulled, so it can be trusted.
+func (h httpBackend) Reindex(ctx context.Context, repo types.Repo) (indexFunc, error) {
+	idx, err := h.storedIndex(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		return indexPackages(ctx, repo, idx)
+	}, nil
+}
+
+// Check makes sure the stored index can be read
+func (h httpBackend) Check(ctx context.Context, repo types.Repo) error {
+	_, err := h.storedIndex(ctx, repo)
+	return err
+}
+
+// storedIndex reads the index that was stored when the repo was last
+// pulled. If the repo was never pulled, ErrNotPulled is returned.
+func (h httpBackend) storedIndex(ctx context.Context, repo types.Repo) (Index, error) {
+	data, err := os.ReadFile(filepath.Join(h.Dir(ctx, repo), IndexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Index{}, ErrNotPulled
+	} else if err != nil {
+		return Index{}, err
+	}
+	return parseIndex(data)
+}
+
+// parseIndex decodes an HTTP repo index and validates its contents
+func parseIndex(data []byte) (Index, error) {
+	var idx Index
+	err := json.Unmarshal(data, &idx)
+	if err != nil {
+		return Index{}, fmt.Errorf("invalid index: %w", err)
+	}
+
+	if idx.Version != IndexVersion {
+		return Index{}, fmt.Errorf("unsupported index version: %d", idx.Version)
+	}
+
+	for _, pkg := range idx.Packages {
+		if !validPkgName(pkg.Name) {
+			return Index{}, fmt.Errorf("invalid package name in index: %q", pkg.Name)
+		}
+	}
+
+	return idx, nil
+}
+
+// indexPackages replaces the packages of the repo in the DB
+// with the packages in the given index
+func indexPackages(ctx context.Context, repo types.Repo, idx Index) error {
+	err := db.DeletePkgs(ctx, db.InRepo(repo.Name))
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range idx.Packages {
+		// Each package is extracted into its own directory named
+		// after it, regardless of where it is in the source repo.
+		pkg.Repository = repo.Name
+		pkg.Path = path.Join(pkg.Name, "lure.sh")
+		err = db.InsertPackage(ctx, pkg.Package)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ScriptPath downloads and extracts the archive of the given
+// package if that hasn't been done already.
+func (h httpBackend) ScriptPath(ctx context.Context, repo types.Repo, dbPkg db.Package) (string, error) {
+	pkgName := dbPkg.Name
+	if !validPkgName(pkgName) {
+		return "", fmt.Errorf("invalid package name: %q", pkgName)
+	}
+
+	repoDir := h.Dir(ctx, repo)
+	pkgDir := filepath.Join(repoDir, pkgName)
+	scriptPath := filepath.Join(pkgDir, "lure.sh")
+
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		return "", err
+	}
+	defer reposLock.Release()
+
+	if _, err := os.Stat(scriptPath); err == nil {
+		return scriptPath, nil
+	}
+
+	// The stored index was verified when the repo was pulled,
+	// so it can be trusted, and so can the checksums inside it.
+	data, err := os.ReadFile(filepath.Join(repoDir, IndexFile))
+	if err != nil {
+		return "", err
+	}
+
+	var idx Index
+	err = json.Unmarshal(data, &idx)
+	if err != nil {
+		return "", err
+	}
+
+	var pkg *IndexPackage
+	for i := range idx.Packages {
+		if idx.Packages[i].Name == pkgName {
+			pkg = &idx.Packages[i]
+			break
+		}
+	}
+
+	if pkg == nil {
+		return "", fmt.Errorf("%s: package not found in index: %s", repo.Name, pkgName)
+	}
+
+	loggerctx.From(ctx).Info("Fetching package").Str("repo", repo.Name).Str("name", pkgName).Send()
+
+	archive, err := httpGet(ctx, repo.URL, pkg.Archive)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(archive)
+	if hex.EncodeToString(sum[:]) != pkg.SHA256 {
+		return "", fmt.Errorf("%s: checksum mismatch for package archive: %s", repo.Name, pkg.Archive)
+	}
+
+	// Extract into a temporary directory first, so that a failed
+	// extraction doesn't leave an incomplete package behind.
+	tmpDir, err := os.MkdirTemp(repoDir, "."+pkgName+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = extractTarGz(bytes.NewReader(archive), tmpDir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", pkg.Archive, err)
+	}
+
+	err = os.RemoveAll(pkgDir)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmpDir, pkgDir)
+	if err != nil {
+		return "", err
+	}
+
+	return scriptPath, nil
+}
+
+// httpGet downloads the file at the given path relative to baseURL
+func httpGet(ctx context.Context, baseURL, path string) ([]byte, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileURL := u.ResolveReference(ref).String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := auth.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.SetRequestAuth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", fileURL, res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+// extractTarGz extracts a gzip-compressed tar archive into dir.
+// Only regular files and directories are allowed, and
+// they have to be located within dir.
+func extractTarGz(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0o755)
+			if err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(path), 0o755)
+			if err != nil {
+				return err
+			}
+
+			fl, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(fl, tr)
+			fl.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported file type in archive: %s", hdr.Name)
+		}
+	}
+}
+
+// validPkgName makes sure a package name from an index
+// can safely be used as the name of a directory
+func validPkgName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
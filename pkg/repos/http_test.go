@@ -0,0 +1,171 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/sigverify"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+}
+
+func TestHTTPRepo(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	srcDir := filepath.Join(tmp, "src")
+	writeFile(t, filepath.Join(srcDir, "lure-repo.toml"), "[repo]\nminVersion = \"v0.0.0\"\n")
+	writeFile(t, filepath.Join(srcDir, "foo", "lure.sh"), "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\ndeps=(bar)\n")
+	writeFile(t, filepath.Join(srcDir, "foo", "foo.patch"), "patch")
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	outDir := filepath.Join(tmp, "out")
+	err = repos.Publish(ctx, srcDir, outDir, signer)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(outDir)))
+	defer srv.Close()
+
+	config.Config(ctx).Repos = []types.Repo{{
+		Name:        "web",
+		Type:        "http",
+		URL:         srv.URL,
+		TrustedKeys: []string{string(ssh.MarshalAuthorizedKey(signer.PublicKey()))},
+	}}
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	pkg, err := db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("web")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if pkg.Version != "1.0.0" {
+		t.Errorf("Expected version 1.0.0, got %s", pkg.Version)
+	}
+
+	if deps := pkg.Depends.Val[""]; len(deps) != 1 || deps[0] != "bar" {
+		t.Errorf("Expected dependency on bar, got %v", deps)
+	}
+
+	script, err := repos.ScriptPath(ctx, "web", "foo")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if !bytes.Contains(data, []byte("version=1.0.0")) {
+		t.Errorf("Expected fetched script to match the published one, got %q", data)
+	}
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(script), "foo.patch"))
+	if err != nil {
+		t.Errorf("Expected other package files to be fetched, got %s", err)
+	}
+
+	// Tamper with the index, which should be
+	// rejected since the signature won't match.
+	indexPath := filepath.Join(outDir, repos.IndexFile)
+	index, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	writeFile(t, indexPath, string(bytes.ReplaceAll(index, []byte("1.0.0"), []byte("6.6.6"))))
+
+	err = repos.Pull(ctx, nil)
+	if !errors.Is(err, sigverify.ErrUntrusted) {
+		t.Fatalf("Expected untrusted signature error, got %v", err)
+	}
+
+	pkg, err = db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("web")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if pkg.Version != "1.0.0" {
+		t.Errorf("Expected tampered index to be ignored, got version %s", pkg.Version)
+	}
+
+	// Without trusted keys, the repo must explicitly allow unsigned indexes
+	repo := types.Repo{Name: "web", Type: "http", URL: srv.URL}
+	err = repos.Pull(ctx, []types.Repo{repo})
+	if !errors.Is(err, repos.ErrNoTrustedKeys) {
+		t.Fatalf("Expected missing trusted keys error, got %v", err)
+	}
+
+	repo.AllowUnsigned = true
+	err = repos.Pull(ctx, []types.Repo{repo})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	pkg, err = db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("web")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if pkg.Version != "6.6.6" {
+		t.Errorf("Expected unsigned index to be used, got version %s", pkg.Version)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestIndexErrors(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	repoDir := filepath.Join(tmp, "repo")
+	writeFile(t, filepath.Join(repoDir, "foo", "lure.sh"), "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\n")
+	brokenPath := filepath.Join(repoDir, "bar", "lure.sh")
+	writeFile(t, brokenPath, "name=bar\nversion=1.0.0\nrelease=1\nif then fi\n")
+
+	config.Config(ctx).Repos = []types.Repo{{Name: "broken", Path: repoDir}}
+
+	// A broken build script shouldn't stop the rest of the repo from being indexed
+	err := repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	_, err = db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("broken")))
+	if err != nil {
+		t.Fatalf("Expected foo to be indexed, got %s", err)
+	}
+
+	indexErrs, err := db.GetIndexErrors(ctx, "broken")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(indexErrs) != 1 || indexErrs[0].Path != "bar/lure.sh" || indexErrs[0].Error == "" {
+		t.Fatalf("Expected an error for bar/lure.sh, got %+v", indexErrs)
+	}
+
+	// Once the script is fixed, the error should be cleared
+	writeFile(t, brokenPath, "name=bar\nversion=1.0.0\nrelease=1\narchitectures=(all)\n")
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(brokenPath, future, future)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	indexErrs, err = db.GetIndexErrors(ctx, "broken")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(indexErrs) != 0 {
+		t.Errorf("Expected no index errors, got %+v", indexErrs)
+	}
+
+	_, err = db.GetPkg(ctx, db.And(db.ByName("bar"), db.InRepo("broken")))
+	if err != nil {
+		t.Errorf("Expected bar to be indexed, got %s", err)
+	}
+}
+
+func TestIndexLimits(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	repoDir := filepath.Join(tmp, "repo")
+	writeFile(t, filepath.Join(repoDir, "foo", "lure.sh"), "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\n")
+	writeFile(t, filepath.Join(repoDir, "loop", "lure.sh"), "name=loop\nfor ((;;)); do x=1; done\n")
+
+	cfg := config.Config(ctx)
+	cfg.Repos = []types.Repo{{Name: "limited", Path: repoDir}}
+	cfg.Limits = types.Limits{ScriptTimeout: "500ms"}
+	defer func() { cfg.Limits = types.Limits{} }()
+
+	// A script that never finishes shouldn't stop the repo from being indexed
+	err := repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	_, err = db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("limited")))
+	if err != nil {
+		t.Fatalf("Expected foo to be indexed, got %s", err)
+	}
+
+	indexErrs, err := db.GetIndexErrors(ctx, "limited")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(indexErrs) != 1 || indexErrs[0].Path != "loop/lure.sh" {
+		t.Fatalf("Expected an error for loop/lure.sh, got %+v", indexErrs)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"lure.sh/lure/internal/db"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// fileRecorder records the files in a repo that a build
+// script accesses while it's being indexed
+type fileRecorder struct {
+	repoDir string
+	files   map[string]struct{}
+}
+
+func newFileRecorder(repoDir string) *fileRecorder {
+	return &fileRecorder{repoDir: repoDir, files: map[string]struct{}{}}
+}
+
+// record adds the file at the given path to the recorded files if it's
+// inside the repo. Files are recorded even if they don't exist, since
+// creating them may change the result of the script.
+func (fr *fileRecorder) record(ctx context.Context, name string, dir bool) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(interp.HandlerCtx(ctx).Dir, name)
+	}
+
+	rel, err := filepath.Rel(fr.repoDir, name)
+	if err != nil || !filepath.IsLocal(rel) {
+		return
+	}
+
+	rel = filepath.ToSlash(rel)
+	if rel == "." && dir {
+		rel = db.RootDir
+	} else if dir {
+		rel += "/"
+	}
+	fr.files[rel] = struct{}{}
+}
+
+// list returns the sorted paths of the recorded files,
+// leaving out the build script at scriptPath
+func (fr *fileRecorder) list(scriptPath string) []string {
+	script, _ := filepath.Rel(fr.repoDir, scriptPath)
+	script = filepath.ToSlash(script)
+
+	out := make([]string, 0, len(fr.files))
+	for file := range fr.files {
+		if file != script {
+			out = append(out, file)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (fr *fileRecorder) open(next interp.OpenHandlerFunc) interp.OpenHandlerFunc {
+	return func(ctx context.Context, name string, flag int, perm fs.FileMode) (io.ReadWriteCloser, error) {
+		fr.record(ctx, name, false)
+		return next(ctx, name, flag, perm)
+	}
+}
+
+func (fr *fileRecorder) stat(next interp.StatHandlerFunc) interp.StatHandlerFunc {
+	return func(ctx context.Context, name string, followSymlinks bool) (fs.FileInfo, error) {
+		fr.record(ctx, name, false)
+		return next(ctx, name, followSymlinks)
+	}
+}
+
+func (fr *fileRecorder) readDir(next interp.ReadDirHandlerFunc) interp.ReadDirHandlerFunc {
+	return func(ctx context.Context, name string) ([]fs.FileInfo, error) {
+		fr.record(ctx, name, true)
+		return next(ctx, name)
+	}
+}
+
+// packageInputs returns the paths of all the files in a local repo that can
+// affect the package built by the script at scriptPath. These are the files in
+// the script's directory, the files the script accessed the last time it was
+// indexed, and the repo's lure-repo.toml file. The parent directories of
+// the files are returned separately. Their modification times change when
+// files are added or removed, but their contents don't affect the package.
+func packageInputs(repoDir, scriptPath string, files []string) (paths, parents []string, err error) {
+	pkgDir := filepath.Dir(scriptPath)
+
+	err = filepath.WalkDir(pkgDir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && fpath != pkgDir {
+			// Skip hidden directories, and directories that
+			// contain the build scripts of other packages
+			if strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(fpath, "lure.sh")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+
+		paths = append(paths, fpath)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, file := range append(files, "lure-repo.toml") {
+		fpath := filepath.Join(repoDir, filepath.FromSlash(file))
+		paths = append(paths, fpath)
+		parents = append(parents, filepath.Dir(fpath))
+	}
+
+	return paths, parents, nil
+}
+
+// inputsModTime returns the latest modification time of the given files.
+// Files that don't exist are ignored.
+func inputsModTime(paths []string) (int64, error) {
+	var out int64
+	for _, fpath := range paths {
+		fi, err := os.Stat(fpath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		if mtime := fi.ModTime().UnixNano(); mtime > out {
+			out = mtime
+		}
+	}
+	return out, nil
+}
+
+// inputsHash returns a hash of the names and contents of the given files,
+// and the entries of any directories among them, so that it changes
+// whenever any of them is added, removed, or modified.
+func inputsHash(repoDir string, paths []string) (string, error) {
+	paths = append([]string(nil), paths...)
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for i, fpath := range paths {
+		if i > 0 && fpath == paths[i-1] {
+			continue
+		}
+
+		fi, err := os.Stat(fpath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		rel, _ := filepath.Rel(repoDir, fpath)
+		io.WriteString(h, filepath.ToSlash(rel))
+		h.Write([]byte{0})
+
+		if fi.IsDir() {
+			entries, err := os.ReadDir(fpath)
+			if err != nil {
+				return "", err
+			}
+
+			for _, entry := range entries {
+				io.WriteString(h, entry.Name())
+				h.Write([]byte{0})
+			}
+			continue
+		}
+
+		fl, err := os.Open(fpath)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = io.Copy(h, fl)
+		fl.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
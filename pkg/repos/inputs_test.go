@@ -0,0 +1,79 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestPackageInputs(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	repoDir := filepath.Join(tmp, "repo")
+	writeFile(t, filepath.Join(repoDir, "common", "vars.sh"), "desc='Version 1'\n")
+	writeFile(t, filepath.Join(repoDir, "foo", "lure.sh"), "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\nsource \"$scriptdir/../common/vars.sh\"\n")
+	writeFile(t, filepath.Join(repoDir, "bar", "lure.sh"), "name=bar\nversion=1.0.0\nrelease=1\narchitectures=(all)\nif [ -f \"$scriptdir/extra\" ]; then desc=extra; fi\n")
+
+	config.Config(ctx).Repos = []types.Repo{{Name: "local", Path: repoDir}}
+
+	err := repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	foo, err := db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("local")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(foo.Files.Val) != 1 || foo.Files.Val[0] != "common/vars.sh" {
+		t.Errorf("Expected foo to use common/vars.sh, got %v", foo.Files.Val)
+	}
+
+	// Changing a file that a script sourced, or adding a file to a
+	// package's directory, should cause the package to be indexed again.
+	writeFile(t, filepath.Join(repoDir, "common", "vars.sh"), "desc='Version 2'\n")
+	writeFile(t, filepath.Join(repoDir, "bar", "extra"), "")
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	expected := map[string]string{"foo": "Version 2", "bar": "extra"}
+	for name, desc := range expected {
+		pkg, err := db.GetPkg(ctx, db.And(db.ByName(name), db.InRepo("local")))
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if pkg.Description.Val[""] != desc {
+			t.Errorf("Expected description of %s to be %q, got %q", name, desc, pkg.Description.Val[""])
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// layout describes where the build scripts of a repo are located
+type layout struct {
+	// recursive means that build scripts may be
+	// located in directories at any depth
+	recursive bool
+	// depth is the number of directories between
+	// the root of the repo and each build script
+	depth int
+}
+
+// defaultLayout is the layout of repos that don't specify one,
+// where each package has its own directory at the root of the repo.
+var defaultLayout = layout{depth: 1}
+
+// parseLayout parses the layout setting of a lure-repo.toml file
+func parseLayout(s string) (layout, error) {
+	switch s {
+	case "":
+		return defaultLayout, nil
+	case "recursive":
+		return layout{recursive: true}, nil
+	}
+
+	dirs := strings.Split(strings.Trim(s, "/"), "/")
+	if dirs[len(dirs)-1] != "lure.sh" || len(dirs) < 2 {
+		return layout{}, fmt.Errorf("invalid repository layout: %q", s)
+	}
+
+	return layout{depth: len(dirs) - 1}, nil
+}
+
+// findScripts returns the paths of all the build scripts in repoDir
+func (l layout) findScripts(repoDir string) ([]string, error) {
+	if !l.recursive {
+		return filepath.Glob(filepath.Join(repoDir, strings.Repeat("*/", l.depth)+"lure.sh"))
+	}
+
+	var out []string
+	err := filepath.WalkDir(repoDir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip hidden directories such as .git
+		if d.IsDir() && fpath != repoDir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if !d.IsDir() && d.Name() == "lure.sh" && filepath.Dir(fpath) != repoDir {
+			out = append(out, fpath)
+		}
+
+		return nil
+	})
+	return out, err
+}
+
+// scriptsFor returns the paths of the build scripts whose packages may
+// contain the file at the given slash-separated path relative to the repo.
+// Any file in a package's directory belongs to that package. In recursive
+// repos, the build script of every parent directory is returned, since
+// any of them may be the one the file belongs to.
+func (l layout) scriptsFor(file string) []string {
+	dirs := strings.Split(path.Dir(file), "/")
+	if dirs[0] == "." {
+		return nil
+	}
+
+	if !l.recursive {
+		if len(dirs) < l.depth {
+			return nil
+		}
+		return []string{path.Join(append(dirs[:l.depth], "lure.sh")...)}
+	}
+
+	var out []string
+	for i := range dirs {
+		// Hidden directories can't contain packages
+		if strings.HasPrefix(dirs[i], ".") {
+			break
+		}
+		out = append(out, path.Join(path.Join(dirs[:i+1]...), "lure.sh"))
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestNestedLayout(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	repoDir := filepath.Join(tmp, "repo")
+	writeFile(t, filepath.Join(repoDir, "lure-repo.toml"), "[repo]\nlayout = \"category/pkg/lure.sh\"\n")
+	writeFile(t, filepath.Join(repoDir, "devel", "foo", "lure.sh"), "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\n")
+	writeFile(t, filepath.Join(repoDir, "misc", "bar", "lure.sh"), "name=bar\nversion=2.0.0\nrelease=1\narchitectures=(all)\n")
+	// Scripts at a different depth than the layout aren't build scripts
+	writeFile(t, filepath.Join(repoDir, "baz", "lure.sh"), "name=baz\nversion=1.0.0\nrelease=1\narchitectures=(all)\n")
+
+	config.Config(ctx).Repos = []types.Repo{{Name: "local", Path: repoDir}}
+
+	err := repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	pkg, err := db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("local")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if pkg.Path != "devel/foo/lure.sh" {
+		t.Errorf("Expected path devel/foo/lure.sh, got %s", pkg.Path)
+	}
+
+	_, err = db.GetPkg(ctx, db.And(db.ByName("baz"), db.InRepo("local")))
+	if err == nil {
+		t.Errorf("Expected script outside of the layout to be ignored")
+	}
+
+	for _, name := range []string{"bar", "misc/bar"} {
+		script, err := repos.ScriptPath(ctx, "local", name)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		expected := filepath.Join(repoDir, "misc", "bar", "lure.sh")
+		if script != expected {
+			t.Errorf("Expected script path %s for %s, got %s", expected, name, script)
+		}
+	}
+
+	// Moving a package to a different category should
+	// update its path without leaving a stale package behind.
+	err = os.Rename(filepath.Join(repoDir, "misc"), filepath.Join(repoDir, "utils"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	pkgs, err := db.GetPkgs(ctx, db.Query{Where: db.And(db.ByName("bar"), db.InRepo("local"))})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(pkgs) != 1 || pkgs[0].Path != "utils/bar/lure.sh" {
+		t.Errorf("Expected bar to be moved to utils/bar/lure.sh, got %v", pkgs)
+	}
+}
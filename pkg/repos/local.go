@@ -0,0 +1,274 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+// localBackend handles repos that are directories on the local
+// filesystem. They're indexed in place, so changes to their build
+// scripts are picked up without having to commit or push them.
+type localBackend struct{}
+
+func (localBackend) Dir(ctx context.Context, repo types.Repo) string {
+	path := repo.Path
+	if path == "" {
+		repoURL, err := url.Parse(repo.URL)
+		if err == nil {
+			path = repoURL.Path
+		}
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+
+	return filepath.Clean(path)
+}
+
+func (localBackend) ScriptPath(ctx context.Context, repo types.Repo, pkg db.Package) (string, error) {
+	return dirScriptPath(ctx, repo, pkg)
+}
+
+// Update makes sure the repo's directory exists. Local repos are indexed
+// in place, so there's nothing to fetch, and the returned function does
+// all the work of finding and indexing changed build scripts.
+func (l localBackend) Update(ctx context.Context, repo types.Repo, full bool) (indexFunc, error) {
+	repoDir := l.Dir(ctx, repo)
+
+	fi, err := os.Stat(repoDir)
+	if err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s: local repository path is not a directory", repoDir)
+	}
+
+	return func(ctx context.Context) error {
+		// If the DB is empty, the recorded file states are
+		// meaningless, so every script has to be indexed again.
+		if full {
+			err := db.DeleteRepoFiles(ctx, repo.Name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return l.index(ctx, repo, repoDir)
+	}, nil
+}
+
+// Reindex indexes all of the repo's packages in place. Local repos are
+// never fetched, so this is the same as updating the repo fully.
+func (l localBackend) Reindex(ctx context.Context, repo types.Repo) (indexFunc, error) {
+	return l.Update(ctx, repo, true)
+}
+
+// Check makes sure the repo's directory exists
+func (l localBackend) Check(ctx context.Context, repo types.Repo) error {
+	repoDir := l.Dir(ctx, repo)
+
+	fi, err := os.Stat(repoDir)
+	if err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s: local repository path is not a directory", repoDir)
+	}
+
+	return nil
+}
+
+// index indexes any packages that were added or changed since the last
+// time the repo was indexed, and removes packages whose build scripts no
+// longer exist. A package has changed if any of its inputs, such as the
+// files in its directory or the files its script accessed, changed. The
+// inputs are only read if their latest modification time changed, and
+// the package is only re-indexed if their contents changed as well.
+func (localBackend) index(ctx context.Context, repo types.Repo, repoDir string) error {
+	log := loggerctx.From(ctx)
+
+	ix, err := newIndexer(ctx, repo, repoDir)
+	if err != nil {
+		return err
+	}
+
+	l, err := ix.layout()
+	if err != nil {
+		return err
+	}
+
+	matches, err := l.findScripts(repoDir)
+	if err != nil {
+		return err
+	}
+
+	recorded, err := db.GetRepoFiles(ctx, repo.Name)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]db.RepoFile{}
+	for _, f := range recorded {
+		known[f.Path] = f
+	}
+
+	pkgs, err := db.GetPkgs(ctx, db.Query{Where: db.InRepo(repo.Name)})
+	if err != nil {
+		return err
+	}
+
+	used := map[string][]string{}
+	for _, pkg := range pkgs {
+		used[pkg.Path] = pkg.Files.Val
+	}
+
+	changed := 0
+
+	for _, match := range matches {
+		relPath, err := filepath.Rel(repoDir, match)
+		if err != nil {
+			return err
+		}
+
+		prev, ok := known[relPath]
+		delete(known, relPath)
+
+		paths, parents, err := packageInputs(repoDir, match, used[filepath.ToSlash(relPath)])
+		if err != nil {
+			return err
+		}
+
+		mtime, err := inputsModTime(append(paths, parents...))
+		if err != nil {
+			return err
+		}
+
+		if ok && prev.ModTime == mtime {
+			continue
+		}
+
+		hash, err := inputsHash(repoDir, paths)
+		if err != nil {
+			return err
+		}
+
+		if ok && prev.Hash == hash {
+			prev.ModTime = mtime
+			err = db.SetRepoFile(ctx, prev)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return err
+		}
+
+		pkg, err := ix.index(ctx, match, io.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", match, err)
+		}
+
+		// The script may access different files now, so its inputs
+		// have to be checked again. If the script couldn't be indexed,
+		// its fingerprint is still recorded, so that it's only indexed
+		// again once it changes.
+		mtime, hash, err = fingerprint(repoDir, match, pkg.Files.Val)
+		if err != nil {
+			return err
+		}
+
+		err = db.SetRepoFile(ctx, db.RepoFile{
+			Repository: repo.Name,
+			Path:       relPath,
+			ModTime:    mtime,
+			Hash:       hash,
+			Package:    pkg.Name,
+		})
+		if err != nil {
+			return err
+		}
+
+		changed++
+	}
+
+	// Any scripts that are left were deleted. Their packages are
+	// found by path, since a package with the same name may have
+	// been moved to a different directory.
+	for _, f := range known {
+		err = db.DeletePkgs(ctx, db.And(db.ByPath(filepath.ToSlash(f.Path)), db.InRepo(repo.Name)))
+		if err != nil {
+			return err
+		}
+
+		err = db.DeleteRepoFile(ctx, repo.Name, f.Path)
+		if err != nil {
+			return err
+		}
+
+		err = db.DeleteIndexError(ctx, repo.Name, filepath.ToSlash(f.Path))
+		if err != nil {
+			return err
+		}
+
+		changed++
+	}
+
+	ix.summarize(ctx)
+
+	if changed == 0 {
+		log.Info("Repository up to date").Str("name", repo.Name).Send()
+	} else {
+		log.Info("Indexed changed packages").Str("name", repo.Name).Int("changed", changed).Send()
+	}
+
+	return nil
+}
+
+// fingerprint returns the latest modification time and the hash
+// of the inputs of the package built by the script at scriptPath
+func fingerprint(repoDir, scriptPath string, files []string) (int64, string, error) {
+	paths, parents, err := packageInputs(repoDir, scriptPath, files)
+	if err != nil {
+		return 0, "", err
+	}
+
+	mtime, err := inputsModTime(append(paths, parents...))
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash, err := inputsHash(repoDir, paths)
+	return mtime, hash, err
+}
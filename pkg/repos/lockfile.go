@@ -0,0 +1,437 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pelletier/go-toml/v2"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+// LockfileVersion is the version of the lockfile format
+const LockfileVersion = 1
+
+const lockfileHeader = "# This file was generated by lure lock. Use lure sync to install the packages it contains.\n\n"
+
+// Lockfile records the commits LURE's repos are checked out at, and
+// the exact versions of a set of packages, so that the same versions
+// can be installed on other machines.
+type Lockfile struct {
+	Version  int             `toml:"version"`
+	Repos    []LockedRepo    `toml:"repo"`
+	Packages []LockedPackage `toml:"package"`
+}
+
+// LockedRepo is a repo recorded in a lockfile
+type LockedRepo struct {
+	Name string `toml:"name"`
+	URL  string `toml:"url,omitempty"`
+	// Commit is the commit the repo was checked out at.
+	// It's empty for repos that aren't git repos.
+	Commit string `toml:"commit,omitempty"`
+}
+
+// LockedPackage is a package recorded in a lockfile
+type LockedPackage struct {
+	Name    string `toml:"name"`
+	Repo    string `toml:"repo"`
+	Path    string `toml:"path"`
+	Version string `toml:"version"`
+	Release int    `toml:"release"`
+	Epoch   uint   `toml:"epoch,omitempty"`
+}
+
+// FullVersion returns the version of the package in the
+// same format the system package manager uses
+func (lp LockedPackage) FullVersion() string {
+	return fullVersion(lp.Version, lp.Release, lp.Epoch)
+}
+
+// Package returns the DB package the locked package refers to
+func (lp LockedPackage) Package() db.Package {
+	return db.Package{
+		Name:       lp.Name,
+		Repository: lp.Repo,
+		Path:       lp.Path,
+		Version:    lp.Version,
+		Release:    lp.Release,
+		Epoch:      lp.Epoch,
+	}
+}
+
+// Lock creates a lockfile for the given packages, using
+// the commits the enabled repos are currently checked out at
+func Lock(ctx context.Context, pkgs []db.Package) (Lockfile, error) {
+	log := loggerctx.From(ctx)
+
+	out := Lockfile{Version: LockfileVersion}
+	for _, repo := range config.Config(ctx).Repos {
+		if repo.Disabled {
+			continue
+		}
+
+		status, err := GetStatus(ctx, repo)
+		if err != nil {
+			return Lockfile{}, fmt.Errorf("%s: %w", repo.Name, err)
+		}
+
+		if status.Type == "git" && status.Commit == "" {
+			return Lockfile{}, fmt.Errorf("%s: repository has not been pulled", repo.Name)
+		} else if status.Type != "git" {
+			log.Warn("Only git repositories can be locked to a specific commit").Str("repo", repo.Name).Send()
+		}
+
+		out.Repos = append(out.Repos, LockedRepo{
+			Name:   repo.Name,
+			URL:    repo.URL,
+			Commit: status.Commit,
+		})
+	}
+
+	for _, pkg := range pkgs {
+		out.Packages = append(out.Packages, LockedPackage{
+			Name:    pkg.Name,
+			Repo:    pkg.Repository,
+			Path:    pkg.Path,
+			Version: pkg.Version,
+			Release: pkg.Release,
+			Epoch:   pkg.Epoch,
+		})
+	}
+
+	return out, nil
+}
+
+// ReadLockfile reads the lockfile at the given path
+func ReadLockfile(path string) (Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Lockfile{}, err
+	}
+
+	var lf Lockfile
+	err = toml.Unmarshal(data, &lf)
+	if err != nil {
+		return Lockfile{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if lf.Version != LockfileVersion {
+		return Lockfile{}, fmt.Errorf("%s: unsupported lockfile version %d; try updating LURE", path, lf.Version)
+	}
+
+	return lf, nil
+}
+
+// WriteLockfile writes a lockfile to the given path
+func WriteLockfile(path string, lf Lockfile) error {
+	data, err := toml.Marshal(lf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(lockfileHeader), data...), 0o644)
+}
+
+type dirOverridesKey struct{}
+
+// Checkout writes the files of each git repo in a lockfile, at the commit
+// recorded in the lockfile, to a temporary directory. The repos' own files
+// aren't changed. It returns a context in which those directories are used
+// as the repos' directories, so that packages are built from them, and a
+// function that removes them. Checkout also makes sure that the repos
+// contain the exact package versions recorded in the lockfile.
+func Checkout(ctx context.Context, lf Lockfile) (context.Context, func(), error) {
+	ctx, cleanup, err := checkout(ctx, lf.Repos)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, lp := range lf.Packages {
+		_, repo := findRepo(config.Config(ctx), lp.Repo)
+		err = verifyLocked(ctx, repo, lp)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+	}
+
+	return ctx, cleanup, nil
+}
+
+// CheckoutCommit is like Checkout, but it only checks out
+// the given commit of the repo with the given name
+func CheckoutCommit(ctx context.Context, repoName, commit string) (context.Context, func(), error) {
+	return checkout(ctx, []LockedRepo{{Name: repoName, Commit: commit}})
+}
+
+// checkout writes the files of the given repos to a temporary directory
+// and returns a context in which they're used as the repos' directories
+func checkout(ctx context.Context, locked []LockedRepo) (context.Context, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "lure-checkout-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	repos := map[string]types.Repo{}
+	for _, repo := range config.Config(ctx).Repos {
+		repos[repo.Name] = repo
+	}
+
+	dirs := map[string]string{}
+	for _, lr := range locked {
+		repo, ok := repos[lr.Name]
+		if !ok {
+			cleanup()
+			return nil, nil, fmt.Errorf("%s: repository is not configured", lr.Name)
+		}
+
+		if lr.Commit == "" {
+			continue
+		}
+
+		dir := filepath.Join(tmpDir, lr.Name)
+		err = checkoutCommit(ctx, repo, lr.Commit, dir)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("%s: %w", lr.Name, err)
+		}
+		dirs[lr.Name] = dir
+	}
+
+	return context.WithValue(ctx, dirOverridesKey{}, dirs), cleanup, nil
+}
+
+// checkoutCommit writes the files of a git repo at
+// the given commit to dir, fetching it if necessary
+func checkoutCommit(ctx context.Context, repo types.Repo, rev, dir string) error {
+	if _, ok := mustBackend(repo).(gitBackend); !ok {
+		return errors.New("repository is not a git repository")
+	}
+
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		return err
+	}
+	defer reposLock.Release()
+
+	r, err := git.PlainOpen(gitBackend{}.Dir(ctx, repo))
+	if err != nil {
+		return err
+	}
+
+	hash, err := resolveCommit(r, rev)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) && plumbing.IsHash(rev) {
+		// The local copy of the repo may be older than the
+		// commit, so it may have to be fetched first.
+		authMethod, err := gitAuth(ctx, repo.URL)
+		if err != nil {
+			return err
+		}
+
+		err = r.FetchContext(ctx, &git.FetchOptions{
+			RemoteURL: repo.URL,
+			Auth:      authMethod,
+			RefSpecs:  []gitConfig.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+			Tags:      git.AllTags,
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return err
+		}
+		hash, err = resolveCommit(r, rev)
+	}
+	if err != nil {
+		return fmt.Errorf("commit %s: %w", rev, err)
+	}
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("commit %s: %w", rev, err)
+	}
+
+	// The commit may come from anywhere, such as a lockfile, so
+	// it has to be signed just like the ones that are pulled.
+	err = verifyTarget(ctx, r, repo, plumbing.ZeroHash, hash)
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	return exportCommit(commit, dir)
+}
+
+// resolveCommit returns the hash of the commit the given
+// revision refers to, such as a full or abbreviated hash
+func resolveCommit(r *git.Repository, rev string) (plumbing.Hash, error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return *hash, nil
+}
+
+// exportCommit writes all the files in a commit to dir
+func exportCommit(c *object.Commit, dir string) error {
+	files, err := c.Files()
+	if err != nil {
+		return err
+	}
+
+	return files.ForEach(func(f *object.File) error {
+		if !filepath.IsLocal(f.Name) {
+			return fmt.Errorf("invalid file path in commit: %s", f.Name)
+		}
+
+		fpath := filepath.Join(dir, filepath.FromSlash(f.Name))
+		err := os.MkdirAll(filepath.Dir(fpath), 0o755)
+		if err != nil {
+			return err
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		if f.Mode == filemode.Symlink {
+			target, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(string(target), fpath)
+		}
+
+		mode := os.FileMode(0o644)
+		if f.Mode == filemode.Executable {
+			mode = 0o755
+		}
+
+		fl, err := os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		defer fl.Close()
+
+		_, err = io.Copy(fl, r)
+		return err
+	})
+}
+
+// verifyLocked makes sure the repo contains the exact
+// version of the package recorded in the lockfile
+func verifyLocked(ctx context.Context, repo types.Repo, lp LockedPackage) error {
+	pkg, err := parsePkg(ctx, repo, lp.Package())
+	if err != nil {
+		return fmt.Errorf("%s/%s: %w", lp.Repo, lp.Name, err)
+	}
+
+	locked := lp.FullVersion()
+	actual := FullVersion(pkg)
+	if pkg.Name != lp.Name || actual != locked {
+		return fmt.Errorf("%s/%s: lockfile requires version %s, but repository contains %s %s", lp.Repo, lp.Name, locked, pkg.Name, actual)
+	}
+
+	return nil
+}
+
+// ParsePkg returns the given package as it's defined in the files of its
+// repo, rather than in the DB. In a context returned by Checkout, those are
+// the files at the commit recorded in the lockfile, so this can be used to
+// find out what a locked package depends on. For HTTP repos, which can't be
+// checked out, the package is read from the DB.
+func ParsePkg(ctx context.Context, pkg db.Package) (db.Package, error) {
+	_, repo := findRepo(config.Config(ctx), pkg.Repository)
+	return parsePkg(ctx, repo, pkg)
+}
+
+func parsePkg(ctx context.Context, repo types.Repo, pkg db.Package) (db.Package, error) {
+	if _, ok := mustBackend(repo).(httpBackend); ok {
+		// The packages of HTTP repos can only be read from the index
+		dbPkg, err := db.GetPkg(ctx, db.And(db.InRepo(repo.Name), db.ByName(pkg.Name)))
+		if err != nil {
+			return db.Package{}, err
+		}
+		return *dbPkg, nil
+	}
+
+	scriptPath, err := dirScriptPath(ctx, repo, pkg)
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	ix, err := newIndexer(ctx, repo, Dir(ctx, repo))
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	data, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	parsed, err := ix.parse(ctx, scriptPath, io.NopCloser(bytes.NewReader(data)))
+	if err != nil && !errors.Is(err, ErrPolicyViolation) {
+		return db.Package{}, err
+	}
+	return parsed, nil
+}
+
+// FullVersion returns the version of a DB package in the
+// same format the system package manager uses
+func FullVersion(pkg db.Package) string {
+	return fullVersion(pkg.Version, pkg.Release, pkg.Epoch)
+}
+
+// fullVersion returns a package version in the
+// same format the system package manager uses
+func fullVersion(version string, release int, epoch uint) string {
+	switch {
+	case release != 0 && epoch == 0:
+		return fmt.Sprintf("%s-%d", version, release)
+	case release != 0:
+		return fmt.Sprintf("%d:%s-%d", epoch, version, release)
+	default:
+		return version
+	}
+}
+
+// mustBackend returns the backend of a repo, falling back to
+// the git backend if the repo's type is invalid, just like Dir
+func mustBackend(repo types.Repo) backend {
+	b, err := backendFor(repo)
+	if err != nil {
+		return gitBackend{}
+	}
+	return b
+}
@@ -0,0 +1,137 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestLockfile(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	srcDir := filepath.Join(tmp, "src")
+	r, err := git.PlainInit(srcDir, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	commit := func(version, dep string) {
+		t.Helper()
+		commitFile(t, r, "foo/lure.sh", "name=foo\nversion="+version+"\nrelease=1\narchitectures=(all)\ndeps=("+dep+")\n")
+	}
+
+	commit("1.0.0", "bar")
+	config.Config(ctx).Repos = []types.Repo{{Name: "git", Type: "git", URL: srcDir}}
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	pkg, err := db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("git")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	lf, err := repos.Lock(ctx, []db.Package{*pkg})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	lockPath := filepath.Join(tmp, "lure.lock")
+	err = repos.WriteLockfile(lockPath, lf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	lf, err = repos.ReadLockfile(lockPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(lf.Repos) != 1 || lf.Repos[0].Commit == "" {
+		t.Fatalf("Expected repo commit to be locked, got %+v", lf.Repos)
+	}
+
+	if len(lf.Packages) != 1 || lf.Packages[0].FullVersion() != "1.0.0-1" {
+		t.Fatalf("Expected foo 1.0.0-1 to be locked, got %+v", lf.Packages)
+	}
+
+	// Once the repo moves on, the checkout should still
+	// contain the version recorded in the lockfile.
+	commit("2.0.0", "baz")
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	lctx, cleanup, err := repos.Checkout(ctx, lf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	script, err := repos.PackageScriptPath(lctx, lf.Packages[0].Package())
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if !strings.Contains(string(data), "version=1.0.0") {
+		t.Errorf("Expected checkout to contain version 1.0.0, got %q", data)
+	}
+
+	// The dependencies of the locked version should
+	// be used, rather than the ones in the DB.
+	parsed, err := repos.ParsePkg(lctx, lf.Packages[0].Package())
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if deps := parsed.Depends.Val[""]; len(deps) != 1 || deps[0] != "bar" {
+		t.Errorf("Expected locked dependency on bar, got %v", deps)
+	}
+
+	cleanup()
+	if _, err := os.Stat(script); !os.IsNotExist(err) {
+		t.Errorf("Expected checkout to be removed, got %v", err)
+	}
+
+	// A lockfile version the repo doesn't contain should be rejected
+	lf.Packages[0].Version = "3.0.0"
+	_, _, err = repos.Checkout(ctx, lf)
+	if err == nil {
+		t.Errorf("Expected error for version missing from repo")
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/types"
+)
+
+// Status contains information about the current state of a repo
+type Status struct {
+	// Type is the kind of repo, such as git, local, or http
+	Type string
+	// LastRefresh is the last time the repo was pulled
+	// successfully. It's zero if it was never pulled.
+	LastRefresh time.Time
+	// Packages is the amount of packages in the repo
+	Packages int
+	// Commit is the commit a git repo is checked out at
+	Commit string
+	// BrokenPackages is the amount of build scripts
+	// in the repo that couldn't be indexed
+	BrokenPackages int
+}
+
+// GetStatus returns the current state of the given repo
+func GetStatus(ctx context.Context, repo types.Repo) (Status, error) {
+	b, err := backendFor(repo)
+	if err != nil {
+		return Status{}, err
+	}
+
+	var out Status
+	switch b.(type) {
+	case gitBackend:
+		out.Type = "git"
+		if r, err := git.PlainOpen(b.Dir(ctx, repo)); err == nil {
+			if head, err := r.Head(); err == nil {
+				out.Commit = head.Hash().String()
+			}
+		}
+	case localBackend:
+		out.Type = "local"
+	case httpBackend:
+		out.Type = "http"
+	}
+
+	times, err := db.GetRefreshTimes(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	out.LastRefresh = times[repo.Name]
+
+	out.Packages, err = db.CountPkgs(ctx, db.InRepo(repo.Name))
+	if err != nil {
+		return Status{}, err
+	}
+
+	indexErrs, err := db.GetIndexErrors(ctx, repo.Name)
+	if err != nil {
+		return Status{}, err
+	}
+	out.BrokenPackages = len(indexErrs)
+
+	return out, nil
+}
+
+// Remove deletes the files LURE downloaded for the repo with the given
+// name and removes its packages from the DB. The repo isn't removed from
+// the config, and the directories of local repos are left alone.
+func Remove(ctx context.Context, name string) error {
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		return err
+	}
+	defer reposLock.Release()
+
+	dbLock, err := lock.Exclusive(ctx, lock.DB)
+	if err != nil {
+		return err
+	}
+	defer dbLock.Release()
+
+	err = os.RemoveAll(filepath.Join(config.GetPaths(ctx).RepoDir, name))
+	if err != nil {
+		return err
+	}
+
+	err = db.DeletePkgs(ctx, db.InRepo(name))
+	if err != nil {
+		return err
+	}
+
+	err = db.DeleteRepoFiles(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	err = db.DeleteIndexErrors(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return db.DeleteRefreshTime(ctx, name)
+}
+
+// Rename moves the files LURE downloaded for the repo named from, as well
+// as its packages in the DB, to the repo named to. The config isn't changed.
+func Rename(ctx context.Context, from, to string) error {
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		return err
+	}
+	defer reposLock.Release()
+
+	dbLock, err := lock.Exclusive(ctx, lock.DB)
+	if err != nil {
+		return err
+	}
+	defer dbLock.Release()
+
+	repoDir := config.GetPaths(ctx).RepoDir
+	oldDir := filepath.Join(repoDir, from)
+	newDir := filepath.Join(repoDir, to)
+
+	if _, err := os.Stat(newDir); err == nil {
+		return fmt.Errorf("%s: directory already exists", newDir)
+	}
+
+	err = os.Rename(oldDir, newDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return db.RenameRepo(ctx, from, to)
+}
+
+// ValidName checks whether the given string can be used as a repo name
+func ValidName(name string) bool {
+	return validPkgName(name)
+}
@@ -0,0 +1,346 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// OverlayScript is the name of the script in an overlay
+// that runs after the build script of the package
+const OverlayScript = "overlay.sh"
+
+// Overlay is a local customization of a package from a repo. Overlays are
+// stored in overlays/<repo>/<package> in the LURE config directory. An overlay
+// either contains a lure.sh file that replaces the package's build script, or
+// an overlay.sh file that runs after it, which can add to or override its
+// variables and functions.
+type Overlay struct {
+	// Dir is the directory containing the overlay's files
+	Dir string
+	// Replace is true if the overlay replaces the build
+	// script completely, rather than running after it
+	Replace bool
+}
+
+// FindOverlay returns the overlay of the package with the given name
+// in the repo with the given name. If there isn't one, false is returned.
+func FindOverlay(ctx context.Context, repo, pkg string) (Overlay, bool, error) {
+	if !validPkgName(repo) || !validPkgName(pkg) {
+		return Overlay{}, false, nil
+	}
+
+	dir := filepath.Join(config.GetPaths(ctx).OverlayDir, repo, pkg)
+	for _, ov := range []Overlay{{Dir: dir, Replace: true}, {Dir: dir}} {
+		_, err := os.Stat(ov.Script())
+		if err == nil {
+			return ov, true, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return Overlay{}, false, err
+		}
+	}
+
+	return Overlay{}, false, nil
+}
+
+// FindScriptOverlay returns the overlay of the package with the given name,
+// whose build script is located at scriptPath. The repo the package belongs
+// to is found using the location of the script. If the script isn't part of
+// a configured repo, or there's no overlay, false is returned.
+func FindScriptOverlay(ctx context.Context, scriptPath, pkg string) (Overlay, bool, error) {
+	scriptPath, err := filepath.Abs(scriptPath)
+	if err != nil {
+		return Overlay{}, false, err
+	}
+
+	for _, repo := range config.Config(ctx).Repos {
+		rel, err := filepath.Rel(Dir(ctx, repo), scriptPath)
+		if err == nil && filepath.IsLocal(rel) {
+			return FindOverlay(ctx, repo.Name, pkg)
+		}
+	}
+
+	return Overlay{}, false, nil
+}
+
+// indexOverlays indexes the packages of a repo whose overlays were added,
+// changed, or removed since the last time they were indexed. The packages
+// of HTTP repos aren't indexed locally, so their overlays are only applied
+// when they're built.
+func indexOverlays(ctx context.Context, repo types.Repo) error {
+	b, err := backendFor(repo)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := b.(httpBackend); ok {
+		return nil
+	}
+
+	pkgs, err := db.GetPkgs(ctx, db.Query{Where: db.InRepo(repo.Name)})
+	if err != nil {
+		return err
+	}
+
+	var ix *indexer
+	changed := 0
+	for _, pkg := range pkgs {
+		ov, ok, err := FindOverlay(ctx, repo.Name, pkg.Name)
+		if err != nil {
+			return err
+		}
+
+		var hash string
+		if ok {
+			hash, err = ov.Hash()
+			if err != nil {
+				return err
+			}
+		}
+
+		if hash == pkg.Overlay {
+			continue
+		}
+
+		if ix == nil {
+			ix, err = newIndexer(ctx, repo, b.Dir(ctx, repo))
+			if err != nil {
+				return err
+			}
+		}
+
+		scriptPath := filepath.Join(ix.repoDir, filepath.FromSlash(pkg.Path))
+		scriptFl, err := os.Open(scriptPath)
+		if err != nil {
+			return err
+		}
+
+		_, err = ix.index(ctx, scriptPath, scriptFl)
+		if err != nil {
+			return fmt.Errorf("%s: %w", scriptPath, err)
+		}
+		changed++
+	}
+
+	// Packages that couldn't be indexed aren't in the DB, so there's no
+	// way to tell whether their overlays changed. If the repo has any
+	// overlays, they're indexed again in case an overlay was the problem.
+	if _, err := os.Stat(filepath.Join(config.GetPaths(ctx).OverlayDir, repo.Name)); err == nil {
+		failed, err := db.GetIndexErrors(ctx, repo.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, ie := range failed {
+			if ix == nil {
+				ix, err = newIndexer(ctx, repo, b.Dir(ctx, repo))
+				if err != nil {
+					return err
+				}
+			}
+
+			scriptPath := filepath.Join(ix.repoDir, filepath.FromSlash(ie.Path))
+			scriptFl, err := os.Open(scriptPath)
+			if err != nil {
+				return err
+			}
+
+			prevErrors := ix.errors
+			_, err = ix.index(ctx, scriptPath, scriptFl)
+			if err != nil {
+				return fmt.Errorf("%s: %w", scriptPath, err)
+			}
+
+			if ix.errors == prevErrors {
+				changed++
+			}
+		}
+	}
+
+	if ix != nil {
+		ix.summarize(ctx)
+	}
+
+	if changed > 0 {
+		loggerctx.From(ctx).Info("Indexed packages with changed overlays").Str("name", repo.Name).Int("changed", changed).Send()
+	}
+
+	return nil
+}
+
+// Script returns the path of the overlay's script
+func (o Overlay) Script() string {
+	if o.Replace {
+		return filepath.Join(o.Dir, "lure.sh")
+	}
+	return filepath.Join(o.Dir, OverlayScript)
+}
+
+// Apply returns the build script fl with the overlay applied. Before the
+// overlay's script runs, the overlaydir variable is set to the overlay's
+// directory, so that the script can refer to the overlay's other files.
+func (o Overlay) Apply(fl *syntax.File) (*syntax.File, error) {
+	scriptFl, err := os.Open(o.Script())
+	if err != nil {
+		return nil, err
+	}
+	defer scriptFl.Close()
+
+	parser := syntax.NewParser()
+	ovFl, err := parser.Parse(scriptFl, o.Script())
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := syntax.Quote(o.Dir, syntax.LangBash)
+	if err != nil {
+		return nil, err
+	}
+
+	setDir, err := parser.Parse(strings.NewReader("overlaydir="+dir+"\n"), o.Script())
+	if err != nil {
+		return nil, err
+	}
+
+	// If the overlay replaces the build script, none
+	// of the original script's statements are kept
+	var stmts []*syntax.Stmt
+	if !o.Replace {
+		stmts = slices.Clone(fl.Stmts)
+	}
+	stmts = append(stmts, setDir.Stmts...)
+
+	out := *ovFl
+	out.Stmts = append(stmts, ovFl.Stmts...)
+	return &out, nil
+}
+
+// Hash returns a hash of the overlay's files,
+// which changes whenever any of them change
+func (o Overlay) Hash() (string, error) {
+	var paths []string
+	err := filepath.WalkDir(o.Dir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, fpath)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return inputsHash(o.Dir, paths)
+}
+
+// Diff returns a diff between the build script at scriptPath
+// and the script that results from applying the overlay to it
+func (o Overlay) Diff(scriptPath string) (string, error) {
+	orig, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(o.Script())
+	if err != nil {
+		return "", err
+	}
+
+	modified := string(data)
+	if !o.Replace {
+		modified = strings.TrimSuffix(string(orig), "\n") + "\n\n# " + o.Script() + "\n" + modified
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("--- " + scriptPath + "\n")
+	sb.WriteString("+++ " + o.Script() + "\n")
+	for _, line := range diffLines(splitLines(string(orig)), splitLines(modified)) {
+		sb.WriteString(line + "\n")
+	}
+
+	return sb.String(), nil
+}
+
+// diffLines returns a diff between the lines in a and the lines in b.
+// Lines that are in both are prefixed with a space, lines that are only
+// in a are prefixed with "-", and lines that are only in b with "+".
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest
+	// common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	out := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+
+	return out
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,83 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestOverlay(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	repoDir := filepath.Join(tmp, "repo")
+	scriptPath := filepath.Join(repoDir, "foo", "lure.sh")
+	writeFile(t, scriptPath, "name=foo\nversion=1.0.0\nrelease=1\narchitectures=(all)\ndeps=(bar)\n")
+
+	config.Config(ctx).Repos = []types.Repo{{Name: "local", Path: repoDir}}
+
+	err := repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	// Adding an overlay should cause the package to be indexed
+	// again, even though the repo itself didn't change.
+	overlayDir := filepath.Join(config.GetPaths(ctx).OverlayDir, "local", "foo")
+	writeFile(t, filepath.Join(overlayDir, "overlay.sh"), "deps+=(baz)\n")
+
+	err = repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	pkg, err := db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("local")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if deps := pkg.Depends.Val[""]; len(deps) != 2 || deps[1] != "baz" {
+		t.Errorf("Expected overlay to add baz to deps, got %v", deps)
+	}
+
+	ov, ok, err := repos.FindScriptOverlay(ctx, scriptPath, "foo")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	} else if !ok {
+		t.Fatalf("Expected overlay to be found")
+	}
+
+	diff, err := ov.Diff(scriptPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if !strings.Contains(diff, "\n deps=(bar)\n") || !strings.Contains(diff, "\n+deps+=(baz)\n") {
+		t.Errorf("Expected diff to contain the script and the overlay, got:\n%s", diff)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+)
+
+// resolveDuplicates sorts packages so that the ones that should be preferred
+// come first, and removes any package that has the same name as a preferred
+// package from another repo. A package is preferred if its repo is pinned
+// for it in the config, then if its repo has a higher priority, then if its
+// repo comes first in the config. Packages from repos with equal priority
+// are kept, since neither one is clearly preferred.
+func resolveDuplicates(cfg *types.Config, pkgs []db.Package) []db.Package {
+	slices.SortStableFunc(pkgs, func(a, b db.Package) int {
+		return comparePkgs(cfg, a, b)
+	})
+
+	var out []db.Package
+	for _, pkg := range pkgs {
+		i := slices.IndexFunc(out, func(p db.Package) bool {
+			return p.Name == pkg.Name
+		})
+
+		// Keep the package if there's no other package with the
+		// same name yet, or if it's tied with the one that's there.
+		if i == -1 || comparePkgs(cfg, out[i], pkg) == 0 {
+			out = append(out, pkg)
+		}
+	}
+	return out
+}
+
+// comparePkgs compares two packages by pin and repo priority. It returns
+// a negative number if a should be preferred over b, a positive number if
+// b should be preferred over a, and zero if there's no preference.
+func comparePkgs(cfg *types.Config, a, b db.Package) int {
+	if a.Name == b.Name {
+		if pinned, ok := cfg.Pin[a.Name]; ok {
+			if a.Repository == pinned && b.Repository != pinned {
+				return -1
+			} else if b.Repository == pinned && a.Repository != pinned {
+				return 1
+			}
+		}
+	}
+
+	aIndex, aRepo := findRepo(cfg, a.Repository)
+	bIndex, bRepo := findRepo(cfg, b.Repository)
+	if aRepo.Priority != bRepo.Priority {
+		return bRepo.Priority - aRepo.Priority
+	}
+
+	// Both repos have the same priority, so fall back to the order
+	// in the config to keep the result deterministic. The caller
+	// decides whether this counts as a preference.
+	if a.Name == b.Name {
+		return 0
+	}
+	return aIndex - bIndex
+}
+
+// findRepo returns the index of the repo with the given name in the
+// config, as well as the repo itself.
+func findRepo(cfg *types.Config, name string) (int, types.Repo) {
+	for i, repo := range cfg.Repos {
+		if repo.Name == name {
+			return i, repo
+		}
+	}
+	return len(cfg.Repos), types.Repo{Name: name}
+}
+
+// ExplainChoice returns a human-readable explanation of why the given
+// package's repo is used rather than any other repo with the same package.
+func ExplainChoice(ctx context.Context, pkg db.Package) (string, error) {
+	cfg := config.Config(ctx)
+
+	pkgs, err := db.GetPkgs(ctx, db.Query{Where: db.And(db.ByName(pkg.Name), enabledRepos(cfg))})
+	if err != nil {
+		return "", err
+	}
+
+	if len(pkgs) <= 1 {
+		return fmt.Sprintf("%s is the only repo containing %s", pkg.Repository, pkg.Name), nil
+	}
+
+	if pinned, ok := cfg.Pin[pkg.Name]; ok && pinned == pkg.Repository {
+		return fmt.Sprintf("%s is pinned to %s in the config", pkg.Name, pkg.Repository), nil
+	}
+
+	ranked := resolveDuplicates(cfg, pkgs)
+	if len(ranked) > 1 {
+		repoNames := make([]string, len(ranked))
+		for i, p := range ranked {
+			repoNames[i] = p.Repository
+		}
+		return fmt.Sprintf("%s have the same priority, so the first one in the config is used unless another one is chosen", strings.Join(repoNames, ", ")), nil
+	}
+
+	_, repo := findRepo(cfg, ranked[0].Repository)
+	return fmt.Sprintf("%s has the highest priority (%d)", ranked[0].Repository, repo.Priority), nil
+}
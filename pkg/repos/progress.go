@@ -0,0 +1,77 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// progressMtx makes sure lines from repos that are
+// pulled concurrently don't get mixed together
+var progressMtx sync.Mutex
+
+// prefixWriter prefixes every line written to it with the name
+// of a repo, so that the progress output of repos that are pulled
+// concurrently can be told apart. Lines may end with either "\n"
+// or "\r", since git uses "\r" to update progress in place.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, name string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte("[" + name + "] ")}
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	pw.buf = append(pw.buf, b...)
+
+	for {
+		i := bytes.IndexAny(pw.buf, "\r\n")
+		if i == -1 {
+			break
+		}
+
+		end := i + 1
+		if pw.buf[i] == '\r' && end < len(pw.buf) && pw.buf[end] == '\n' {
+			end++
+		}
+
+		// Empty lines don't need a prefix
+		if i > 0 {
+			line := make([]byte, 0, len(pw.prefix)+end)
+			line = append(line, pw.prefix...)
+			line = append(line, pw.buf[:end]...)
+
+			progressMtx.Lock()
+			_, err := pw.w.Write(line)
+			progressMtx.Unlock()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		pw.buf = pw.buf[end:]
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,211 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"lure.sh/lure/internal/sigverify"
+	"lure.sh/lure/internal/types"
+)
+
+// Publish generates the files of an HTTP repo from the repo in srcDir,
+// which may be a git repo or any other directory, and writes them to outDir.
+// If signer isn't nil, the index is signed using it.
+func Publish(ctx context.Context, srcDir, outDir string, signer ssh.Signer) error {
+	ix, err := newIndexer(ctx, types.Repo{}, srcDir)
+	if err != nil {
+		return err
+	}
+
+	l, err := ix.layout()
+	if err != nil {
+		return err
+	}
+
+	matches, err := l.findScripts(srcDir)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(outDir, "pkgs"), 0o755)
+	if err != nil {
+		return err
+	}
+
+	idx := Index{Version: IndexVersion}
+
+	repoCfg, err := os.ReadFile(filepath.Join(srcDir, "lure-repo.toml"))
+	if err == nil {
+		idx.RepoConfig = string(repoCfg)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	seen := map[string]string{}
+
+	for _, match := range matches {
+		scriptFl, err := os.Open(match)
+		if err != nil {
+			return err
+		}
+
+		pkg, err := ix.parse(ctx, match, scriptFl)
+		if err != nil {
+			return fmt.Errorf("%s: %w", match, err)
+		}
+
+		if !validPkgName(pkg.Name) {
+			return fmt.Errorf("%s: invalid package name: %q", match, pkg.Name)
+		}
+
+		if prev, ok := seen[pkg.Name]; ok {
+			return fmt.Errorf("package %s is defined by both %s and %s", pkg.Name, prev, match)
+		}
+		seen[pkg.Name] = match
+
+		archive := path.Join("pkgs", pkg.Name+".tar.gz")
+		sum, err := writeTarGz(filepath.Dir(match), filepath.Join(outDir, archive))
+		if err != nil {
+			return err
+		}
+
+		idx.Packages = append(idx.Packages, IndexPackage{
+			Package: pkg,
+			Archive: archive,
+			SHA256:  sum,
+		})
+	}
+
+	data, err := json.MarshalIndent(idx, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(outDir, IndexFile), data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	sigPath := filepath.Join(outDir, IndexSigFile)
+	if signer == nil {
+		// Don't leave behind a signature for an older index
+		err = os.Remove(sigPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	sig, err := sigverify.SignSSH(signer, bytes.NewReader(data), IndexNamespace)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(sigPath, sig, 0o644)
+}
+
+// writeTarGz writes a gzip-compressed tar archive containing the files
+// in dir to path, and returns its SHA256 checksum. The archive doesn't
+// contain modification times or owners, so that it only changes when
+// the contents of the files change.
+func writeTarGz(dir, path string) (string, error) {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+
+	err := filepath.WalkDir(dir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, fpath)
+		if err != nil || name == "." {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(name),
+			Mode:    int64(fi.Mode().Perm()),
+			ModTime: time.Unix(0, 0),
+		}
+
+		switch {
+		case fi.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			return tw.WriteHeader(hdr)
+		case fi.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = fi.Size()
+		default:
+			return fmt.Errorf("%s: only regular files and directories are supported", fpath)
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		fl, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		defer fl.Close()
+
+		_, err = io.Copy(tw, fl)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = gw.Close()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), os.WriteFile(path, buf.Bytes(), 0o644)
+}
@@ -21,24 +21,27 @@ package repos
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
-	"net/url"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
-	"github.com/go-git/go-billy/v5"
-	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/diff"
-	"github.com/pelletier/go-toml/v2"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"go.elara.ws/vercmp"
+	"golang.org/x/exp/slices"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/shutils/decoder"
 	"lure.sh/lure/internal/shutils/handlers"
+	"lure.sh/lure/internal/shutils/limits"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/distro"
 	"lure.sh/lure/pkg/loggerctx"
@@ -47,138 +50,188 @@ import (
 	"mvdan.cc/sh/v3/syntax"
 )
 
+// maxConcurrentPulls is the maximum amount of repos that are pulled at once
+const maxConcurrentPulls = 4
+
 // Pull pulls the provided repositories. If a repo doesn't exist, it will be cloned
 // and its packages will be written to the DB. If it does exist, it will be pulled.
 // In this case, only changed packages will be processed if possible.
+// Local repos are indexed in place rather than cloned.
 // If repos is set to nil, the repos in the LURE config will be used.
+// Disabled repos are skipped.
+//
+// Repos are fetched concurrently, but their packages are written to the DB
+// one repo at a time. If a repo fails to pull, the others are still pulled,
+// and the errors for all failed repos are returned together.
 func Pull(ctx context.Context, repos []types.Repo) error {
 	log := loggerctx.From(ctx)
 
-	if repos == nil {
-		repos = config.Config(ctx).Repos
+	repos = pullableRepos(ctx, repos)
+
+	reposLock, err := lock.Exclusive(ctx, lock.Repos)
+	if err != nil {
+		return err
 	}
+	defer reposLock.Release()
 
-	for _, repo := range repos {
-		repoURL, err := url.Parse(repo.URL)
-		if err != nil {
-			return err
-		}
+	// If the DB was empty at startup, every repo has to be indexed
+	// fully, even the ones that are already up to date. The same
+	// applies to repos that were never pulled successfully.
+	full := db.IsEmpty(ctx)
 
-		log.Info("Pulling repository").Str("name", repo.Name).Send()
-		repoDir := filepath.Join(config.GetPaths(ctx).RepoDir, repo.Name)
+	refreshed, err := db.GetRefreshTimes(ctx)
+	if err != nil {
+		return err
+	}
 
-		var repoFS billy.Filesystem
-		gitDir := filepath.Join(repoDir, ".git")
-		// Only pull repos that contain valid git repos
-		if fi, err := os.Stat(gitDir); err == nil && fi.IsDir() {
-			r, err := git.PlainOpen(repoDir)
-			if err != nil {
-				return err
+	type result struct {
+		repo  types.Repo
+		index indexFunc
+		err   error
+	}
+
+	results := make(chan result, len(repos))
+	sem := make(chan struct{}, maxConcurrentPulls)
+	for _, repo := range repos {
+		go func(repo types.Repo) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			_, ok := refreshed[repo.Name]
+			index, err := pullRepo(ctx, repo, full || !ok)
+			results <- result{repo, index, err}
+		}(repo)
+	}
+
+	var errs []error
+	for range repos {
+		res := <-results
+
+		err := withDBLock(ctx, func() error {
+			// The index function may be returned along with an error if
+			// the repo's files were updated, in which case the DB still
+			// has to be updated to match them.
+			if res.index != nil {
+				err := res.index(ctx)
+				if res.err == nil {
+					res.err = err
+				}
 			}
 
-			w, err := r.Worktree()
-			if err != nil {
-				return err
+			if res.err != nil {
+				return nil
 			}
 
-			old, err := r.Head()
+			err := indexOverlays(ctx, res.repo)
 			if err != nil {
 				return err
 			}
 
-			err = w.PullContext(ctx, &git.PullOptions{Progress: os.Stderr})
-			if errors.Is(err, git.NoErrAlreadyUpToDate) {
-				log.Info("Repository up to date").Str("name", repo.Name).Send()
-			} else if err != nil {
-				return err
-			}
-			repoFS = w.Filesystem
+			return db.SetRefreshTime(ctx, res.repo.Name, time.Now())
+		})
+		if res.err == nil {
+			res.err = err
+		}
 
-			// Make sure the DB is created even if the repo is up to date
-			if !errors.Is(err, git.NoErrAlreadyUpToDate) || db.IsEmpty(ctx) {
-				new, err := r.Head()
-				if err != nil {
-					return err
-				}
+		if res.err != nil {
+			log.Error("Error pulling repository").Str("name", res.repo.Name).Err(res.err).Send()
+			errs = append(errs, &PullError{Repo: res.repo.Name, Err: res.err})
+		}
+	}
 
-				// If the DB was not present at startup, that means it's
-				// empty. In this case, we need to update the DB fully
-				// rather than just incrementally.
-				if db.IsEmpty(ctx) {
-					err = processRepoFull(ctx, repo, repoDir)
-					if err != nil {
-						return err
-					}
-				} else {
-					err = processRepoChanges(ctx, repo, r, w, old, new)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		} else {
-			err = os.RemoveAll(repoDir)
-			if err != nil {
-				return err
-			}
+	return errors.Join(errs...)
+}
 
-			err = os.MkdirAll(repoDir, 0o755)
-			if err != nil {
-				return err
-			}
+// pullableRepos returns the repos that aren't disabled. If repos
+// is nil, the repos in the LURE config are used instead.
+func pullableRepos(ctx context.Context, repos []types.Repo) []types.Repo {
+	if repos == nil {
+		repos = config.Config(ctx).Repos
+	}
+
+	return slices.DeleteFunc(slices.Clone(repos), func(repo types.Repo) bool {
+		return repo.Disabled
+	})
+}
+
+// PullError is returned by Pull for each repo that failed to pull
+type PullError struct {
+	Repo string
+	Err  error
+}
+
+func (pe *PullError) Error() string {
+	return pe.Repo + ": " + pe.Err.Error()
+}
+
+func (pe *PullError) Unwrap() error {
+	return pe.Err
+}
+
+// pullRepo fetches a single repo and checks its lure-repo.toml file.
+// It returns the function that writes the repo's packages to the DB.
+func pullRepo(ctx context.Context, repo types.Repo, full bool) (indexFunc, error) {
+	log := loggerctx.From(ctx)
+
+	b, err := backendFor(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Pulling repository").Str("name", repo.Name).Send()
 
-			_, err = git.PlainCloneContext(ctx, repoDir, false, &git.CloneOptions{
-				URL:      repoURL.String(),
-				Progress: os.Stderr,
-			})
+	index, err := b.Update(ctx, repo, full)
+	if err != nil {
+		return index, err
+	}
+
+	repoCfg, err := ReadRepoConfig(b.Dir(ctx, repo))
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Warn("Repository does not appear to be a valid LURE repo").Str("repo", repo.Name).Send()
+		return index, nil
+	} else if err != nil {
+		return index, err
+	}
+
+	// If this version of LURE can't handle the repo, its packages are
+	// removed from the DB rather than indexed, and it's marked as never
+	// pulled, so that it's indexed fully once LURE has been updated.
+	err = CheckRequirements(repoCfg)
+	if err != nil {
+		return func(ctx context.Context) error {
+			err := db.DeletePkgs(ctx, db.InRepo(repo.Name))
 			if err != nil {
 				return err
 			}
 
-			err = processRepoFull(ctx, repo, repoDir)
+			err = db.DeleteIndexErrors(ctx, repo.Name)
 			if err != nil {
 				return err
 			}
+			return db.DeleteRefreshTime(ctx, repo.Name)
+		}, err
+	}
 
-			repoFS = osfs.New(repoDir)
-		}
-
-		fl, err := repoFS.Open("lure-repo.toml")
-		if err != nil {
-			log.Warn("Git repository does not appear to be a valid LURE repo").Str("repo", repo.Name).Send()
-			continue
-		}
-
-		var repoCfg types.RepoConfig
-		err = toml.NewDecoder(fl).Decode(&repoCfg)
-		if err != nil {
-			return err
-		}
-		fl.Close()
-
-		// If the version doesn't have a "v" prefix, it's not a standard version.
-		// It may be "unknown" or a git version, but either way, there's no way
-		// to compare it to the repo version, so only compare versions with the "v".
-		if strings.HasPrefix(config.Version, "v") {
-			if vercmp.Compare(config.Version, repoCfg.Repo.MinVersion) == -1 {
-				log.Warn("LURE repo's minumum LURE version is greater than the current version. Try updating LURE if something doesn't work.").Str("repo", repo.Name).Send()
-			}
+	// If the version doesn't have a "v" prefix, it's not a standard version.
+	// It may be "unknown" or a git version, but either way, there's no way
+	// to compare it to the repo version, so only compare versions with the "v".
+	if strings.HasPrefix(config.Version, "v") {
+		if vercmp.Compare(config.Version, repoCfg.Repo.MinVersion) == -1 {
+			log.Warn("LURE repo's minumum LURE version is greater than the current version. Try updating LURE if something doesn't work.").Str("repo", repo.Name).Send()
 		}
 	}
 
-	return nil
+	return index, nil
 }
 
-type actionType uint8
-
-const (
-	actionDelete actionType = iota
-	actionUpdate
-)
-
-type action struct {
-	Type actionType
-	File string
+// withDBLock runs fn while holding the DB lock
+func withDBLock(ctx context.Context, fn func() error) error {
+	dbLock, err := lock.Exclusive(ctx, lock.DB)
+	if err != nil {
+		return err
+	}
+	defer dbLock.Release()
+	return fn()
 }
 
 func processRepoChanges(ctx context.Context, repo types.Repo, r *git.Repository, w *git.Worktree, old, new *plumbing.Reference) error {
@@ -197,207 +250,392 @@ func processRepoChanges(ctx context.Context, repo types.Repo, r *git.Repository,
 		return err
 	}
 
-	var actions []action
-	for _, fp := range patch.FilePatches() {
-		from, to := fp.Files()
+	repoDir := w.Filesystem.Root()
 
-		if !isValid(from, to) {
-			continue
-		}
+	ix, err := newIndexer(ctx, repo, repoDir)
+	if err != nil {
+		return err
+	}
 
-		if to == nil {
-			actions = append(actions, action{
-				Type: actionDelete,
-				File: from.Path(),
-			})
-		} else if from == nil {
-			actions = append(actions, action{
-				Type: actionUpdate,
-				File: to.Path(),
-			})
-		} else {
-			if from.Path() != to.Path() {
-				actions = append(actions,
-					action{
-						Type: actionDelete,
-						File: from.Path(),
-					},
-					action{
-						Type: actionUpdate,
-						File: to.Path(),
-					},
-				)
-			} else {
-				actions = append(actions, action{
-					Type: actionUpdate,
-					File: to.Path(),
-				})
-			}
-		}
+	// If the layout changed, the build scripts may have moved
+	// to completely different paths, so the repo has to be
+	// indexed again from scratch.
+	newLayout, err := ix.layout()
+	if err != nil {
+		return err
 	}
 
-	repoDir := w.Filesystem.Root()
-	parser := syntax.NewParser()
-
-	for _, action := range actions {
-		env := append(os.Environ(), "scriptdir="+filepath.Dir(filepath.Join(repoDir, action.File)))
-		runner, err := interp.New(
-			interp.Env(expand.ListEnviron(env...)),
-			interp.ExecHandler(handlers.NopExec),
-			interp.ReadDirHandler(handlers.RestrictedReadDir(repoDir)),
-			interp.StatHandler(handlers.RestrictedStat(repoDir)),
-			interp.OpenHandler(handlers.RestrictedOpen(repoDir)),
-			interp.StdIO(handlers.NopRWC{}, handlers.NopRWC{}, handlers.NopRWC{}),
-		)
-		if err != nil {
-			return err
-		}
+	oldLayout, err := commitLayout(oldCommit)
+	if err != nil || oldLayout != newLayout {
+		return reindexRepo(ctx, repo, repoDir)
+	}
 
-		switch action.Type {
-		case actionDelete:
-			if filepath.Base(action.File) != "lure.sh" {
+	// Find the build scripts of all the packages that contain changed
+	// files, or that accessed them the last time they were indexed.
+	// Renamed files affect both the package they were moved from and
+	// the one they were moved to.
+	var scripts []string
+	seen := map[string]bool{}
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		for _, f := range []diff.File{from, to} {
+			if f == nil {
 				continue
 			}
 
-			scriptFl, err := oldCommit.File(action.File)
-			if err != nil {
-				return nil
+			// The repo's defaults and policy apply to all of its packages
+			if f.Path() == "lure-repo.toml" {
+				return reindexRepo(ctx, repo, repoDir)
 			}
 
-			r, err := scriptFl.Reader()
-			if err != nil {
-				return nil
-			}
+			affected := newLayout.scriptsFor(f.Path())
 
-			var pkg db.Package
-			err = parseScript(ctx, parser, runner, r, &pkg)
+			users, err := db.GetPkgs(ctx, db.Query{Where: db.And(db.InRepo(repo.Name), db.UsesFile(f.Path()))})
 			if err != nil {
 				return err
 			}
-
-			err = db.DeletePkgs(ctx, "name = ? AND repository = ?", pkg.Name, repo.Name)
-			if err != nil {
-				return err
-			}
-		case actionUpdate:
-			if filepath.Base(action.File) != "lure.sh" {
-				action.File = filepath.Join(filepath.Dir(action.File), "lure.sh")
+			for _, pkg := range users {
+				affected = append(affected, pkg.Path)
 			}
 
-			scriptFl, err := newCommit.File(action.File)
-			if err != nil {
-				return nil
+			for _, script := range affected {
+				if !seen[script] {
+					seen[script] = true
+					scripts = append(scripts, script)
+				}
 			}
+		}
+	}
 
-			r, err := scriptFl.Reader()
+	for _, script := range scripts {
+		scriptFl, err := newCommit.File(script)
+		if errors.Is(err, object.ErrFileNotFound) {
+			// The build script was deleted or moved elsewhere
+			err = db.DeletePkgs(ctx, db.And(db.InRepo(repo.Name), db.ByPath(script)))
 			if err != nil {
-				return nil
-			}
-
-			pkg := db.Package{
-				Description:  db.NewJSON(map[string]string{}),
-				Homepage:     db.NewJSON(map[string]string{}),
-				Maintainer:   db.NewJSON(map[string]string{}),
-				Depends:      db.NewJSON(map[string][]string{}),
-				BuildDepends: db.NewJSON(map[string][]string{}),
-				Repository:   repo.Name,
+				return err
 			}
 
-			err = parseScript(ctx, parser, runner, r, &pkg)
+			err = db.DeleteIndexError(ctx, repo.Name, script)
 			if err != nil {
 				return err
 			}
+			continue
+		} else if err != nil {
+			return err
+		}
 
-			resolveOverrides(runner, &pkg)
+		r, err := scriptFl.Reader()
+		if err != nil {
+			return err
+		}
 
-			err = db.InsertPackage(ctx, pkg)
-			if err != nil {
-				return err
-			}
+		_, err = ix.index(ctx, filepath.Join(repoDir, script), r)
+		if err != nil {
+			return err
 		}
 	}
 
+	ix.summarize(ctx)
 	return nil
 }
 
-// isValid makes sure the path of the file being updated is valid.
-// It checks to make sure the file is not within a nested directory
-// and that it is called lure.sh.
-func isValid(from, to diff.File) bool {
-	var path string
-	if from != nil {
-		path = from.Path()
+// reindexRepo removes all the packages of a repo
+// from the DB, and then indexes all of them again
+func reindexRepo(ctx context.Context, repo types.Repo, repoDir string) error {
+	err := db.DeletePkgs(ctx, db.InRepo(repo.Name))
+	if err != nil {
+		return err
+	}
+	return processRepoFull(ctx, repo, repoDir)
+}
+
+// commitLayout returns the layout of a git repo at the given commit
+func commitLayout(c *object.Commit) (layout, error) {
+	fl, err := c.File("lure-repo.toml")
+	if errors.Is(err, object.ErrFileNotFound) {
+		return defaultLayout, nil
+	} else if err != nil {
+		return layout{}, err
+	}
+
+	r, err := fl.Reader()
+	if err != nil {
+		return layout{}, err
 	}
-	if to != nil {
-		path = to.Path()
+	defer r.Close()
+
+	cfg, err := decodeRepoConfig(r)
+	if err != nil {
+		return layout{}, err
 	}
 
-	match, _ := filepath.Match("*/*.sh", path)
-	return match
+	return parseLayout(cfg.Repo.Layout)
 }
 
+// processRepoFull indexes all the build scripts in the repo
 func processRepoFull(ctx context.Context, repo types.Repo, repoDir string) error {
-	glob := filepath.Join(repoDir, "/*/lure.sh")
-	matches, err := filepath.Glob(glob)
+	ix, err := newIndexer(ctx, repo, repoDir)
 	if err != nil {
 		return err
 	}
 
-	parser := syntax.NewParser()
+	l, err := ix.layout()
+	if err != nil {
+		return err
+	}
+
+	matches, err := l.findScripts(repoDir)
+	if err != nil {
+		return err
+	}
+
+	// Every script is indexed again, so errors
+	// from scripts that no longer exist are cleared
+	err = db.DeleteIndexErrors(ctx, repo.Name)
+	if err != nil {
+		return err
+	}
 
 	for _, match := range matches {
-		env := append(os.Environ(), "scriptdir="+filepath.Dir(match))
-		runner, err := interp.New(
-			interp.Env(expand.ListEnviron(env...)),
-			interp.ExecHandler(handlers.NopExec),
-			interp.ReadDirHandler(handlers.RestrictedReadDir(repoDir)),
-			interp.StatHandler(handlers.RestrictedStat(repoDir)),
-			interp.OpenHandler(handlers.RestrictedOpen(repoDir)),
-			interp.StdIO(handlers.NopRWC{}, handlers.NopRWC{}, handlers.NopRWC{}),
-		)
+		scriptFl, err := os.Open(match)
 		if err != nil {
 			return err
 		}
 
-		scriptFl, err := os.Open(match)
+		_, err = ix.index(ctx, match, scriptFl)
 		if err != nil {
 			return err
 		}
+	}
 
-		pkg := db.Package{
-			Description:  db.NewJSON(map[string]string{}),
-			Homepage:     db.NewJSON(map[string]string{}),
-			Maintainer:   db.NewJSON(map[string]string{}),
-			Depends:      db.NewJSON(map[string][]string{}),
-			BuildDepends: db.NewJSON(map[string][]string{}),
-			Repository:   repo.Name,
-		}
+	ix.summarize(ctx)
+	return nil
+}
 
-		err = parseScript(ctx, parser, runner, scriptFl, &pkg)
-		if err != nil {
-			return err
+// newRunner creates a shell runner that can only access files within
+// allowedDirs, for running the script at scriptPath. Scripts run by the
+// runner have to be run using budget, which enforces their limits.
+func newRunner(scriptPath string, rec *fileRecorder, budget *limits.Budget, allowedDirs ...string) (*interp.Runner, error) {
+	env := append(os.Environ(), "scriptdir="+filepath.Dir(scriptPath))
+	return interp.New(
+		interp.Env(expand.ListEnviron(env...)),
+		interp.CallHandler(budget.CallHandler),
+		interp.ExecHandler(handlers.NopExec),
+		interp.ReadDirHandler(rec.readDir(handlers.RestrictedReadDir(allowedDirs...))),
+		interp.StatHandler(rec.stat(handlers.RestrictedStat(allowedDirs...))),
+		interp.OpenHandler(rec.open(handlers.RestrictedOpen(allowedDirs...))),
+		interp.StdIO(handlers.NopRWC{}, handlers.NopRWC{}, handlers.NopRWC{}),
+	)
+}
+
+// indexer parses the build scripts of a repo, applying
+// the settings from the repo's lure-repo.toml file
+type indexer struct {
+	parser  *syntax.Parser
+	repo    types.Repo
+	repoDir string
+	cfg     types.RepoConfig
+	prelude *syntax.File
+	limits  limits.Limits
+	// commit is the commit a git repo is checked out at
+	commit string
+	// errors is the amount of build scripts that couldn't be indexed
+	errors int
+}
+
+// newIndexer creates an indexer for the repo in repoDir
+func newIndexer(ctx context.Context, repo types.Repo, repoDir string) (*indexer, error) {
+	cfg, err := ReadRepoConfig(repoDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	prelude, err := Prelude(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ix := &indexer{
+		parser:  syntax.NewParser(),
+		repo:    repo,
+		repoDir: repoDir,
+		cfg:     cfg,
+		prelude: prelude,
+		limits:  limits.FromConfig(ctx, config.Config(ctx).Limits),
+	}
+
+	if _, ok := mustBackend(repo).(gitBackend); ok {
+		if r, err := git.PlainOpen(repoDir); err == nil {
+			if head, err := r.Head(); err == nil {
+				ix.commit = head.Hash().String()
+			}
 		}
+	}
+
+	return ix, nil
+}
+
+// layout returns the layout of the repo
+func (ix *indexer) layout() (layout, error) {
+	return parseLayout(ix.cfg.Repo.Layout)
+}
 
-		resolveOverrides(runner, &pkg)
+// index parses the build script at scriptPath, whose contents are read
+// from r, and writes the package it describes to the DB. If the package
+// violates the repo's policy, it's left out of the DB and a warning is
+// logged instead. If the script can't be parsed, its package is removed
+// from the DB and the error is recorded rather than returned, so that one
+// broken build script doesn't stop the rest of the repo from being indexed.
+// In that case, the returned package is empty.
+func (ix *indexer) index(ctx context.Context, scriptPath string, r io.ReadCloser) (db.Package, error) {
+	relPath, err := filepath.Rel(ix.repoDir, scriptPath)
+	if err != nil {
+		r.Close()
+		return db.Package{}, err
+	}
+	relPath = filepath.ToSlash(relPath)
 
-		err = db.InsertPackage(ctx, pkg)
+	pkg, err := ix.parse(ctx, scriptPath, r)
+	if errors.Is(err, ErrPolicyViolation) {
+		loggerctx.From(ctx).Warn("Skipping package").Str("repo", ix.repo.Name).Str("name", pkg.Name).Err(err).Send()
+		err = db.DeleteIndexError(ctx, ix.repo.Name, relPath)
 		if err != nil {
-			return err
+			return db.Package{}, err
 		}
+		return pkg, db.DeletePkgs(ctx, db.And(db.InRepo(ix.repo.Name), db.ByPath(pkg.Path)))
+	} else if err != nil {
+		return db.Package{}, ix.recordError(ctx, relPath, err)
 	}
 
-	return nil
+	err = db.DeleteIndexError(ctx, ix.repo.Name, relPath)
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	// If the package was renamed, the old one has to be removed
+	err = db.DeletePkgs(ctx, db.And(db.InRepo(ix.repo.Name), db.ByPath(pkg.Path), db.Not(db.ByName(pkg.Name))))
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	return pkg, db.InsertPackage(ctx, pkg)
 }
 
-func parseScript(ctx context.Context, parser *syntax.Parser, runner *interp.Runner, r io.ReadCloser, pkg *db.Package) error {
-	defer r.Close()
-	fl, err := parser.Parse(r, "lure.sh")
+// recordError removes the package built by the script at relPath
+// from the DB, and records the error that occurred while indexing it
+func (ix *indexer) recordError(ctx context.Context, relPath string, indexErr error) error {
+	loggerctx.From(ctx).Warn("Error indexing package").Str("repo", ix.repo.Name).Str("path", relPath).Err(indexErr).Send()
+	ix.errors++
+
+	err := db.DeletePkgs(ctx, db.And(db.InRepo(ix.repo.Name), db.ByPath(relPath)))
 	if err != nil {
 		return err
 	}
 
+	return db.SetIndexError(ctx, db.IndexError{
+		Repository: ix.repo.Name,
+		Path:       relPath,
+		Error:      indexErr.Error(),
+		Commit:     ix.commit,
+	})
+}
+
+// summarize logs how many build scripts couldn't be indexed, if any
+func (ix *indexer) summarize(ctx context.Context) {
+	if ix.errors > 0 {
+		loggerctx.From(ctx).Warn("Some packages could not be indexed. Run lure repo doctor for details.").
+			Str("repo", ix.repo.Name).
+			Int("errors", ix.errors).
+			Send()
+	}
+}
+
+// parse parses the build script at scriptPath, whose contents are read
+// from r, and returns the package it describes, with its local overlay
+// applied if it has one. If the package violates the repo's policy,
+// it's returned along with an error wrapping ErrPolicyViolation.
+func (ix *indexer) parse(ctx context.Context, scriptPath string, r io.ReadCloser) (db.Package, error) {
+	relPath, err := filepath.Rel(ix.repoDir, scriptPath)
+	if err != nil {
+		r.Close()
+		return db.Package{}, err
+	}
+
+	fl, err := ix.parser.Parse(r, "lure.sh")
+	r.Close()
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	overlayDir := filepath.Join(config.GetPaths(ctx).OverlayDir, ix.repo.Name)
+	rec := newFileRecorder(ix.repoDir)
+	budget := limits.New(ix.limits)
+	runner, err := newRunner(scriptPath, rec, budget, ix.repoDir, overlayDir)
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	pkg := ix.newPackage(relPath)
+	err = runScript(ctx, runner, budget, WithPrelude(ix.prelude, fl), &pkg)
+	if err != nil {
+		return db.Package{}, err
+	}
+
+	// Overlays are found using the name of the package, so the original
+	// script has to run first. Repos that aren't configured, such as the
+	// ones being published, don't have overlays.
+	ov, ok, err := FindOverlay(ctx, ix.repo.Name, pkg.Name)
+	if err != nil {
+		return db.Package{}, err
+	} else if ok {
+		ovFl, err := ov.Apply(fl)
+		if err != nil {
+			return db.Package{}, err
+		}
+
+		name := pkg.Name
+		pkg = ix.newPackage(relPath)
+		err = runScript(ctx, runner, budget, WithPrelude(ix.prelude, ovFl), &pkg)
+		if err != nil {
+			return db.Package{}, fmt.Errorf("overlay %s: %w", ov.Dir, err)
+		}
+
+		if pkg.Name != name {
+			return db.Package{}, fmt.Errorf("overlay %s: overlays can't change the name of a package", ov.Dir)
+		}
+
+		pkg.Overlay, err = ov.Hash()
+		if err != nil {
+			return db.Package{}, err
+		}
+	}
+
+	resolveOverrides(runner, &pkg)
+	pkg.Files = db.NewJSON(rec.list(scriptPath))
+	return pkg, CheckPolicy(ix.cfg, pkg)
+}
+
+// newPackage returns an empty package from the
+// repo, whose build script is located at relPath
+func (ix *indexer) newPackage(relPath string) db.Package {
+	return db.Package{
+		Description:  db.NewJSON(map[string]string{}),
+		Homepage:     db.NewJSON(map[string]string{}),
+		Maintainer:   db.NewJSON(map[string]string{}),
+		Depends:      db.NewJSON(map[string][]string{}),
+		BuildDepends: db.NewJSON(map[string][]string{}),
+		OptDepends:   db.NewJSON(map[string][]string{}),
+		Repository:   ix.repo.Name,
+		Path:         filepath.ToSlash(relPath),
+	}
+}
+
+// runScript runs a build script within the limits of budget
+// and decodes the package it describes
+func runScript(ctx context.Context, runner *interp.Runner, budget *limits.Budget, fl *syntax.File, pkg *db.Package) error {
 	runner.Reset()
-	err = runner.Run(ctx, fl)
+	err := budget.Run(ctx, runner, fl)
 	if err != nil {
 		return err
 	}
@@ -411,6 +649,7 @@ func parseScript(ctx context.Context, parser *syntax.Parser, runner *interp.Runn
 var overridable = map[string]string{
 	"deps":       "Depends",
 	"build_deps": "BuildDepends",
+	"opt_deps":   "OptDepends",
 	"desc":       "Description",
 	"homepage":   "Homepage",
 	"maintainer": "Maintainer",
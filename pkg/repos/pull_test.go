@@ -23,87 +23,137 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/repos"
 )
 
-func setCfgDirs(t *testing.T) {
-	t.Helper()
-
-	paths := config.GetPaths()
-
-	var err error
-	paths.CacheDir, err = os.MkdirTemp("/tmp", "lure-pull-test.*")
+// TestMain points LURE's config and cache directories at a temporary
+// directory, so that nothing is written to the user's directories
+// before setupTest replaces the paths.
+func TestMain(m *testing.M) {
+	tmp, err := os.MkdirTemp("", "lure-repos-test.*")
 	if err != nil {
-		t.Fatalf("Expected no error, got %s", err)
+		panic(err)
 	}
 
-	paths.RepoDir = filepath.Join(paths.CacheDir, "repo")
-	paths.PkgsDir = filepath.Join(paths.CacheDir, "pkgs")
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
 
-	err = os.MkdirAll(paths.RepoDir, 0o755)
+	code := m.Run()
+	os.RemoveAll(tmp)
+	os.Exit(code)
+}
+
+// newTestRepo creates a git repo containing the itd-bin and itd-git
+// packages, and sets it as the only repo in the LURE config
+func newTestRepo(ctx context.Context, t *testing.T, name, dir string) {
+	t.Helper()
+
+	r, err := git.PlainInit(dir, false)
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
 
-	err = os.MkdirAll(paths.PkgsDir, 0o755)
-	if err != nil {
-		t.Fatalf("Expected no error, got %s", err)
+	for _, name := range []string{"itd-bin", "itd-git"} {
+		commitFile(t, r, name+"/lure.sh", "name="+name+"\nversion=1.0.0\nrelease=1\narchitectures=(all)\nprovides=(itd)\n")
 	}
 
-	paths.DBPath = filepath.Join(paths.CacheDir, "db")
+	config.Config(ctx).Repos = []types.Repo{{Name: name, URL: dir}}
 }
 
-func removeCacheDir(t *testing.T) {
+// commitFile writes a file to the worktree of a git
+// repo and commits it, returning the commit's hash
+func commitFile(t *testing.T, r *git.Repository, file, content string) string {
 	t.Helper()
 
-	err := os.RemoveAll(config.GetPaths().CacheDir)
+	wt, err := r.Worktree()
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
-}
 
-func TestPull(t *testing.T) {
-	_, err := db.Open(":memory:")
+	path := filepath.Join(wt.Filesystem.Root(), filepath.FromSlash(file))
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
-	defer db.Close()
 
-	setCfgDirs(t)
-	defer removeCacheDir(t)
-
-	ctx := context.Background()
+	err = os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
 
-	err = repos.Pull(ctx, []types.Repo{
-		{
-			Name: "default",
-			URL:  "https://github.com/Arsen6331/lure-repo.git",
-		},
-	})
+	_, err = wt.Add(file)
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
 
-	result, err := db.GetPkgs("name LIKE 'itd%'")
+	sig := &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	hash, err := wt.Commit("Update "+file, &git.CommitOptions{Author: sig, Committer: sig})
 	if err != nil {
 		t.Fatalf("Expected no error, got %s", err)
 	}
+	return hash.String()
+}
+
+// setupTest gives the test its own config and cache directories and
+// an empty repo list, and returns the temporary directory they're in.
+// The paths are only generated once per process, so they're replaced
+// here rather than through the environment, and the database is closed
+// so that it's opened again at the new path.
+func setupTest(ctx context.Context, t *testing.T) string {
+	t.Helper()
+
+	tmp := t.TempDir()
+	db.Close()
+	t.Cleanup(func() { db.Close() })
+
+	configDir := filepath.Join(tmp, "config", "lure")
+	cacheDir := filepath.Join(tmp, "cache", "lure")
+	*config.GetPaths(ctx) = config.Paths{
+		ConfigDir:  configDir,
+		ConfigPath: filepath.Join(configDir, "lure.toml"),
+		OverlayDir: filepath.Join(configDir, "overlays"),
+		CacheDir:   cacheDir,
+		RepoDir:    filepath.Join(cacheDir, "repo"),
+		PkgsDir:    filepath.Join(cacheDir, "pkgs"),
+		LockDir:    filepath.Join(cacheDir, "locks"),
+		DBPath:     filepath.Join(cacheDir, "db"),
+	}
 
-	var pkgAmt int
-	for result.Next() {
-		var dbPkg db.Package
-		err = result.StructScan(&dbPkg)
+	for _, dir := range []string{configDir, config.GetPaths(ctx).RepoDir, config.GetPaths(ctx).PkgsDir} {
+		err := os.MkdirAll(dir, 0o755)
 		if err != nil {
-			t.Errorf("Expected no error, got %s", err)
+			t.Fatalf("Expected no error, got %s", err)
 		}
-		pkgAmt++
 	}
 
-	if pkgAmt < 2 {
-		t.Errorf("Expected 2 packages to match, got %d", pkgAmt)
+	config.Config(ctx).Repos = nil
+	return tmp
+}
+
+func TestPull(t *testing.T) {
+	ctx := context.Background()
+	tmp := setupTest(ctx, t)
+
+	newTestRepo(ctx, t, "pull", filepath.Join(tmp, "repo"))
+
+	err := repos.Pull(ctx, config.Config(ctx).Repos)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	result, err := db.GetPkgs(ctx, db.Query{Where: db.And(db.NameLike("itd%"), db.InRepo("pull"))})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if len(result) < 2 {
+		t.Errorf("Expected 2 packages to match, got %d", len(result))
 	}
 }
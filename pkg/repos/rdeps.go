@@ -0,0 +1,61 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+)
+
+// ReverseDeps returns the dependency index entries of all the packages
+// that depend on the given package name. If the name matches any LURE
+// packages, anything depending on a name those packages provide is
+// included as well.
+func ReverseDeps(ctx context.Context, pkgName string) ([]db.Dependency, error) {
+	names := []string{pkgName}
+
+	found, _, err := FindPkgs(ctx, []string{pkgName})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range found[pkgName] {
+		names = append(names, pkg.Name)
+		names = append(names, pkg.Provides.Val...)
+	}
+
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	deps, err := db.GetReverseDeps(ctx, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	// A package providing its own name shouldn't be listed
+	// as depending on itself
+	cfg := config.Config(ctx)
+	return slices.DeleteFunc(deps, func(dep db.Dependency) bool {
+		_, repo := findRepo(cfg, dep.Repository)
+		return dep.Name == pkgName || repo.Disabled
+	}), nil
+}
@@ -0,0 +1,131 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/loggerctx"
+)
+
+// Offline disables pulling repos in AutoPull. It's set
+// by the --offline flag.
+var Offline bool
+
+// refreshPolicy controls how often AutoPull pulls repos.
+// If manual is false and ttl is zero, repos are always pulled.
+type refreshPolicy struct {
+	manual bool
+	ttl    time.Duration
+}
+
+// parseRefreshPolicy parses the refresh setting from the LURE config,
+// which may be "always", "manual", or a duration such as "1h".
+func parseRefreshPolicy(s string) (refreshPolicy, error) {
+	switch s {
+	case "", "always":
+		return refreshPolicy{}, nil
+	case "manual":
+		return refreshPolicy{manual: true}, nil
+	default:
+		ttl, err := time.ParseDuration(s)
+		if err != nil {
+			return refreshPolicy{}, err
+		}
+		return refreshPolicy{ttl: ttl}, nil
+	}
+}
+
+// AutoPull pulls the repos in the LURE config that need to be refreshed
+// according to the configured refresh policy. Repos that were never pulled
+// are always pulled, unless Offline is set. If a repo that was pulled before
+// fails to pull, a warning is logged and its existing data is used instead,
+// so that LURE can still be used without a network connection.
+func AutoPull(ctx context.Context) error {
+	log := loggerctx.From(ctx)
+	cfg := config.Config(ctx)
+
+	policy, err := parseRefreshPolicy(cfg.Refresh)
+	if err != nil {
+		log.Warn("Invalid refresh policy, refreshing repositories every time").Str("refresh", cfg.Refresh).Err(err).Send()
+	}
+
+	times, err := db.GetRefreshTimes(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var toPull []types.Repo
+	for _, repo := range cfg.Repos {
+		if repo.Disabled {
+			continue
+		}
+
+		last, ok := times[repo.Name]
+		age := now.Sub(last)
+
+		switch {
+		case Offline && !ok:
+			log.Warn("Repository has never been pulled, but offline mode is enabled").Str("name", repo.Name).Send()
+		case Offline:
+			if policy.ttl > 0 && age > policy.ttl {
+				log.Warn("Repository data is out of date").Str("name", repo.Name).Stringer("age", age.Round(time.Second)).Send()
+			}
+		case !ok:
+			toPull = append(toPull, repo)
+		case policy.manual:
+		case age >= policy.ttl:
+			toPull = append(toPull, repo)
+		}
+	}
+
+	if len(toPull) == 0 {
+		return nil
+	}
+
+	err = Pull(ctx, toPull)
+	if err == nil {
+		return nil
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return err
+	}
+
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		var pe *PullError
+		if errors.As(err, &pe) {
+			if last, ok := times[pe.Repo]; ok {
+				log.Warn("Unable to refresh repository, using existing data").Str("name", pe.Repo).Stringer("age", now.Sub(last).Round(time.Second)).Send()
+				continue
+			}
+		}
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,216 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/shutils/helpers"
+	"lure.sh/lure/internal/types"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Features contains the optional LURE features that repos
+// can list in the requiredFeatures field of lure-repo.toml
+var Features = []string{
+	"nested-layout",
+	"repo-defaults",
+	"repo-policy",
+	"http-repos",
+	"signed-commits",
+}
+
+// ErrPolicyViolation is returned when a package
+// doesn't follow the policy of its repo
+var ErrPolicyViolation = errors.New("package violates repository policy")
+
+var varNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ReadRepoConfig reads the lure-repo.toml file of the repo in repoDir.
+// If the repo doesn't have one, an error wrapping fs.ErrNotExist is returned.
+func ReadRepoConfig(repoDir string) (types.RepoConfig, error) {
+	fl, err := os.Open(filepath.Join(repoDir, "lure-repo.toml"))
+	if err != nil {
+		return types.RepoConfig{}, err
+	}
+	defer fl.Close()
+
+	return decodeRepoConfig(fl)
+}
+
+// FindRepoConfig reads the lure-repo.toml file of the repo that contains the
+// build script at scriptPath, by looking for it in each of the script's parent
+// directories. If it can't be found, an empty config is returned.
+func FindRepoConfig(scriptPath string) (types.RepoConfig, error) {
+	dir, err := filepath.Abs(filepath.Dir(scriptPath))
+	if err != nil {
+		return types.RepoConfig{}, err
+	}
+
+	for {
+		cfg, err := ReadRepoConfig(dir)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return cfg, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return types.RepoConfig{}, nil
+		}
+		dir = parent
+	}
+}
+
+func decodeRepoConfig(r io.Reader) (types.RepoConfig, error) {
+	var cfg types.RepoConfig
+	err := toml.NewDecoder(r).Decode(&cfg)
+	if err != nil {
+		return types.RepoConfig{}, fmt.Errorf("lure-repo.toml: %w", err)
+	}
+	return cfg, nil
+}
+
+// CheckRequirements makes sure this version of LURE supports all
+// the features and helper commands required by a repo
+func CheckRequirements(cfg types.RepoConfig) error {
+	var missing []string
+	for _, feature := range cfg.Repo.RequiredFeatures {
+		if !slices.Contains(Features, feature) {
+			missing = append(missing, "feature "+feature)
+		}
+	}
+
+	for _, helper := range cfg.Repo.RequiredHelpers {
+		if _, ok := helpers.Helpers[helper]; !ok {
+			missing = append(missing, "helper "+helper)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("repository requires unsupported %s; try updating LURE", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Prelude returns shell code that sets the default variables of a repo.
+// It's meant to run before each of the repo's build scripts, so that the
+// scripts can override the defaults. If the repo has no defaults, nil is
+// returned.
+func Prelude(cfg types.RepoConfig) (*syntax.File, error) {
+	if len(cfg.Defaults) == 0 {
+		return nil, nil
+	}
+
+	// Sort the names so that the prelude is always the same
+	names := make([]string, 0, len(cfg.Defaults))
+	for name := range cfg.Defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sb := &strings.Builder{}
+	for _, name := range names {
+		if !varNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("lure-repo.toml: invalid default variable name: %q", name)
+		}
+
+		val, err := quoteDefault(cfg.Defaults[name])
+		if err != nil {
+			return nil, fmt.Errorf("lure-repo.toml: default variable %s: %w", name, err)
+		}
+
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(val)
+		sb.WriteByte('\n')
+	}
+
+	return syntax.NewParser().Parse(strings.NewReader(sb.String()), "lure-repo.toml")
+}
+
+// quoteDefault converts the value of a default variable to shell code.
+// Strings and numbers become regular variables, and arrays become arrays.
+func quoteDefault(val any) (string, error) {
+	switch val := val.(type) {
+	case string:
+		return syntax.Quote(val, syntax.LangBash)
+	case int64, float64, bool:
+		return syntax.Quote(fmt.Sprint(val), syntax.LangBash)
+	case []any:
+		items := make([]string, len(val))
+		for i, item := range val {
+			str, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("arrays may only contain strings, got %T", item)
+			}
+
+			quoted, err := syntax.Quote(str, syntax.LangBash)
+			if err != nil {
+				return "", err
+			}
+			items[i] = quoted
+		}
+		return "(" + strings.Join(items, " ") + ")", nil
+	default:
+		return "", fmt.Errorf("unsupported value type: %T", val)
+	}
+}
+
+// WithPrelude returns a copy of fl that runs prelude first
+func WithPrelude(prelude, fl *syntax.File) *syntax.File {
+	if prelude == nil {
+		return fl
+	}
+
+	out := *fl
+	out.Stmts = append(slices.Clone(prelude.Stmts), fl.Stmts...)
+	return &out
+}
+
+// CheckPolicy makes sure a package follows the policy of its repo.
+// If it doesn't, an error wrapping ErrPolicyViolation is returned.
+func CheckPolicy(cfg types.RepoConfig, pkg db.Package) error {
+	if len(cfg.Policy.Licenses) > 0 {
+		for _, license := range pkg.Licenses.Val {
+			if !slices.Contains(cfg.Policy.Licenses, license) {
+				return fmt.Errorf("%w: license %s is not allowed", ErrPolicyViolation, license)
+			}
+		}
+	}
+
+	for _, dep := range db.Dependencies(pkg) {
+		if slices.Contains(cfg.Policy.DeniedDeps, dep.Dep) {
+			return fmt.Errorf("%w: dependency on %s is not allowed", ErrPolicyViolation, dep.Dep)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repos_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/repos"
+)
+
+func TestRepoConfig(t *testing.T) {
+	ctx := context.Background()
+
+	tmp := setupTest(ctx, t)
+
+	repoDir := filepath.Join(tmp, "repo")
+	writeFile(t, filepath.Join(repoDir, "lure-repo.toml"), `
+[defaults]
+maintainer = "Jane <jane@example.com>"
+architectures = ["all"]
+
+[policy]
+deniedDeps = ["evil"]
+`)
+	writeFile(t, filepath.Join(repoDir, "foo", "lure.sh"), "name=foo\nversion=1.0.0\nrelease=1\n")
+	writeFile(t, filepath.Join(repoDir, "bar", "lure.sh"), "name=bar\nversion=1.0.0\nrelease=1\narchitectures=(amd64)\n")
+	writeFile(t, filepath.Join(repoDir, "baz", "lure.sh"), "name=baz\nversion=1.0.0\nrelease=1\ndeps=(evil)\n")
+
+	config.Config(ctx).Repos = []types.Repo{{Name: "local", Path: repoDir}}
+
+	err := repos.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	foo, err := db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("local")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if m := foo.Maintainer.Val[""]; m != "Jane <jane@example.com>" {
+		t.Errorf("Expected default maintainer, got %q", m)
+	}
+
+	if a := foo.Architectures.Val; len(a) != 1 || a[0] != "all" {
+		t.Errorf("Expected default architectures, got %v", a)
+	}
+
+	bar, err := db.GetPkg(ctx, db.And(db.ByName("bar"), db.InRepo("local")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if a := bar.Architectures.Val; len(a) != 1 || a[0] != "amd64" {
+		t.Errorf("Expected script to override default architectures, got %v", a)
+	}
+
+	_, err = db.GetPkg(ctx, db.And(db.ByName("baz"), db.InRepo("local")))
+	if err == nil {
+		t.Errorf("Expected package with a denied dependency to be skipped")
+	}
+
+	// Repos that require unsupported features can't be pulled
+	writeFile(t, filepath.Join(repoDir, "lure-repo.toml"), "[repo]\nrequiredFeatures = [\"time-travel\"]\n")
+
+	err = repos.Pull(ctx, nil)
+	if err == nil {
+		t.Fatalf("Expected error for unsupported feature")
+	}
+
+	_, err = db.GetPkg(ctx, db.And(db.ByName("foo"), db.InRepo("local")))
+	if err == nil {
+		t.Errorf("Expected packages of unsupported repo to be removed")
+	}
+}
@@ -6,12 +6,10 @@ import (
 	"io"
 	"io/fs"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
 
-	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/pkg/repos"
 )
 
 // Filter represents search filters.
@@ -87,55 +85,46 @@ type Options struct {
 
 // Search searches for packages in the database based on the given options.
 func Search(ctx context.Context, opts Options) ([]Package, error) {
-	query := "(name LIKE ? OR description LIKE ? OR json_array_contains(provides, ?))"
-	args := []any{"%" + opts.Query + "%", "%" + opts.Query + "%", opts.Query}
-
-	if opts.Filter != FilterNone {
-		switch opts.Filter {
-		case FilterInRepo:
-			query += " AND repository = ?"
-		case FilterSupportsArch:
-			query += " AND json_array_contains(architectures, ?)"
-		}
-		args = append(args, opts.FilterValue)
+	where := db.Or(
+		db.NameLike("%"+opts.Query+"%"),
+		db.DescriptionLike("%"+opts.Query+"%"),
+		db.ByProvides(opts.Query),
+	)
+
+	switch opts.Filter {
+	case FilterInRepo:
+		where = db.And(where, db.InRepo(opts.FilterValue))
+	case FilterSupportsArch:
+		where = db.And(where, db.SupportsArch(opts.FilterValue))
 	}
 
-	if opts.SortBy != SortByNone {
-		switch opts.SortBy {
-		case SortByName:
-			query += " ORDER BY name"
-		case SortByRepo:
-			query += " ORDER BY repository"
-		case SortByVersion:
-			query += " ORDER BY version"
-		}
+	q := db.Query{Where: where, Limit: opts.Limit}
+	switch opts.SortBy {
+	case SortByName:
+		q.SortBy = db.SortByName
+	case SortByRepo:
+		q.SortBy = db.SortByRepo
+	case SortByVersion:
+		q.SortBy = db.SortByVersion
 	}
 
-	if opts.Limit != 0 {
-		query += " LIMIT " + strconv.FormatInt(opts.Limit, 10)
-	}
-
-	result, err := db.GetPkgs(ctx, query, args...)
+	result, err := db.IterPkgs(ctx, q)
 	if err != nil {
 		return nil, err
 	}
+	defer result.Close()
 
 	var out []Package
 	for result.Next() {
-		pkg := db.Package{}
-		err = result.StructScan(&pkg)
-		if err != nil {
-			return nil, err
-		}
-		out = append(out, convertPkg(pkg))
+		out = append(out, convertPkg(result.Package()))
 	}
 
-	return out, err
+	return out, result.Err()
 }
 
 // GetPkg gets a single package from the database and returns it.
 func GetPkg(ctx context.Context, repo, name string) (Package, error) {
-	pkg, err := db.GetPkg(ctx, "name = ? AND repository = ?", name, repo)
+	pkg, err := db.GetPkg(ctx, db.And(db.ByName(name), db.InRepo(repo)))
 	return convertPkg(*pkg), err
 }
 
@@ -155,7 +144,11 @@ func GetScript(ctx context.Context, repo, name string) (io.ReadCloser, error) {
 		return nil, ErrInvalidArgument
 	}
 
-	scriptPath := filepath.Join(config.GetPaths(ctx).RepoDir, repo, name, "lure.sh")
+	scriptPath, err := repos.ScriptPath(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+
 	fl, err := os.Open(scriptPath)
 	if errors.Is(err, fs.ErrNotExist) {
 		return nil, ErrScriptNotFound
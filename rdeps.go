@@ -0,0 +1,209 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slices"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/overrides"
+	"lure.sh/lure/pkg/distro"
+	"lure.sh/lure/pkg/loggerctx"
+	"lure.sh/lure/pkg/manager"
+	"lure.sh/lure/pkg/repos"
+)
+
+var rdepsCmd = &cli.Command{
+	Name:      "rdeps",
+	Usage:     "List the LURE packages that depend on a package",
+	ArgsUsage: "<package>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "installed",
+			Aliases: []string{"I"},
+			Usage:   "Only show packages that are installed on the system",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		args := c.Args()
+		if args.Len() != 1 {
+			log.Fatalf("Command rdeps expected 1 argument, got %d", args.Len()).Send()
+		}
+
+		err := repos.AutoPull(ctx)
+		if err != nil {
+			log.Fatal("Error pulling repositories").Err(err).Send()
+		}
+
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		defer dbLock.Release()
+
+		deps, err := repos.ReverseDeps(ctx, args.First())
+		if err != nil {
+			log.Fatal("Error getting reverse dependencies").Err(err).Send()
+		}
+
+		var installed map[string]string
+		if c.Bool("installed") {
+			mgr := manager.Detect()
+			if mgr == nil {
+				log.Fatal("Unable to detect a supported package manager on the system").Send()
+			}
+
+			installed, err = mgr.ListInstalled(&manager.Opts{AsRoot: false})
+			if err != nil {
+				log.Fatal("Error listing installed packages").Err(err).Send()
+			}
+		}
+
+		sections := []struct {
+			depType string
+			title   string
+		}{
+			{db.DepTypeRuntime, "Runtime dependencies"},
+			{db.DepTypeBuild, "Build dependencies"},
+			{db.DepTypeOptional, "Optional dependencies"},
+		}
+
+		for _, section := range sections {
+			var lines []string
+			for _, dep := range deps {
+				if dep.Type != section.depType {
+					continue
+				}
+
+				if installed != nil {
+					if _, ok := installed[dep.Name]; !ok {
+						continue
+					}
+				}
+
+				line := dep.Repository + "/" + dep.Name
+				if dep.Override != "" {
+					line += " (" + dep.Override + ")"
+				}
+				lines = append(lines, line)
+			}
+
+			if len(lines) == 0 {
+				continue
+			}
+
+			fmt.Println(section.title + ":")
+			for _, line := range lines {
+				fmt.Println("  " + line)
+			}
+		}
+
+		return nil
+	},
+}
+
+// warnDependents logs a warning for each of the given packages that installed
+// LURE packages still depend on, and returns true if there were any. Since
+// this check shouldn't stop the packages from being removed, a failure only
+// skips the rest of it with a warning.
+func warnDependents(ctx context.Context, mgr manager.Manager, names []string) bool {
+	log := loggerctx.From(ctx)
+
+	installed, err := mgr.ListInstalled(&manager.Opts{AsRoot: false})
+	if err != nil {
+		log.Warn("Unable to list installed packages, skipping the check for packages that are still needed").Err(err).Send()
+		return false
+	}
+
+	needed := false
+	for _, pkgName := range names {
+		dependents, err := installedDependents(ctx, pkgName, installed, names)
+		if err != nil {
+			log.Warn("Unable to get reverse dependencies, skipping the check for packages that are still needed").Str("name", pkgName).Err(err).Send()
+			return needed
+		}
+
+		if len(dependents) > 0 {
+			needed = true
+			log.Warn("Package is still needed by other installed LURE packages").
+				Str("name", pkgName).
+				Str("dependents", strings.Join(dependents, ", ")).
+				Send()
+		}
+	}
+
+	return needed
+}
+
+// installedDependents returns the names of the installed LURE packages
+// that still need pkgName at runtime on this system, ignoring any
+// packages contained in skip.
+func installedDependents(ctx context.Context, pkgName string, installed map[string]string, skip []string) ([]string, error) {
+	deps, err := repos.ReverseDeps(ctx, pkgName)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := distro.ParseOSRelease(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := overrides.Resolve(info, overrides.DefaultOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var out []string
+	for _, dep := range deps {
+		if dep.Type != db.DepTypeRuntime || seen[dep.Repository+"/"+dep.Name] {
+			continue
+		}
+
+		if _, ok := installed[dep.Name]; !ok || slices.Contains(skip, dep.Name) {
+			continue
+		}
+
+		// Only the override in effect on this system matters,
+		// so resolve the dependent package and check its deps
+		pkg, err := db.GetPkg(ctx, db.And(db.ByName(dep.Name), db.InRepo(dep.Repository)))
+		if err != nil {
+			return nil, err
+		}
+
+		resolved := overrides.ResolvePackage(pkg, names)
+		if !slices.Contains(resolved.Depends, dep.Dep) {
+			continue
+		}
+
+		seen[dep.Repository+"/"+dep.Name] = true
+		out = append(out, dep.Repository+"/"+dep.Name)
+	}
+
+	return out, nil
+}
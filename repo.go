@@ -19,17 +19,26 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
 
-	"github.com/pelletier/go-toml/v2"
+	"github.com/AlecAivazis/survey/v2"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/loggerctx"
 	"lure.sh/lure/pkg/repos"
 	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
 )
 
 var addrepoCmd = &cli.Command{
@@ -49,6 +58,16 @@ var addrepoCmd = &cli.Command{
 			Required: true,
 			Usage:    "URL of the new repo",
 		},
+		&cli.StringFlag{
+			Name:    "ref",
+			Aliases: []string{"r"},
+			Usage:   "Branch or tag of the new repo to follow",
+		},
+		&cli.StringFlag{
+			Name:    "commit",
+			Aliases: []string{"C"},
+			Usage:   "Commit of the new repo to pin to",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		ctx := c.Context
@@ -59,28 +78,28 @@ var addrepoCmd = &cli.Command{
 
 		cfg := config.Config(ctx)
 
+		if !repos.ValidName(name) {
+			log.Fatal("Invalid repo name").Str("name", name).Send()
+		}
+
 		for _, repo := range cfg.Repos {
-			if repo.URL == repoURL {
+			if repo.URL == repoURL || repo.Name == name {
 				log.Fatal("Repo already exists").Str("name", repo.Name).Send()
 			}
 		}
 
-		cfg.Repos = append(cfg.Repos, types.Repo{
-			Name: name,
-			URL:  repoURL,
-		})
-
-		cfgFl, err := os.Create(config.GetPaths(ctx).ConfigPath)
-		if err != nil {
-			log.Fatal("Error opening config file").Err(err).Send()
+		newRepo := types.Repo{
+			Name:   name,
+			URL:    repoURL,
+			Ref:    c.String("ref"),
+			Commit: c.String("commit"),
 		}
 
-		err = toml.NewEncoder(cfgFl).Encode(cfg)
-		if err != nil {
-			log.Fatal("Error encoding config").Err(err).Send()
-		}
+		editConfig(ctx, func(e *config.Editor) error {
+			return e.AddRepo(newRepo)
+		})
 
-		err = repos.Pull(ctx, cfg.Repos)
+		err := repos.Pull(ctx, []types.Repo{newRepo})
 		if err != nil {
 			log.Fatal("Error pulling repos").Err(err).Send()
 		}
@@ -106,57 +125,519 @@ var removerepoCmd = &cli.Command{
 		log := loggerctx.From(ctx)
 
 		name := c.String("name")
-		cfg := config.Config(ctx)
+		findConfigRepo(ctx, name)
+
+		editConfig(ctx, func(e *config.Editor) error {
+			return e.RemoveRepo(name)
+		})
+
+		err := repos.Remove(ctx, name)
+		if err != nil {
+			log.Fatal("Error removing repo data").Err(err).Send()
+		}
+
+		return nil
+	},
+}
+
+var refreshCmd = &cli.Command{
+	Name:    "refresh",
+	Usage:   "Pull all repositories that have changed",
+	Aliases: []string{"ref"},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+		err := repos.Pull(ctx, config.Config(ctx).Repos)
+		if err != nil {
+			log.Fatal("Error pulling repos").Err(err).Send()
+		}
+		return nil
+	},
+}
+
+var repoCmd = &cli.Command{
+	Name:  "repo",
+	Usage: "Manage repositories",
+	Subcommands: []*cli.Command{
+		repoListCmd,
+		repoShowCmd,
+		repoEnableCmd,
+		repoDisableCmd,
+		repoRenameCmd,
+		repoSetURLCmd,
+		repoDoctorCmd,
+		repoPublishCmd,
+	},
+}
+
+var repoListCmd = &cli.Command{
+	Name:    "list",
+	Usage:   "List the configured repositories",
+	Aliases: []string{"ls"},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		defer dbLock.Release()
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTYPE\tSOURCE\tREF\tREFRESHED\tPACKAGES\tCOMMIT")
+
+		for _, repo := range config.Config(ctx).Repos {
+			status, err := repos.GetStatus(ctx, repo)
+			if err != nil {
+				log.Fatal("Error getting repository status").Str("repo", repo.Name).Err(err).Send()
+			}
+
+			name := repo.Name
+			if repo.Disabled {
+				name += " (disabled)"
+			}
 
-		found := false
-		index := 0
-		for i, repo := range cfg.Repos {
-			if repo.Name == name {
-				index = i
-				found = true
+			source := repo.URL
+			if repo.Path != "" {
+				source = repo.Path
 			}
+
+			ref := repo.Ref
+			if repo.Commit != "" {
+				ref = repo.Commit
+			}
+
+			refreshed := "never"
+			if !status.LastRefresh.IsZero() {
+				refreshed = time.Since(status.LastRefresh).Round(time.Second).String() + " ago"
+			}
+
+			commit := status.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", name, status.Type, source, valueOr(ref, "-"), refreshed, status.Packages, valueOr(commit, "-"))
 		}
-		if !found {
-			log.Fatal("Repo does not exist").Str("name", name).Send()
+
+		return tw.Flush()
+	},
+}
+
+// repoInfo is the information about a repo shown by lure repo show
+type repoInfo struct {
+	Name             string         `yaml:"name"`
+	URL              string         `yaml:"url,omitempty"`
+	Path             string         `yaml:"path,omitempty"`
+	Description      string         `yaml:"description,omitempty"`
+	Homepage         string         `yaml:"homepage,omitempty"`
+	Maintainers      []string       `yaml:"maintainers,omitempty"`
+	MinVersion       string         `yaml:"minVersion,omitempty"`
+	Layout           string         `yaml:"layout,omitempty"`
+	RequiredFeatures []string       `yaml:"requiredFeatures,omitempty"`
+	RequiredHelpers  []string       `yaml:"requiredHelpers,omitempty"`
+	Defaults         map[string]any `yaml:"defaults,omitempty"`
+	AllowedLicenses  []string       `yaml:"allowedLicenses,omitempty"`
+	DeniedDeps       []string       `yaml:"deniedDeps,omitempty"`
+	Type             string         `yaml:"type"`
+	Ref              string         `yaml:"ref,omitempty"`
+	Commit           string         `yaml:"commit,omitempty"`
+	Disabled         bool           `yaml:"disabled,omitempty"`
+	LastRefresh      *time.Time     `yaml:"lastRefresh,omitempty"`
+	Packages         int            `yaml:"packages"`
+	BrokenPackages   int            `yaml:"brokenPackages,omitempty"`
+}
+
+var repoShowCmd = &cli.Command{
+	Name:      "show",
+	Usage:     "Show information about a repository",
+	ArgsUsage: "<name>",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		if c.Args().Len() != 1 {
+			log.Fatalf("Command show expected 1 argument, got %d", c.Args().Len()).Send()
 		}
+		name := c.Args().First()
 
-		cfg.Repos = slices.Delete(cfg.Repos, index, index+1)
+		repo := findConfigRepo(ctx, name)
 
-		cfgFl, err := os.Create(config.GetPaths(ctx).ConfigPath)
+		err := repos.AutoPull(ctx)
 		if err != nil {
-			log.Fatal("Error opening config file").Err(err).Send()
+			log.Fatal("Error pulling repositories").Err(err).Send()
 		}
 
-		err = toml.NewEncoder(cfgFl).Encode(&cfg)
+		dbLock, err := lock.Shared(ctx, lock.DB)
 		if err != nil {
-			log.Fatal("Error encoding config").Err(err).Send()
+			log.Fatal("Error acquiring lock").Err(err).Send()
 		}
+		defer dbLock.Release()
 
-		err = os.RemoveAll(filepath.Join(config.GetPaths(ctx).RepoDir, name))
+		repoCfg, err := repos.ReadRepoConfig(repos.Dir(ctx, repo))
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Warn("Repository does not appear to be a valid LURE repo").Str("repo", repo.Name).Send()
+		} else if err != nil {
+			log.Fatal("Error reading repository config").Err(err).Send()
+		}
+
+		status, err := repos.GetStatus(ctx, repo)
 		if err != nil {
-			log.Fatal("Error removing repo directory").Err(err).Send()
+			log.Fatal("Error getting repository status").Err(err).Send()
 		}
 
-		err = db.DeletePkgs(ctx, "repository = ?", name)
+		var lastRefresh *time.Time
+		if !status.LastRefresh.IsZero() {
+			lastRefresh = &status.LastRefresh
+		}
+
+		commit := repo.Commit
+		if status.Commit != "" {
+			commit = status.Commit
+		}
+
+		err = yaml.NewEncoder(os.Stdout).Encode(repoInfo{
+			Name:             repo.Name,
+			URL:              repo.URL,
+			Path:             repo.Path,
+			Description:      repoCfg.Repo.Description,
+			Homepage:         repoCfg.Repo.Homepage,
+			Maintainers:      repoCfg.Repo.Maintainers,
+			MinVersion:       repoCfg.Repo.MinVersion,
+			Layout:           repoCfg.Repo.Layout,
+			RequiredFeatures: repoCfg.Repo.RequiredFeatures,
+			RequiredHelpers:  repoCfg.Repo.RequiredHelpers,
+			Defaults:         repoCfg.Defaults,
+			AllowedLicenses:  repoCfg.Policy.Licenses,
+			DeniedDeps:       repoCfg.Policy.DeniedDeps,
+			Type:             status.Type,
+			Ref:              repo.Ref,
+			Commit:           commit,
+			Disabled:         repo.Disabled,
+			LastRefresh:      lastRefresh,
+			Packages:         status.Packages,
+			BrokenPackages:   status.BrokenPackages,
+		})
 		if err != nil {
-			log.Fatal("Error removing packages from database").Err(err).Send()
+			log.Fatal("Error encoding repository information").Err(err).Send()
 		}
 
 		return nil
 	},
 }
 
-var refreshCmd = &cli.Command{
-	Name:    "refresh",
-	Usage:   "Pull all repositories that have changed",
-	Aliases: []string{"ref"},
+var repoEnableCmd = &cli.Command{
+	Name:      "enable",
+	Usage:     "Enable a repository that was disabled",
+	ArgsUsage: "<name>",
 	Action: func(c *cli.Context) error {
 		ctx := c.Context
 		log := loggerctx.From(ctx)
-		err := repos.Pull(ctx, config.Config(ctx).Repos)
+
+		if c.Args().Len() != 1 {
+			log.Fatalf("Command enable expected 1 argument, got %d", c.Args().Len()).Send()
+		}
+		repo := findConfigRepo(ctx, c.Args().First())
+
+		editConfig(ctx, func(e *config.Editor) error {
+			return e.SetRepoValue(repo.Name, "disabled", nil)
+		})
+
+		repo.Disabled = false
+		err := repos.Pull(ctx, []types.Repo{repo})
 		if err != nil {
 			log.Fatal("Error pulling repos").Err(err).Send()
 		}
+
+		return nil
+	},
+}
+
+var repoDisableCmd = &cli.Command{
+	Name:      "disable",
+	Usage:     "Disable a repository without removing it",
+	ArgsUsage: "<name>",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		if c.Args().Len() != 1 {
+			log.Fatalf("Command disable expected 1 argument, got %d", c.Args().Len()).Send()
+		}
+		repo := findConfigRepo(ctx, c.Args().First())
+
+		editConfig(ctx, func(e *config.Editor) error {
+			return e.SetRepoValue(repo.Name, "disabled", true)
+		})
+
+		return nil
+	},
+}
+
+var repoRenameCmd = &cli.Command{
+	Name:      "rename",
+	Usage:     "Rename a repository",
+	ArgsUsage: "<name> <new name>",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		if c.Args().Len() != 2 {
+			log.Fatalf("Command rename expected 2 arguments, got %d", c.Args().Len()).Send()
+		}
+		repo := findConfigRepo(ctx, c.Args().Get(0))
+		newName := c.Args().Get(1)
+
+		if !repos.ValidName(newName) {
+			log.Fatal("Invalid repo name").Str("name", newName).Send()
+		}
+
+		cfg := config.Config(ctx)
+		if slices.ContainsFunc(cfg.Repos, func(r types.Repo) bool { return r.Name == newName }) {
+			log.Fatal("Repo already exists").Str("name", newName).Send()
+		}
+
+		err := repos.Rename(ctx, repo.Name, newName)
+		if err != nil {
+			log.Fatal("Error renaming repo data").Err(err).Send()
+		}
+
+		editConfig(ctx, func(e *config.Editor) error {
+			err := e.SetRepoValue(repo.Name, "name", newName)
+			if err != nil {
+				return err
+			}
+
+			// Pins that refer to the old name would
+			// otherwise stop matching any repo
+			for pkg, pinned := range cfg.Pin {
+				if pinned == repo.Name {
+					err = e.SetValue("pin", pkg, newName)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		})
+
+		return nil
+	},
+}
+
+var repoSetURLCmd = &cli.Command{
+	Name:      "set-url",
+	Usage:     "Change the URL of a repository",
+	ArgsUsage: "<name> <url>",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		if c.Args().Len() != 2 {
+			log.Fatalf("Command set-url expected 2 arguments, got %d", c.Args().Len()).Send()
+		}
+		repo := findConfigRepo(ctx, c.Args().Get(0))
+		if repo.Path != "" {
+			log.Fatal("Local repos don't have a URL").Str("name", repo.Name).Send()
+		}
+		repo.URL = c.Args().Get(1)
+
+		editConfig(ctx, func(e *config.Editor) error {
+			return e.SetRepoValue(repo.Name, "url", repo.URL)
+		})
+
+		// The data from the old URL can't be reused,
+		// so the repo is downloaded again from scratch.
+		err := repos.Remove(ctx, repo.Name)
+		if err != nil {
+			log.Fatal("Error removing repo data").Err(err).Send()
+		}
+
+		if !repo.Disabled {
+			err = repos.Pull(ctx, []types.Repo{repo})
+			if err != nil {
+				log.Fatal("Error pulling repos").Err(err).Send()
+			}
+		}
+
+		return nil
+	},
+}
+
+var repoDoctorCmd = &cli.Command{
+	Name:      "doctor",
+	Usage:     "List the packages that couldn't be indexed, along with their errors",
+	ArgsUsage: "[name]",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		if c.Args().Len() > 1 {
+			log.Fatalf("Command doctor expected at most 1 argument, got %d", c.Args().Len()).Send()
+		}
+
+		name := c.Args().First()
+		if name != "" {
+			findConfigRepo(ctx, name)
+		}
+
+		err := repos.AutoPull(ctx)
+		if err != nil {
+			log.Fatal("Error pulling repositories").Err(err).Send()
+		}
+
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		defer dbLock.Release()
+
+		indexErrs, err := db.GetIndexErrors(ctx, name)
+		if err != nil {
+			log.Fatal("Error getting index errors").Err(err).Send()
+		}
+
+		if len(indexErrs) == 0 {
+			log.Info("All packages were indexed successfully").Send()
+			return nil
+		}
+
+		for _, ie := range indexErrs {
+			commit := ie.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+
+			fmt.Printf("%s/%s", ie.Repository, ie.Path)
+			if commit != "" {
+				fmt.Printf(" (commit %s)", commit)
+			}
+			fmt.Printf(":\n    %s\n", strings.ReplaceAll(ie.Error, "\n", "\n    "))
+		}
+
+		dbLock.Release()
+		log.Fatal("Some packages could not be indexed").Int("count", len(indexErrs)).Send()
+		return nil
+	},
+}
+
+var repoPublishCmd = &cli.Command{
+	Name:  "publish",
+	Usage: "Generate the files of an HTTP repository from a git or local repository",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "repo",
+			Aliases: []string{"r"},
+			Usage:   "Name of a configured repo to publish",
+		},
+		&cli.PathFlag{
+			Name:    "dir",
+			Aliases: []string{"d"},
+			Value:   ".",
+			Usage:   "Directory of the repo to publish, if --repo isn't set",
+		},
+		&cli.PathFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Required: true,
+			Usage:    "Directory to write the HTTP repo's files to",
+		},
+		&cli.PathFlag{
+			Name:    "key",
+			Aliases: []string{"k"},
+			Usage:   "SSH private key to sign the index with",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		srcDir := c.Path("dir")
+		if name := c.String("repo"); name != "" {
+			found := false
+			for _, repo := range config.Config(ctx).Repos {
+				if repo.Name == name {
+					srcDir = repos.Dir(ctx, repo)
+					found = true
+				}
+			}
+			if !found {
+				log.Fatal("Repo does not exist").Str("name", name).Send()
+			}
+		}
+
+		var signer ssh.Signer
+		if keyPath := c.Path("key"); keyPath != "" {
+			data, err := os.ReadFile(keyPath)
+			if err != nil {
+				log.Fatal("Error reading signing key").Err(err).Send()
+			}
+
+			signer, err = ssh.ParsePrivateKey(data)
+			var missingErr *ssh.PassphraseMissingError
+			if errors.As(err, &missingErr) {
+				var passphrase string
+				err = survey.AskOne(&survey.Password{Message: "Passphrase for " + keyPath}, &passphrase)
+				if err != nil {
+					log.Fatal("Error prompting for passphrase").Err(err).Send()
+				}
+				signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+			}
+			if err != nil {
+				log.Fatal("Error parsing signing key").Err(err).Send()
+			}
+		} else {
+			log.Warn("No signing key provided, the index will not be signed").Send()
+		}
+
+		err := repos.Publish(ctx, srcDir, c.Path("output"), signer)
+		if err != nil {
+			log.Fatal("Error publishing repo").Err(err).Send()
+		}
+
+		log.Info("Published repo").Str("output", c.Path("output")).Send()
 		return nil
 	},
 }
+
+// findConfigRepo returns the repo with the given name from the
+// LURE config, exiting with an error if it doesn't exist
+func findConfigRepo(ctx context.Context, name string) types.Repo {
+	i := slices.IndexFunc(config.Config(ctx).Repos, func(repo types.Repo) bool {
+		return repo.Name == name
+	})
+	if i == -1 {
+		loggerctx.From(ctx).Fatal("Repo does not exist").Str("name", name).Send()
+	}
+	return config.Config(ctx).Repos[i]
+}
+
+// editConfig runs fn with an editor for the LURE config
+// file, and then saves the changes it made
+func editConfig(ctx context.Context, fn func(e *config.Editor) error) {
+	log := loggerctx.From(ctx)
+
+	e, err := config.NewEditor(ctx)
+	if err != nil {
+		log.Fatal("Error opening config file").Err(err).Send()
+	}
+
+	err = fn(e)
+	if err != nil {
+		log.Fatal("Error editing config").Err(err).Send()
+	}
+
+	err = e.Save()
+	if err != nil {
+		log.Fatal("Error saving config").Err(err).Send()
+	}
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"lure.sh/lure/internal/config"
 	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
 	"lure.sh/lure/internal/types"
 	"lure.sh/lure/pkg/build"
 	"lure.sh/lure/pkg/distro"
@@ -61,12 +62,18 @@ var upgradeCmd = &cli.Command{
 			log.Fatal("Unable to detect a supported package manager on the system").Send()
 		}
 
-		err = repos.Pull(ctx, config.Config(ctx).Repos)
+		err = repos.AutoPull(ctx)
 		if err != nil {
 			log.Fatal("Error pulling repos").Err(err).Send()
 		}
 
+		dbLock, err := lock.Shared(ctx, lock.DB)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+
 		updates, err := checkForUpdates(ctx, mgr, info)
+		dbLock.Release()
 		if err != nil {
 			log.Fatal("Error checking for updates").Err(err).Send()
 		}
@@ -103,11 +110,20 @@ func checkForUpdates(ctx context.Context, mgr manager.Manager, info *distro.OSRe
 			continue
 		}
 
-		if len(pkgs) > 1 {
-			// Puts the element with the highest version first
-			slices.SortFunc(pkgs, func(a, b db.Package) int {
-				return vercmp.Compare(a.Version, b.Version)
-			})
+		// Packages installed from the history of a repo
+		// stay at their version until they're unpinned
+		if _, ok := config.Config(ctx).PinVersion[pkgName]; ok {
+			continue
+		}
+
+		// FindPkgs also returns packages that provide the name, so prefer
+		// the ones that actually have it. FindPkgs puts the package from
+		// the pinned or highest priority repo first.
+		exact := slices.DeleteFunc(slices.Clone(pkgs), func(p db.Package) bool {
+			return p.Name != pkgName
+		})
+		if len(exact) > 0 {
+			pkgs = exact
 		}
 
 		// First element is the package we want to install
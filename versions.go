@@ -0,0 +1,183 @@
+/*
+ * LURE - Linux User REpository
+ * Copyright (C) 2023 Elara Musayelyan
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"lure.sh/lure/internal/cliutils"
+	"lure.sh/lure/internal/config"
+	"lure.sh/lure/internal/db"
+	"lure.sh/lure/internal/lock"
+	"lure.sh/lure/internal/types"
+	"lure.sh/lure/pkg/build"
+	"lure.sh/lure/pkg/loggerctx"
+	"lure.sh/lure/pkg/repos"
+)
+
+var versionsCmd = &cli.Command{
+	Name:      "versions",
+	Usage:     "List the versions of a package found in the history of its repo",
+	ArgsUsage: "<package>",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		args := c.Args()
+		if args.Len() != 1 {
+			log.Fatalf("Command versions expected 1 argument, got %d", args.Len()).Send()
+		}
+
+		err := repos.AutoPull(ctx)
+		if err != nil {
+			log.Fatal("Error pulling repositories").Err(err).Send()
+		}
+
+		found, _, err := repos.FindPkgs(ctx, args.Slice())
+		if err != nil {
+			log.Fatal("Error finding packages").Err(err).Send()
+		}
+
+		if len(found) == 0 {
+			log.Fatal("Package not found").Str("name", args.First()).Send()
+		}
+
+		pkgs := cliutils.FlattenPkgs(ctx, found, "show", c.Bool("interactive"))
+
+		reposLock, err := lock.Shared(ctx, lock.Repos)
+		if err != nil {
+			log.Fatal("Error acquiring lock").Err(err).Send()
+		}
+		defer reposLock.Release()
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PACKAGE\tVERSION\tCOMMIT\tDATE")
+
+		for _, pkg := range pkgs {
+			versions, err := repos.Versions(ctx, pkg)
+			if err != nil {
+				reposLock.Release()
+				log.Fatal("Error reading repository history").Str("name", pkg.Name).Err(err).Send()
+			}
+
+			for _, hv := range versions {
+				fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\n", pkg.Repository, pkg.Name, hv.FullVersion(), hv.Commit[:12], hv.Time.Format("2006-01-02"))
+			}
+		}
+
+		return tw.Flush()
+	},
+}
+
+var unpinCmd = &cli.Command{
+	Name:      "unpin",
+	Usage:     "Allow packages installed from repository history to be upgraded again",
+	ArgsUsage: "<package...>",
+	Action: func(c *cli.Context) error {
+		ctx := c.Context
+		log := loggerctx.From(ctx)
+
+		args := c.Args()
+		if args.Len() < 1 {
+			log.Fatalf("Command unpin expected at least 1 argument, got %d", args.Len()).Send()
+		}
+
+		for _, name := range args.Slice() {
+			if _, ok := config.Config(ctx).PinVersion[name]; !ok {
+				log.Fatal("Package version is not pinned").Str("name", name).Send()
+			}
+		}
+
+		editConfig(ctx, func(e *config.Editor) error {
+			for _, name := range args.Slice() {
+				err := e.SetValue("pinVersion", name, nil)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		return nil
+	},
+}
+
+// installFromHistory builds and installs the given package as it was at an
+// older commit of its repo, and pins its version so that it isn't upgraded.
+// If rev is empty, the newest commit with the given version is used.
+func installFromHistory(ctx context.Context, pkg db.Package, version, rev string, opts types.BuildOpts) {
+	log := loggerctx.From(ctx)
+
+	var (
+		hv  repos.HistoryVersion
+		err error
+	)
+	if rev != "" {
+		hv, err = repos.VersionAt(ctx, pkg, rev)
+		if err == nil && version != "" && hv.Version != version && hv.FullVersion() != version {
+			err = fmt.Errorf("%s/%s has version %s at commit %s, not %s", pkg.Repository, pkg.Name, hv.FullVersion(), rev, version)
+		}
+	} else {
+		hv, err = repos.FindVersion(ctx, pkg, version)
+	}
+	if err != nil {
+		log.Fatal("Error finding package version").Err(err).Send()
+	}
+
+	log.Info("Installing package from repository history").
+		Str("name", pkg.Name).
+		Str("version", hv.FullVersion()).
+		Str("commit", hv.Commit).
+		Send()
+
+	hctx, cleanup, err := repos.CheckoutCommit(ctx, pkg.Repository, hv.Commit)
+	if err != nil {
+		log.Fatal("Error checking out repository").Err(err).Send()
+	}
+
+	// The package is built here rather than using build.InstallPkgs,
+	// so that the checkout can be removed before exiting on errors.
+	scripts, err := build.GetScriptPaths(hctx, []db.Package{pkg})
+	if err != nil {
+		cleanup()
+		log.Fatal("Error getting build scripts").Err(err).Send()
+	}
+
+	opts.Script = scripts[0]
+	builtPkgs, _, err := build.BuildPackage(hctx, opts)
+	cleanup()
+	if err != nil {
+		log.Fatal("Error building package").Err(err).Send()
+	}
+
+	err = opts.Manager.InstallLocal(nil, builtPkgs...)
+	if err != nil {
+		log.Fatal("Error installing package").Err(err).Send()
+	}
+
+	editConfig(ctx, func(e *config.Editor) error {
+		return e.SetValue("pinVersion", pkg.Name, hv.FullVersion())
+	})
+
+	log.Info("Pinned package version. It won't be upgraded until it's unpinned using lure unpin.").Str("name", pkg.Name).Send()
+}